@@ -61,6 +61,7 @@ func printMetadataVerbose(metadata state.Metadata) {
 		siblingString,
 		"\tEvent Counter:", metadata.EventCounter, "\n",
 		"\tStorage Price:", metadata.StoragePrice, "\n",
+		"\tScript Price:", metadata.ScriptPrice, "\n",
 		"\tParent Block:", metadata.ParentBlock, "\n",
 		"\tHeight:", metadata.Height, "\n",
 		"\tRecent Snapshot:", metadata.RecentSnapshot, "\n",
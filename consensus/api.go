@@ -1,18 +1,49 @@
 package consensus
 
 import (
+	"bytes"
 	"time"
 
 	"github.com/NebulousLabs/Sia/delta"
 	"github.com/NebulousLabs/Sia/state"
 )
 
-// AddScriptInput is an RPC that appends a script input to
-// Participant.scriptInputs.
+// AddScriptInput is an RPC that adds a script input to
+// Participant.scriptInputs, which is a bounded queue ordered by deadline. An
+// input that has expired, or that has a later deadline than everything in a
+// full queue, is rejected.
+//
+// An input with a fee is dry run first, and rejected unless its script exits
+// successfully. A fee is only paid by a script that accepts its input, so an
+// input that the script would refuse could otherwise win a place in a block
+// with a fee that it never pays, and push out the inputs that do pay.
 func (p *Participant) AddScriptInput(si state.ScriptInput, _ *struct{}) (err error) {
+	if si.Fee != (state.Balance{}) {
+		var trace delta.ScriptTrace
+		trace, err = p.engine.DryRun(si, p.engineLock.RLocker())
+		if err != nil {
+			return
+		}
+		if trace.Err != "" {
+			err = errUnpaidScriptInput
+			return
+		}
+	}
+
+	p.engineLock.RLock()
+	height := p.engine.Metadata().Height
+	p.engineLock.RUnlock()
+
 	p.updatesLock.Lock()
-	p.scriptInputs = append(p.scriptInputs, si)
+	var dropped *state.ScriptInput
+	p.scriptInputs, dropped, err = queueScriptInput(p.scriptInputs, si, height)
 	p.updatesLock.Unlock()
+	if err == errScriptQueueFull {
+		p.log.Warn("rejected script input for wallet", si.WalletID, "with deadline", si.Deadline, "- queue is full")
+	}
+	if dropped != nil {
+		p.log.Warn("dropped script input for wallet", dropped.WalletID, "with deadline", dropped.Deadline, "- queue is full")
+	}
 	return
 }
 
@@ -57,6 +88,64 @@ func (p *Participant) DownloadSegment(id state.WalletID, segment *[]byte) (err e
 	return
 }
 
+// RepairDataRequest asks a sibling for the data that it contributes to the
+// repair of the segment held by the sibling at index 'Lost'.
+type RepairDataRequest struct {
+	WalletID state.WalletID
+	Lost     byte
+}
+
+// DownloadRepairData is an RPC that returns the repair data computed from the
+// participant's segment of a wallet, for sectors that use the regenerating
+// code.
+func (p *Participant) DownloadRepairData(rdr RepairDataRequest, data *[]byte) (err error) {
+	p.engineLock.RLock()
+	w, err := p.engine.Wallet(rdr.WalletID)
+	if err != nil {
+		p.engineLock.RUnlock()
+		return
+	}
+	selfIndex := p.engine.SiblingIndex()
+	segment, err := p.engine.DownloadSector(rdr.WalletID)
+	p.engineLock.RUnlock()
+	if err != nil {
+		return
+	}
+
+	if !w.Sector.Regenerating() {
+		err = errNotRegenerating
+		return
+	}
+	if selfIndex >= state.QuorumSize {
+		err = errNotSibling
+		return
+	}
+
+	// Refuse to help with a segment that doesn't match the hash set, so
+	// that a corrupted segment doesn't spoil the repair.
+	hash, err := state.MerkleCollapse(bytes.NewReader(segment), w.Sector.Atoms)
+	if err != nil {
+		return
+	}
+	if hash != w.Sector.HashSet[selfIndex] {
+		err = errCorruptSegment
+		return
+	}
+
+	*data, err = state.RepairData(segment, selfIndex, rdr.Lost)
+	return
+}
+
+// DryRun is an RPC that runs a script input against the participant's current
+// state, and returns a trace of every instruction that the script ran. None
+// of the changes made by the script are kept, and nothing is charged. The
+// engine is only locked while the script reads from it, so that a dry run
+// doesn't hold up the participant.
+func (p *Participant) DryRun(si state.ScriptInput, trace *delta.ScriptTrace) (err error) {
+	*trace, err = p.engine.DryRun(si, p.engineLock.RLocker())
+	return
+}
+
 // Metadata is an RPC that returns the current state metadata.
 func (p *Participant) Metadata(_ struct{}, smd *state.Metadata) (err error) {
 	p.engineLock.RLock()
@@ -65,6 +154,21 @@ func (p *Participant) Metadata(_ struct{}, smd *state.Metadata) (err error) {
 	return
 }
 
+// RepairStatusStruct lists the repairs in the participant's repair queue.
+// Failed repairs are still retried, but have failed too many times to be
+// considered pending.
+type RepairStatusStruct struct {
+	Pending []state.Repair
+	Failed  []state.Repair
+}
+
+// RepairStatus is an RPC that returns the pending and failed repairs of the
+// participant.
+func (p *Participant) RepairStatus(_ struct{}, rs *RepairStatusStruct) (err error) {
+	rs.Pending, rs.Failed = p.engine.RepairQueue().Status()
+	return
+}
+
 // UploadSegment accepts a SegmentUpload contianing a wallet id, an update
 // index, and a new segment. This is processed by the engine. If the
 // segmentupload is accepted, then an update advancement is added to be sent to
@@ -13,80 +13,167 @@ import (
 
 /*
 The Bootstrapping Process
-1. The new sibling announces its intent to the quorum.
-2. The quorum includes the sibling as a "hopeful" in the next heartbeat.
-3. During compile, the quorum decides whether or not to add the hopeful, and where.
-4. If accepted, the hopeful downloads the current quorum state.
-5. The current quorum siblings add the new participant, along with the default heartbeat.
-6. The hopeful listens to the quorum and processes any incoming heartbeats.
-7. After the next compile, the hopeful becomes a full sibling.
+1. The new sibling announces its intent to the quorum by submitting a join
+   request, signed by its tether wallet.
+2. When the join request is compiled, the sibling is put on the quorum's list
+   of hopefuls.
+3. At the end of the same compile, the quorum places the hopeful into an empty
+   sibling slot. The slot is picked using the germ, so every sibling makes the
+   same choice. The new sibling is passive for SiblingPassiveWindow blocks.
+4. The new sibling sees itself in the metadata and downloads the current quorum
+   state, cross-checking the snapshot against several siblings.
+5. The new sibling starts ticking, and listens to the quorum while passive.
+6. In the first block where the new sibling is active (the handoff block), the
+   quorum uses a default heartbeat for the sibling if its heartbeat is missing.
+7. After the handoff block, the new sibling is a full sibling.
+
+
+[- Interim 0 -]       [-- Compile --]       [- Interim 1 -]       [- Compiles --]       [-- Compile --]       [- Interim N -]
+[   hopeful   ]       [   hopeful   ]       [ hopeful gets]       [   passive   ]       [ default hb  ]       [   hopeful   ]
+[  announces  ]  -->  [  added and  ]  -->  [  state and  ]  -->  [   window    ]  -->  [  used for   ]  -->  [  now fully  ]
+[   intent    ]       [   placed    ]       [ begins tick ]       [   elapses   ]       [   handoff   ]       [  integrated ]
+[-------------]       [-------------]       [-------------]       [-------------]       [-------------]       [-------------]
 
+*/
 
-[- Interim 0 -]       [-- Compile --]       [- Interim 1 -]       [-- Compile --]       [- Interim 2 -]       [-- Compile --]       [- Interim 3 -]       [-- Compile --]
-[   hopeful   ]       [             ]       [   hopeful   ]       [   quorum    ]       [ hopeful gets]       [ default hb  ]       [   hopeful   ]       [             ]
-[  announces  ]  -->  [             ]  -->  [  added to   ]  -->  [ decides to  ]  -->  [  state and  ]  -->  [  used for   ]  -->  [  now fully  ]  -->  [             ]
-[   intent    ]       [             ]       [  heartbeat  ]       [ add hopeful ]       [  heartbeats ]       [   compile   ]       [  integrated ]       [             ]
-[-------------]       [-------------]       [-------------]       [-------------]       [-------------]       [-------------]       [-------------]       [-------------]
+const (
+	// joinRequestWindow is the number of blocks that a join request has to
+	// make it into a block before it expires.
+	joinRequestWindow = 2
+)
 
-*/
+var (
+	errHandoffFailed    = errors.New("the participant was removed from the quorum before the handoff completed")
+	errJoinRejected     = errors.New("the quorum did not accept the join request")
+	errNoQuorumSiblings = errors.New("none of the quorum siblings could be reached")
+)
 
-// A helper function for CreateJoiningParticipant, that downloads the next
-// block and compiles it into the participant. fetchAndCompileNextBlock
-// requires the engine mutex to be locked.
-func (p *Participant) fetchAndCompileNextBlock(quorumSiblings []network.Address) (err error) {
-	var b delta.Block
-	err = p.router.SendMessage(network.Message{
-		Dest: quorumSiblings[0],
-		Proc: "Participant.Block",
-		Args: p.engine.Metadata().Height,
-		Resp: &b,
-	})
-	if err != nil {
-		return
+// synchronize downloads and compiles blocks until the engine reaches 'height',
+// moving on to the next source whenever a source fails. Like fetchBlock,
+// synchronize only locks the engine mutex while it reads from or compiles into
+// the engine, so the caller must not hold it.
+func (p *Participant) synchronize(sources []network.Address, height uint32) (err error) {
+	err = errNoRecoverySource
+	for _, source := range sources {
+		err = nil
+		for p.metadata().Height < height && err == nil {
+			err = p.fetchBlock(source)
+		}
+		if err == nil {
+			return
+		}
+		p.log.Debug("could not synchronize using", source, "-", err)
 	}
-
-	p.engine.Compile(b)
 	return
 }
 
+// awaitPlacement polls the quorum until the participant has been placed into
+// a sibling slot, returning the metadata that contains the placement. An
+// error is returned if the join request expires without the participant ever
+// making it onto the hopeful list, or if the participant is dropped from the
+// hopeful list.
+//
+// If the participant was recruited as the successor of a departing sibling,
+// the address of the departing sibling is returned as 'predecessor', so that
+// the segments can be transferred from it directly.
+func (p *Participant) awaitPlacement(quorumSiblings []network.Address, deadline uint32) (metadata state.Metadata, predecessor network.Address, err error) {
+	var failures int
+	for {
+		time.Sleep(StepDuration)
+
+		// Get the metadata from the first sibling that responds.
+		err = errNoQuorumSiblings
+		for _, address := range quorumSiblings {
+			err = p.router.SendMessage(network.Message{
+				Dest: address,
+				Proc: "Participant.Metadata",
+				Args: struct{}{},
+				Resp: &metadata,
+			})
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			failures++
+			if failures > int(NumSteps)*joinRequestWindow {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		// Check whether the participant has been placed.
+		for _, sibling := range metadata.Siblings {
+			if !sibling.Inactive() && sibling.PublicKey == p.publicKey {
+				return
+			}
+		}
+
+		// Check whether the participant is succeeding a departing
+		// sibling.
+		var hopeful bool
+		for i, h := range metadata.Successors {
+			if !h.Empty() && h.Sibling.PublicKey == p.publicKey {
+				predecessor = metadata.Siblings[i].Address
+				hopeful = true
+			}
+		}
+
+		// Once the join request has expired, the participant must be
+		// on the hopeful list.
+		if metadata.Height > deadline {
+			for _, h := range metadata.Hopefuls {
+				if !h.Empty() && h.Sibling.PublicKey == p.publicKey {
+					hopeful = true
+				}
+			}
+			if !hopeful {
+				err = errJoinRejected
+				return
+			}
+		}
+	}
+}
+
 var (
 	errNilMessageRouter = errors.New("cannot create a participant with a nil message router")
 )
 
 // NewParticipant initializes a Participant object with the provided
-// MessageRouter and filePrefix. It also creates a keypair and sets default
-// values for the siblingIndex and currentStep.
-func newParticipant(rpcs *network.RPCServer, filePrefix string) (p *Participant, err error) {
+// MessageRouter, filePrefix and identity, and sets default values for the
+// siblingIndex and currentStep. The background work of the participant is not
+// started until startBackground is called, once the participant is set up.
+func newParticipant(rpcs *network.RPCServer, filePrefix string, id identity) (p *Participant, err error) {
 	if rpcs == nil {
 		err = errNilMessageRouter
 		return
 	}
 
 	p = new(Participant)
-
-	// Create a keypair for the participant.
-	p.publicKey, p.secretKey, err = siacrypto.CreateKeyPair()
-	if err != nil {
-		return
-	}
-	p.engine.SetSiblingIndex(^byte(0))
+	p.closed = make(chan struct{})
+	p.publicKey = id.PublicKey
+	p.secretKey = id.SecretKey
 
 	// Create the update maps.
 	for i := range p.updates {
 		p.updates[i] = make(map[siacrypto.Hash]Update)
 	}
 
-	// Initialize the network components of the participant.
+	// Initialize the network components of the participant, proving the
+	// participant's public key to the peers that it connects with, and
+	// limiting which peers can call it and what they can send it.
 	p.address = rpcs.RegisterHandler(p)
 	p.router = rpcs
+	p.router.SetIdentity(p.address.ID, p.publicKey, p.secretKey)
+	p.router.SetAuthorizer(p.address.ID, p.authorize)
+	setLimits(p.router)
 
 	// Initialize the logger and file prefix
 	p.log = sialog.Default // TODO: figure out logger initialization
 	p.engine.Initialize(p.log, filePrefix)
-	p.setSiblingIndex(p.engine.SiblingIndex())
-
-	// Set up a listener for segment repairs.
-	go p.recoveryListen()
+	p.setSiblingIndex(^byte(0))
+	p.scrubRate = DefaultScrubRate
 
 	// Write-lock the updateStop to stop updates until the participant
 	// starts ticking.
@@ -95,6 +182,14 @@ func newParticipant(rpcs *network.RPCServer, filePrefix string) (p *Participant,
 	return
 }
 
+// startBackground starts working through the repair queue, and starts the
+// scrubber that feeds it. It is called once the participant has its identity
+// and its engine is set up.
+func (p *Participant) startBackground() {
+	go p.processRepairs()
+	go p.scrub()
+}
+
 // Sets the sibling index for the participant and engine, should be called once
 // the sibling index is discovered.
 func (p *Participant) setSiblingIndex(siblingIndex byte) {
@@ -103,7 +198,11 @@ func (p *Participant) setSiblingIndex(siblingIndex byte) {
 
 // CreateBootstrapParticipant returns a participant that is participating as
 // the first and only sibling on a new quorum.
-func CreateBootstrapParticipant(rpcs *network.RPCServer, filePrefix string, bootstrapTetherWallet state.WalletID, tetherWalletPublicKey siacrypto.PublicKey) (p *Participant, err error) {
+//
+// The participant's keypair is saved under the file prefix, encrypted with
+// the passphrase, so that the participant can later be restarted with
+// LoadParticipant.
+func CreateBootstrapParticipant(rpcs *network.RPCServer, filePrefix string, passphrase string, bootstrapTetherWallet state.WalletID, tetherWalletPublicKey siacrypto.PublicKey) (p *Participant, err error) {
 	// ID 0 is reserved for the early-distribution 'fountain' wallet. The
 	// full netowrk is not likely to have this, but it makes test-network
 	// actions a lot simpler.
@@ -113,7 +212,15 @@ func CreateBootstrapParticipant(rpcs *network.RPCServer, filePrefix string, boot
 	}
 
 	// Create basic participant.
-	p, err = newParticipant(rpcs, filePrefix)
+	id, err := createIdentity()
+	if err != nil {
+		return
+	}
+	p, err = newParticipant(rpcs, filePrefix, id)
+	if err != nil {
+		return
+	}
+	err = p.saveIdentity(filePrefix, passphrase)
 	if err != nil {
 		return
 	}
@@ -125,189 +232,74 @@ func CreateBootstrapParticipant(rpcs *network.RPCServer, filePrefix string, boot
 		PublicKey: p.publicKey,
 		WalletID:  bootstrapTetherWallet,
 	}
+	p.engineLock.Lock()
 	err = p.engine.Bootstrap(bootstrapSibling, tetherWalletPublicKey)
+	if err == nil {
+		p.setSiblingIndex(0)
+	}
+	p.engineLock.Unlock()
 	if err != nil {
 		return
 	}
-	p.setSiblingIndex(0)
 
 	// Create the first update.
 	p.newSignedUpdate()
 
 	// Run the first compile, this will create a snapshot.
 	block := p.condenseBlock()
+	p.engineLock.Lock()
 	err = p.engine.Compile(block)
+	p.expectSiblings()
+	p.engineLock.Unlock()
 	if err != nil {
 		return
 	}
 	p.newSignedUpdate()
 
 	// Begin ticking.
+	p.startBackground()
 	go p.tick()
 
 	return
 }
 
-// CreateJoiningParticipant creates a new participant and integrates it as a
-// host with an existing quorum. It is assumed that the tetherID is an ID to a
-// generic wallet, and that the secret key is the key that should be the key
-// that is assiciated with the public key of the generic wallet.
-func CreateJoiningParticipant(rpcs *network.RPCServer, filePrefix string, tetherID state.WalletID, tetherWalletSecretKey siacrypto.SecretKey, quorumSiblings []network.Address) (p *Participant, err error) {
-	// Create a new, basic participant.
-	p, err = newParticipant(rpcs, filePrefix)
-	if err != nil {
-		return
-	}
-
-	// An important step that is being omitted for this version of Sia
-	// (omitted until Sia has meta-quorums and network-discovery) is
-	// verifying the hashes of the snapshot and blocks before you actually
-	// attempt to acquire anything.
-
-	// There is an assumption that the input wallet exists on the quorum
-	// with a balance sufficient to cover the costs of creating the
-	// participant. This needs to be tested and verified.
-
-	// Download a snapshot, which will form the basis of synchronization.
-	{
-		// get height of the most recent snapshot
-		var metadata state.Metadata
-		err = rpcs.SendMessage(network.Message{
-			Dest: quorumSiblings[0],
-			Proc: "Participant.Metadata",
-			Args: struct{}{},
-			Resp: &metadata,
-		})
-		if err != nil {
-			return
-		}
-
-		// get the metadata from the snapshot
-		var snapshotMetadata state.Metadata
-		err = rpcs.SendMessage(network.Message{
-			Dest: quorumSiblings[0],
-			Proc: "Participant.SnapshotMetadata",
-			Args: metadata.RecentSnapshot,
-			Resp: &snapshotMetadata,
-		})
-		if err != nil {
-			return
-		}
-		p.engine.BootstrapSetMetadata(snapshotMetadata)
-
-		// get the list of wallets in the snapshot
-		var walletList []state.WalletID
-		err = rpcs.SendMessage(network.Message{
-			Dest: quorumSiblings[0],
-			Proc: "Participant.SnapshotWalletList",
-			Args: metadata.RecentSnapshot,
-			Resp: &walletList,
-		})
-		if err != nil {
+// awaitHandoff blocks until the participant's handoff block has been
+// compiled. An error is returned if the participant is no longer a sibling
+// once the handoff is complete.
+func (p *Participant) awaitHandoff() (err error) {
+	for {
+		p.engineLock.RLock()
+		metadata := p.engine.Metadata()
+		index := p.engine.SiblingIndex()
+		p.engineLock.RUnlock()
+		if index >= state.QuorumSize || metadata.Siblings[index].PublicKey != p.publicKey || metadata.Siblings[index].Inactive() {
+			err = errHandoffFailed
 			return
 		}
-
-		// get each wallet individually and insert them into the quorum
-		for _, walletID := range walletList {
-			swa := SnapshotWalletArg{
-				SnapshotHead: metadata.RecentSnapshot,
-				WalletID:     walletID,
-			}
-
-			var wallet state.Wallet
-			err = rpcs.SendMessage(network.Message{
-				Dest: quorumSiblings[0],
-				Proc: "Participant.SnapshotWallet",
-				Args: swa,
-				Resp: &wallet,
-			})
-			if err != nil {
-				return
-			}
-
-			err = p.engine.BootstrapInsertWallet(wallet)
-			if err != nil {
-				// ???, panic would be inappropriate
-			}
-		}
-
-		// Event downloading will be implemented later.
-	}
-
-	// At this point, saveBlock() in package delta is expecting the active
-	// history file to be available, but this file hasn't been created yet
-	// because the inital values for snapshot weren't established
-	// correctly. It's a bit of a hack and should be refactored at some
-	// point, but we've got to set those variables so that compile(),
-	// saveBlock(), and saveSnapshot() work as expected.
-	err = p.engine.BootstrapJoinSetup()
-	if err != nil {
-		return
-	}
-
-	// Download all of the blocks that have been processed since the
-	// snapshot, which will bring the quorum up to date, except for being
-	// behind in the current round of consensus.
-	{
-		// figure out which block height is the latest
-		var currentMetadata state.Metadata
-		err = rpcs.SendMessage(network.Message{
-			Dest: quorumSiblings[0],
-			Proc: "Participant.Metadata",
-			Args: struct{}{},
-			Resp: &currentMetadata,
-		})
-		if err != nil {
+		sibling := metadata.Siblings[index]
+		if sibling.Active() && metadata.Height > sibling.JoinHeight+state.SiblingPassiveWindow+1 {
 			return
 		}
-
-		for p.engine.Metadata().Height < currentMetadata.Height {
-			p.engineLock.Lock()
-			err = p.fetchAndCompileNextBlock(quorumSiblings)
-			p.engineLock.Unlock()
-			if err != nil {
-				return
-			}
-		}
+		time.Sleep(StepDuration)
 	}
+}
 
-	// Synchronize to the quorum (this implementation is non-cryptographic)
-	// and begin ticking.
-	//
-	// The method is a bit haphazard, but the goal is to insure that when
-	// ticking starts and updates come in, the quorum is guaranteed to be
-	// caught up. We do make certain (reasonable) assumptions about network
-	// speed.
-	//
-	// 1. Get the synchronization information of the current network,
-	// including the current height, the current step, and the progress
-	// throug the current step.
-	//
-	// 2. Submit the join request, waiting if the step is 0-2 because it's
-	// unclear whether the join request will make it into the current block
-	// with the low step numbers.
-	//
-	// 3. Download any blocks that are missing, catching up to the current
-	// quorum. At most 1 will be missing.
-	//
-	// 4. Wait for this block to finish (will not have join request), and
-	// the next block to finish (will have join request).
-	//
-	// 5. Begin ticking as soon as the current quorum hits step 0 after the
-	// block containint the join request. The participant can now start
-	// receiving SignedUpdates without synchronization issues.
-	//
-	// 6. Download the two blocks that we're missing. Updates will be held
-	// by the HandleSignedUpdate function until step 2 is reached, so these
-	// two blocks must be downloaded before step 2 is reached. The most
-	// recently completed block is not guaranteed to be available until
-	// step 1 is reached, so this must be taken into consideration.
-	//
-	// That's it! The rest of the code should maintain synchronization.
-	{
-		var cps ConsensusProgressStruct
-		err = rpcs.SendMessage(network.Message{
-			Dest: quorumSiblings[0],
+// catchUp synchronizes the participant to the quorum (this implementation is
+// non-cryptographic) and begins ticking.
+//
+// The goal is to start ticking at the exact moment that the quorum compiles a
+// block, so that the steps of the participant line up with the steps of the
+// quorum. Before sleeping until the next compile, all of the blocks that are
+// currently available are downloaded. If a compile happens while the blocks
+// are downloading, the process is repeated. Once ticking has started, the
+// block that the quorum compiled at that moment is downloaded as well. Updates
+// from the quorum will be held by HandleSignedUpdate until the block has been
+// compiled.
+func (p *Participant) catchUp(sources []network.Address) (err error) {
+	var cps ConsensusProgressStruct
+	for {
+		err = p.router.SendMessage(network.Message{
+			Dest: sources[0],
 			Proc: "Participant.ConsensusProgress",
 			Args: struct{}{},
 			Resp: &cps,
@@ -317,74 +309,110 @@ func CreateJoiningParticipant(rpcs *network.RPCServer, filePrefix string, tether
 		}
 		cpsReceived := time.Now()
 
-		// Don't submit the joinRequest unless the step is greater than
-		// 1. It creates uncertainty over which block the join request
-		// will be accepted in. This can be revisited later to remove
-		// this artificial constraint.
-		if cps.CurrentStep < 3 {
-			time.Sleep(StepDuration * time.Duration(3-cps.CurrentStep))
-		}
-
-		// Create the join request and send it to the quorum.
-		var joinRequest state.ScriptInput
-		inputSibling := state.Sibling{
-			Address:   p.address,
-			PublicKey: p.publicKey,
-		}
-		joinRequest, err = delta.AddSiblingInput(tetherID, cps.Height+2, inputSibling, tetherWalletSecretKey)
+		err = p.synchronize(sources, cps.Height)
+		p.engineLock.Lock()
+		p.expectSiblings()
+		p.engineLock.Unlock()
 		if err != nil {
 			return
 		}
-		for _, address := range quorumSiblings {
-			// Something should asynchronously log any errors
-			// returned.
-			rpcs.SendAsyncMessage(network.Message{
-				Dest: address,
-				Proc: "Participant.AddScriptInput",
-				Args: joinRequest,
-			})
-		}
 
-		// Download any blocks that are missing that are currently
-		// available.
-		for p.engine.Metadata().Height < cps.Height {
-			p.engineLock.Lock()
-			err = p.fetchAndCompileNextBlock(quorumSiblings)
-			p.engineLock.Unlock()
-			if err != nil {
-				return
-			}
+		sleepDuration := time.Duration(NumSteps-cps.CurrentStep)*StepDuration - cps.CurrentStepProgress - time.Since(cpsReceived)
+		if sleepDuration > 0 {
+			time.Sleep(sleepDuration)
+			break
 		}
+	}
+	go p.tick()
 
-		// Wait for the current block to finish, and then for the next
-		// block to also finish, and begin ticking when the following
-		// block hits step 0.
-		sleepDuration := (time.Duration(NumSteps-cps.CurrentStep) * StepDuration) - time.Since(cpsReceived) + time.Duration(NumSteps)*StepDuration - cps.CurrentStepProgress
-		time.Sleep(sleepDuration)
-		go p.tick()
+	err = p.synchronize(sources, cps.Height+1)
+	return
+}
 
-		// Download the first missing block.
-		p.engineLock.Lock()
-		err = p.fetchAndCompileNextBlock(quorumSiblings)
-		p.engineLock.Unlock()
-		if err != nil {
-			return
-		}
+// CreateJoiningParticipant creates a new participant and integrates it as a
+// host with an existing quorum. It is assumed that the tetherID is an ID to a
+// generic wallet, and that the secret key is the key that should be the key
+// that is assiciated with the public key of the generic wallet.
+//
+// CreateJoiningParticipant follows the bootstrapping process described at the
+// top of this file, and does not return until the participant has recovered
+// its segments and is a full sibling of the quorum. Like a bootstrap
+// participant, the joining participant saves its keypair encrypted with the
+// passphrase.
+func CreateJoiningParticipant(rpcs *network.RPCServer, filePrefix string, passphrase string, tetherID state.WalletID, tetherWalletSecretKey siacrypto.SecretKey, quorumSiblings []network.Address) (p *Participant, err error) {
+	// Create a new, basic participant.
+	id, err := createIdentity()
+	if err != nil {
+		return
+	}
+	p, err = newParticipant(rpcs, filePrefix, id)
+	if err != nil {
+		return
+	}
+	err = p.saveIdentity(filePrefix, passphrase)
+	if err != nil {
+		return
+	}
 
-		// Sleep another step so that the second block becomes
-		// available.
-		time.Sleep(StepDuration)
+	// There is an assumption that the input wallet exists on the quorum
+	// with a balance sufficient to cover the costs of creating the
+	// participant. This needs to be tested and verified.
 
-		// Download second missing block.
-		p.engineLock.Lock()
-		err = p.fetchAndCompileNextBlock(quorumSiblings)
-		p.engineLock.Unlock()
-		if err != nil {
-			return
+	// Announce intent to the quorum by submitting a join request to every
+	// known sibling.
+	var cps ConsensusProgressStruct
+	err = rpcs.SendMessage(network.Message{
+		Dest: quorumSiblings[0],
+		Proc: "Participant.ConsensusProgress",
+		Args: struct{}{},
+		Resp: &cps,
+	})
+	if err != nil {
+		return
+	}
+	deadline := cps.Height + joinRequestWindow
+	inputSibling := state.Sibling{
+		Address:   p.address,
+		PublicKey: p.publicKey,
+	}
+	joinRequest, err := delta.AddSiblingInput(tetherID, deadline, inputSibling, tetherWalletSecretKey)
+	if err != nil {
+		return
+	}
+	for _, address := range quorumSiblings {
+		// Something should asynchronously log any errors
+		// returned.
+		rpcs.SendAsyncMessage(network.Message{
+			Dest: address,
+			Proc: "Participant.AddScriptInput",
+			Args: joinRequest,
+		})
+	}
+
+	// Wait for the quorum to place the participant.
+	metadata, predecessor, err := p.awaitPlacement(quorumSiblings, deadline)
+	if err != nil {
+		return
+	}
+
+	// Download the state from all of the other siblings.
+	var sources []network.Address
+	for _, sibling := range metadata.Siblings {
+		if !sibling.Inactive() && sibling.PublicKey != p.publicKey {
+			sources = append(sources, sibling.Address)
 		}
 	}
+	err = p.downloadSnapshot(sources, metadata.RecentSnapshot)
+	if err != nil {
+		return
+	}
 
-	// Parse the metadata and figure out which sibling is ourselves.
+	// Synchronize to the quorum and begin ticking, then figure out which
+	// sibling is ourselves.
+	err = p.catchUp(sources)
+	if err != nil {
+		return
+	}
 	p.engineLock.Lock()
 	for i, sibling := range p.engine.Metadata().Siblings {
 		if sibling.Address == p.address && sibling.PublicKey == p.publicKey {
@@ -393,19 +421,17 @@ func CreateJoiningParticipant(rpcs *network.RPCServer, filePrefix string, tether
 		}
 	}
 	p.engineLock.Unlock()
+	p.newSignedUpdate()
+	p.startBackground()
 
-	// Download all files that are missing.
-	p.engineLock.RLock()
-	walletList := p.engine.WalletList()
-	p.engineLock.RUnlock()
-	for _, wallet := range walletList {
-		// Function should not return until this has finished, but this
-		// can be parallelized.
-		err2 := p.recoverSegment(wallet)
-		if err2 != nil {
-			//fmt.Println(err2)
-		}
-	}
+	// Download all segments that are missing while the participant is
+	// still passive, so that it can build storage proofs once it is
+	// active. A successor gets the segments straight from the sibling that
+	// it replaced.
+	p.rebuildSegments(predecessor)
 
+	// Wait until the handoff block has been compiled, at which point the
+	// participant is a full sibling.
+	err = p.awaitHandoff()
 	return
 }
@@ -110,15 +110,27 @@ func (p *Participant) condenseBlock() (b delta.Block) {
 			p.updates[i] = make(map[siacrypto.Hash]Update)
 		}
 
-		// Sort the scriptInputMap and include the scriptInputs into the block in
-		// sorted order.
+		// Sort the scriptInputMap, and include the scriptInputs that fit into
+		// the cost budget of the block by fee density. Inputs with the same
+		// fee density stay in sorted order. The inputs that don't fit are
+		// queued again, to be sent in the next heartbeat until their
+		// deadline passes. Every sibling queues the same inputs, and the
+		// copies are merged by the script input map.
 		var sortedKeys []string
 		for k := range scriptInputMap {
 			sortedKeys = append(sortedKeys, k)
 		}
 		sort.Strings(sortedKeys)
+		var scriptInputs []state.ScriptInput
 		for _, k := range sortedKeys {
-			b.ScriptInputs = append(b.ScriptInputs, scriptInputMap[k])
+			scriptInputs = append(scriptInputs, scriptInputMap[k])
+		}
+		var deferred []state.ScriptInput
+		p.engineLock.RLock()
+		b.ScriptInputs, deferred = p.engine.SelectScriptInputs(scriptInputs)
+		p.engineLock.RUnlock()
+		for _, si := range deferred {
+			p.scriptInputs, _, _ = queueScriptInput(p.scriptInputs, si, b.Height+1)
 		}
 
 		// Sort the updateAdvancementMap and include the advancements into the
@@ -150,17 +162,49 @@ func (p *Participant) newSignedUpdate() {
 	var entropy state.Entropy
 	copy(entropy[:], siacrypto.RandomByteSlice(state.EntropyVolume))
 
-	sp, err := p.engine.BuildStorageProof()
-	if err == state.ErrEmptyQuorum {
-		p.log.Debug("could not build storage proof:", err)
-	} else if err != nil {
-		p.log.Error(sialog.AddCtx(err, "failed to construct storage proof"))
-		return
-	}
+	// A sibling that is rebuilding its segments reports its progress in
+	// place of a storage proof, until it has rebuilt every segment and
+	// proves it.
 	hb := delta.Heartbeat{
-		ParentBlock:  p.engine.Metadata().ParentBlock,
-		Entropy:      entropy,
-		StorageProof: sp,
+		ParentBlock: p.engine.Metadata().ParentBlock,
+		Entropy:     entropy,
+	}
+	rebuilding := p.engine.Metadata().Rebuilding(p.engine.SiblingIndex())
+	if rebuilding {
+		p.rebuildLock.Lock()
+		hb.Rebuild = p.rebuild
+		p.rebuildLock.Unlock()
+	}
+	if !rebuilding || hb.Rebuild.Complete {
+		sp, err := p.engine.BuildStorageProof()
+		if err == state.ErrEmptyQuorum {
+			p.log.Debug("could not build storage proof:", err)
+		} else if err != nil {
+			p.log.Error(sialog.AddCtx(err, "failed to construct storage proof"))
+			return
+		}
+		hb.StorageProof = sp
+	}
+
+	// If the quorum has the participant at an old address, such as an
+	// address from before a restart or from before the participant's IP
+	// changed, announce the current address.
+	index := p.engine.SiblingIndex()
+	if address := p.currentAddress(); p.engine.Metadata().Siblings[index].Address != address {
+		au := state.AddressUpdate{
+			SiblingIndex: index,
+			Address:      address,
+			Height:       p.engine.Metadata().Height,
+		}
+		auSignature, err := p.secretKey.SignObject(au)
+		if err != nil {
+			p.log.Error("failed to sign address update:", err)
+		} else {
+			hb.AddressUpdate = &state.SignedAddressUpdate{
+				AddressUpdate: au,
+				Signature:     auSignature,
+			}
+		}
 	}
 
 	signature, err := p.secretKey.SignObject(hb)
@@ -264,6 +308,13 @@ func (p *Participant) HandleSignedUpdate(su SignedUpdate, _ *struct{}) (err erro
 		return
 	}
 
+	// Check that the update doesn't carry more script inputs than a
+	// sibling can queue.
+	if len(su.Update.ScriptInputs) > MaxPendingScriptInputs {
+		err = errTooManyScriptInputs
+		return
+	}
+
 	// Check that the update is not late.
 	p.tickLock.RLock()
 	p.engineLock.RLock()
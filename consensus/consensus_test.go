@@ -1,6 +1,9 @@
 package consensus
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
@@ -20,6 +23,14 @@ func TestConsensus(t *testing.T) {
 		t.Skip()
 	}
 
+	// The deadlines leave enough time for all of the participants to
+	// join before the script is forgotten and the sector update is
+	// applied.
+	const (
+		scriptDeadline = 16
+		sectorDeadline = 17
+	)
+
 	// Create a keypair for the tether wallet.
 	tetherWalletPK, tetherWalletSK, err := siacrypto.CreateKeyPair()
 	if err != nil {
@@ -32,7 +43,7 @@ func TestConsensus(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestConsensus-Start"), tetherWalletID, tetherWalletPK)
+	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestConsensus-Start"), "", tetherWalletID, tetherWalletPK)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -49,7 +60,7 @@ func TestConsensus(t *testing.T) {
 	// snapshot without the script input and event, but the later joining
 	// participants will get snapshots that have the event.
 	si := state.ScriptInput{
-		Deadline: 6,
+		Deadline: scriptDeadline,
 		WalletID: delta.FountainWalletID,
 		Input:    delta.CreateFountainWalletInput(2, delta.DefaultScript(tetherWalletPK)),
 	}
@@ -58,17 +69,32 @@ func TestConsensus(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Submit a sector update to the tether wallet.
+	// Submit a sector update to the tether wallet. The segments are
+	// uploaded to the siblings while the later participants are joining.
+	// The sector uses the regenerating code, so that the repair at the end
+	// of the test rebuilds the segment from the repair data of 3 siblings.
+	sectorData := siacrypto.RandomByteSlice(12 * state.AtomSize)
+	segments := make([][]byte, state.QuorumSize)
+	atoms, err := state.EncodeSector(bytes.NewReader(sectorData), segments, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
 	su := state.SectorUpdate{
-		Atoms: 6,
-		K:     1,
-		D:     1,
+		Atoms: atoms,
+		K:     2,
+		D:     3,
 		ConfirmationsRequired: 3,
 	}
-	su.Event.Deadline = 7
+	for i, segment := range segments {
+		su.HashSet[i], err = state.MerkleCollapse(bytes.NewReader(segment), atoms)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	su.Event.Deadline = sectorDeadline
 
 	si = state.ScriptInput{
-		Deadline: 6,
+		Deadline: scriptDeadline,
 		Input:    delta.UpdateSectorInput(su),
 		WalletID: 1,
 	}
@@ -96,7 +122,7 @@ func TestConsensus(t *testing.T) {
 		}
 		quorumSiblingAddresses = append(quorumSiblingAddresses, sibling.Address)
 	}
-	joiningParticipant, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join1"), tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
+	joiningParticipant, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join1"), "", tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -123,19 +149,24 @@ func TestConsensus(t *testing.T) {
 	joiningParticipant.tickLock.RUnlock()
 
 	// CreateJoiningParticipant won't return until it has fully integrated.
-	// Test that the integration was successful.
-	p.engineLock.RLock()
-	if p.engine.Metadata().Siblings[0].Inactive() || p.engine.Metadata().Siblings[1].Inactive() {
-		t.Error("Initial participant is not recognizing both siblings as active.")
-	}
-	p.engineLock.RUnlock()
-
+	// Test that the integration was successful. The joining participant
+	// can be placed into any empty slot.
 	joiningParticipant.engineLock.RLock()
-	if joiningParticipant.engine.Metadata().Siblings[0].Inactive() || joiningParticipant.engine.Metadata().Siblings[1].Inactive() {
+	joinIndex := joiningParticipant.engine.SiblingIndex()
+	if joinIndex == 0 || joinIndex >= state.QuorumSize {
+		t.Fatal("Joined participant was given a bad sibling index:", joinIndex)
+	}
+	if joiningParticipant.engine.Metadata().Siblings[0].Inactive() || joiningParticipant.engine.Metadata().Siblings[joinIndex].Inactive() {
 		t.Error("Joined participant is not recognizing both siblings as active.")
 	}
 	joiningParticipant.engineLock.RUnlock()
 
+	p.engineLock.RLock()
+	if p.engine.Metadata().Siblings[0].Inactive() || p.engine.Metadata().Siblings[joinIndex].Inactive() {
+		t.Error("Initial participant is not recognizing both siblings as active.")
+	}
+	p.engineLock.RUnlock()
+
 	// See that the snapshots are properly synchronized.
 	p.engineLock.RLock()
 	joiningParticipant.engineLock.RLock()
@@ -148,25 +179,54 @@ func TestConsensus(t *testing.T) {
 	joiningParticipant.engineLock.RUnlock()
 	p.engineLock.RUnlock()
 
+	// uploadSegment uploads the segment belonging to a participant,
+	// checking that the upload is accepted. The upload is sent from the
+	// tether wallet's key, which owns the sector.
+	uploader := mr.RegisterIdentity(tetherWalletPK, tetherWalletSK)
+	uploadSegment := func(participant *Participant) {
+		participant.engineLock.RLock()
+		index := participant.engine.SiblingIndex()
+		participant.engineLock.RUnlock()
+
+		var accepted bool
+		err := mr.SendMessage(network.Message{
+			Dest:   participant.address,
+			Source: uploader,
+			Proc:   "Participant.UploadSegment",
+			Args: delta.SegmentUpload{
+				WalletID:    tetherWalletID,
+				UpdateIndex: 0,
+				NewSegment:  segments[index],
+			},
+			Resp: &accepted,
+		})
+		if err != nil || !accepted {
+			t.Error("segment upload to sibling", index, "failed:", err)
+		}
+	}
+
 	// Add 2 more participants simultaneously and see if everything is
 	// stable upon completion. The mutexing is so that non-parallel
 	// functions can run in parallel, while the program still has to wait
-	// for both to finish.
+	// for both to finish. While the participants are joining, the
+	// existing siblings receive their segments of the sector update.
 	joinChan := make(chan *Participant)
 	go func() {
-		p, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join2"), tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
+		p, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join2"), "", tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
 		if err != nil {
 			t.Fatal(err)
 		}
 		joinChan <- p
 	}()
 	go func() {
-		p, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join3"), tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
+		p, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join3"), "", tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
 		if err != nil {
 			t.Fatal(err)
 		}
 		joinChan <- p
 	}()
+	uploadSegment(p)
+	uploadSegment(joiningParticipant)
 	join2, join3 := <-joinChan, <-joinChan
 
 	// At this point, there should be a full quorum, where each participant
@@ -182,17 +242,9 @@ func TestConsensus(t *testing.T) {
 		participant.engineLock.RUnlock()
 	}
 
-	// Have 3 participants submit an update advancement.
-	for _, participant := range []*Participant{p, joiningParticipant, join3} {
-		advancement := state.UpdateAdvancement{
-			SiblingIndex: participant.engine.SiblingIndex(),
-			WalletID:     tetherWalletID,
-			UpdateIndex:  0,
-		}
-		participant.updatesLock.Lock()
-		participant.updateAdvancements = append(participant.updateAdvancements, advancement)
-		participant.updatesLock.Unlock()
-	}
+	// Upload the remaining segments now that every sibling has joined.
+	uploadSegment(join2)
+	uploadSegment(join3)
 
 	// Check that all participants have the script that was submitted
 	// earlier.
@@ -208,8 +260,16 @@ func TestConsensus(t *testing.T) {
 		}
 	}
 
-	// Wait through four full blocks and try again.
-	time.Sleep(StepDuration * time.Duration(NumSteps) * 4)
+	// Wait until the sector update has gone through and try again.
+	for {
+		p.engineLock.RLock()
+		height := p.engine.Metadata().Height
+		p.engineLock.RUnlock()
+		if height > sectorDeadline+1 {
+			break
+		}
+		time.Sleep(StepDuration)
+	}
 	for i, participant := range []*Participant{p, joiningParticipant, join2, join3} {
 		participant.engineLock.RLock()
 		for j := 0; j < 4; j++ {
@@ -247,6 +307,104 @@ func TestConsensus(t *testing.T) {
 			t.Error("sibling of index", i, "did not forget the script.")
 		}
 	}
+
+	// Have join3 leave the quorum while a new participant waits on the
+	// hopeful list. The new participant should be recruited as the
+	// successor, take over join3's slot, and get join3's segment of the
+	// sector.
+	join3Index := join3.engine.SiblingIndex()
+	joinErr := make(chan error, 1)
+	go func() {
+		successor, err := CreateJoiningParticipant(mr, siafiles.TempFilename("TestConsensus-Join4"), "", tetherWalletID, tetherWalletSK, quorumSiblingAddresses)
+		if err != nil {
+			joinErr <- err
+			return
+		}
+		joinChan <- successor
+	}()
+	for {
+		select {
+		case err = <-joinErr:
+			t.Fatal(err)
+		default:
+		}
+		p.engineLock.RLock()
+		waiting := !p.engine.Metadata().Hopefuls[0].Empty()
+		p.engineLock.RUnlock()
+		if waiting {
+			break
+		}
+		time.Sleep(StepDuration)
+	}
+	err = join3.Depart(tetherWalletID, tetherWalletSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var join4 *Participant
+	select {
+	case join4 = <-joinChan:
+	case err = <-joinErr:
+		t.Fatal(err)
+	}
+
+	join4.engineLock.RLock()
+	if join4.engine.SiblingIndex() != join3Index {
+		t.Error("successor was placed into slot", join4.engine.SiblingIndex(), "instead of the departed sibling's slot", join3Index)
+	}
+	if !siafiles.Exists(join4.engine.SegmentFilename(tetherWalletID)) {
+		t.Error("successor did not receive the departed sibling's segment")
+	}
+	join4.engineLock.RUnlock()
+
+	join3.tickLock.RLock()
+	if !join3.departed {
+		t.Error("departed participant is still ticking")
+	}
+	join3.tickLock.RUnlock()
+
+	for i, participant := range []*Participant{p, joiningParticipant, join2, join4} {
+		participant.engineLock.RLock()
+		for j := 0; j < 4; j++ {
+			if participant.engine.Metadata().Siblings[j].Inactive() {
+				t.Error("Sibling recognized as inactive after the departure for iterators", i, ",", j)
+			}
+			if participant.engine.Metadata().Rebuilds[j].Pending {
+				t.Error("Sibling still rebuilding its segments for iterators", i, ",", j)
+			}
+		}
+		participant.engineLock.RUnlock()
+	}
+
+	// Corrupt the bootstrap participant's segment, and check that the
+	// scrubber finds the corruption and has the segment repaired.
+	p.engineLock.RLock()
+	segmentFilename := p.engine.SegmentFilename(tetherWalletID)
+	segment := segments[p.engine.SiblingIndex()]
+	p.engineLock.RUnlock()
+	corrupted := append([]byte(nil), segment...)
+	corrupted[0]++
+	file, err := os.Create(segmentFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(corrupted)
+	file.Close()
+	var ss ScrubStatusStruct
+	for i := 0; i < 20; i++ {
+		time.Sleep(StepDuration)
+		p.ScrubStatus(struct{}{}, &ss)
+		repaired, _ := ioutil.ReadFile(segmentFilename)
+		if ss.Corrupted != 0 && bytes.Equal(repaired, segment) {
+			break
+		}
+	}
+	if ss.Corrupted == 0 {
+		t.Error("scrubber did not find the corrupted segment")
+	}
+	repaired, err := ioutil.ReadFile(segmentFilename)
+	if err != nil || !bytes.Equal(repaired, segment) {
+		t.Error("corrupted segment was not repaired")
+	}
 }
 
 /*
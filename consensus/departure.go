@@ -0,0 +1,136 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/NebulousLabs/Sia/delta"
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Graceful Departure
+//
+// A sibling leaves the quorum when the wallet it is tethered to submits a
+// leave request. The sibling keeps participating for SiblingDepartureWindow
+// blocks while the quorum recruits a successor from the hopeful list. Once the
+// window is over, the sibling's collateral is refunded, the successor takes
+// over the slot, and the departed participant stops ticking. The departed
+// participant keeps answering RPCs, so the successor can download the
+// departed sibling's segments directly instead of running erasure recovery.
+
+var (
+	errNotSibling = errors.New("participant is not a sibling of the quorum")
+)
+
+// Depart asks the quorum to let the participant leave. The request is signed
+// with the secret key of the wallet that the participant is tethered to. Depart
+// returns once the request has been submitted; the participant stops ticking
+// after the departure window has passed.
+func (p *Participant) Depart(tetherID state.WalletID, tetherWalletSecretKey siacrypto.SecretKey) (err error) {
+	p.engineLock.RLock()
+	index := p.engine.SiblingIndex()
+	height := p.engine.Metadata().Height
+	p.engineLock.RUnlock()
+	if index >= state.QuorumSize {
+		err = errNotSibling
+		return
+	}
+
+	leaveRequest, err := delta.LeaveSiblingInput(tetherID, height+joinRequestWindow, index, tetherWalletSecretKey)
+	if err != nil {
+		return
+	}
+	err = p.AddScriptInput(leaveRequest, nil)
+	if err != nil {
+		return
+	}
+	p.broadcast(network.Message{
+		Proc: "Participant.AddScriptInput",
+		Args: leaveRequest,
+	})
+	return
+}
+
+// checkDeparture is called after each compile, and returns true if the
+// participant is no longer a sibling of the quorum. This happens when the
+// participant finishes departing, or when it gets tossed.
+func (p *Participant) checkDeparture() (departed bool) {
+	p.engineLock.Lock()
+	defer p.engineLock.Unlock()
+	index := p.engine.SiblingIndex()
+	if index >= state.QuorumSize {
+		return
+	}
+	sibling := p.engine.Metadata().Siblings[index]
+	if !sibling.Inactive() && sibling.PublicKey == p.publicKey {
+		return
+	}
+
+	p.log.Info("participant is no longer a sibling of the quorum, stopping at height", p.engine.Metadata().Height)
+	p.setSiblingIndex(^byte(0))
+	departed = true
+	return
+}
+
+// transferSegments downloads the participant's segment of each wallet from the
+// sibling that previously held the participant's slot, checking each segment
+// against the sector's hash set. The wallets whose segments could not be
+// transferred are returned, so that they can be recovered from the rest of
+// the quorum instead.
+func (p *Participant) transferSegments(predecessor network.Address, ids []state.WalletID) (remaining []state.WalletID) {
+	for _, id := range ids {
+		err := p.transferSegment(predecessor, id)
+		if err != nil && err != errNoSector {
+			p.log.Debug("could not transfer segment for wallet", id, "-", err)
+			remaining = append(remaining, id)
+			continue
+		}
+		p.segmentRebuilt()
+	}
+	return
+}
+
+// transferSegment downloads a single segment from the predecessor.
+func (p *Participant) transferSegment(predecessor network.Address, id state.WalletID) (err error) {
+	p.engineLock.RLock()
+	w, err := p.engine.Wallet(id)
+	index := p.engine.SiblingIndex()
+	p.engineLock.RUnlock()
+	if err != nil {
+		return
+	}
+	if w.Sector.Atoms == 0 {
+		err = errNoSector
+		return
+	}
+
+	var segment []byte
+	err = p.router.SendMessage(network.Message{
+		Dest: predecessor,
+		Proc: "Participant.DownloadSegment",
+		Args: id,
+		Resp: &segment,
+	})
+	if err != nil {
+		return
+	}
+	hash, err := state.MerkleCollapse(bytes.NewReader(segment), w.Sector.Atoms)
+	if err != nil {
+		return
+	}
+	if hash != w.Sector.HashSet[index] {
+		err = errors.New("transferred segment does not match the sector")
+		return
+	}
+
+	file, err := os.Create(p.engine.SegmentFilename(id))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = file.Write(segment)
+	return
+}
@@ -0,0 +1,344 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NebulousLabs/Sia/delta"
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Fork Detection and Recovery
+//
+// A participant that misses a heartbeat or compiles a block differently from
+// the rest of the quorum will end up with a different ParentBlock than the
+// other siblings. Once that happens, every heartbeat it submits builds on the
+// wrong parent. Compile demotes siblings that submit such heartbeats to
+// passive, which gives the forked participant SiblingPassiveWindow blocks to
+// notice and fix the problem.
+//
+// Partway through every block, each participant asks the other siblings for
+// their metadata and compares ParentBlocks. If a majority of the quorum
+// agrees on a parent that differs from the local parent, the participant
+// considers itself forked. At the next compile, instead of compiling its own
+// block, it rolls back to the most recent snapshot that it shares with the
+// quorum (downloading one if no local snapshot matches), and then downloads
+// and compiles every block from the snapshot onwards using the Block RPC.
+
+const (
+	// forkCheckStep is the step at which siblings compare parent blocks.
+	// By this step, every sibling should have finished compiling the
+	// previous block.
+	forkCheckStep = 2
+
+	// blockFetchTimeout is the amount of time that a recovering
+	// participant will keep retrying to download a block that the quorum
+	// has not finished compiling yet.
+	blockFetchTimeout = time.Duration(NumSteps) * StepDuration
+)
+
+var (
+	errNoRecoverySource = errors.New("no sibling could provide the blocks needed to recover from the fork")
+	errForkedBlock      = errors.New("downloaded block does not extend the current state")
+)
+
+// A ForkReport describes a fork that was detected by the participant, and
+// what was done to recover from it. The report is filled out as recovery
+// progresses and is logged at each stage.
+type ForkReport struct {
+	DetectedAt   time.Time
+	Height       uint32
+	LocalParent  siacrypto.Hash
+	QuorumParent siacrypto.Hash
+
+	// Sibling indices grouped by how they compared to the local parent.
+	// Siblings that could not be reached, or that reported a different
+	// height, are listed as unreachable.
+	Agreeing    []byte
+	Disagreeing []byte
+	Unreachable []byte
+
+	// Recovery information.
+	SnapshotHead     uint32
+	SnapshotSource   string
+	BlocksRecompiled int
+	Recovered        bool
+	Err              string
+
+	// sources contains the addresses of the siblings that agree with the
+	// quorum parent.
+	sources []network.Address
+}
+
+// String returns the report formatted for the log.
+func (fr *ForkReport) String() string {
+	return fmt.Sprintf("height=%v local_parent=%x quorum_parent=%x agreeing=%v disagreeing=%v unreachable=%v snapshot=%v snapshot_source=%q blocks_recompiled=%v recovered=%v err=%q detected=%v",
+		fr.Height, fr.LocalParent[:8], fr.QuorumParent[:8], fr.Agreeing, fr.Disagreeing, fr.Unreachable, fr.SnapshotHead, fr.SnapshotSource, fr.BlocksRecompiled, fr.Recovered, fr.Err, fr.DetectedAt.Format(time.RFC3339))
+}
+
+// forkVerdict takes the local parent block and the parent blocks reported by
+// the other siblings, and determines whether a majority of the quorum
+// (including the local participant) has settled on a different parent.
+func forkVerdict(localParent siacrypto.Hash, parents map[byte]siacrypto.Hash) (quorumParent siacrypto.Hash, forked bool) {
+	votes := make(map[siacrypto.Hash]int)
+	votes[localParent]++
+	for _, parent := range parents {
+		votes[parent]++
+	}
+
+	total := len(parents) + 1
+	for parent, count := range votes {
+		if count*2 > total {
+			quorumParent = parent
+			forked = parent != localParent
+			return
+		}
+	}
+
+	// Without a majority, there's no way to tell who forked.
+	quorumParent = localParent
+	return
+}
+
+// checkForFork compares the local parent block against the parent blocks of
+// the other siblings. If the participant has forked from the quorum, a report
+// is created and recovery is scheduled for the next compile.
+func (p *Participant) checkForFork() {
+	p.engineLock.RLock()
+	metadata := p.engine.Metadata()
+	index := p.engine.SiblingIndex()
+	p.engineLock.RUnlock()
+	if index >= state.QuorumSize {
+		return
+	}
+
+	// Ask every other sibling for its metadata.
+	type response struct {
+		index    byte
+		metadata state.Metadata
+		err      error
+	}
+	responses := make(chan response)
+	var queried int
+	for i, sibling := range metadata.Siblings {
+		if sibling.Inactive() || byte(i) == index {
+			continue
+		}
+		queried++
+		go func(i byte, address network.Address) {
+			var r response
+			r.index = i
+			r.err = p.router.SendMessage(network.Message{
+				Dest: address,
+				Proc: "Participant.Metadata",
+				Args: struct{}{},
+				Resp: &r.metadata,
+			})
+			responses <- r
+		}(byte(i), sibling.Address)
+	}
+
+	report := &ForkReport{
+		DetectedAt:  time.Now(),
+		Height:      metadata.Height,
+		LocalParent: metadata.ParentBlock,
+	}
+	parents := make(map[byte]siacrypto.Hash)
+	for i := 0; i < queried; i++ {
+		r := <-responses
+		if r.err != nil || r.metadata.Height != metadata.Height {
+			report.Unreachable = append(report.Unreachable, r.index)
+			continue
+		}
+		parents[r.index] = r.metadata.ParentBlock
+	}
+
+	var forked bool
+	report.QuorumParent, forked = forkVerdict(metadata.ParentBlock, parents)
+	for i := byte(0); i < state.QuorumSize; i++ {
+		parent, exists := parents[i]
+		if !exists {
+			continue
+		}
+		if parent == metadata.ParentBlock {
+			report.Agreeing = append(report.Agreeing, i)
+		} else {
+			report.Disagreeing = append(report.Disagreeing, i)
+		}
+		if parent == report.QuorumParent {
+			report.sources = append(report.sources, metadata.Siblings[i].Address)
+		}
+	}
+
+	if !forked {
+		if len(report.Disagreeing) != 0 {
+			p.log.Debug("siblings disagree on the parent block, but the local parent has the majority:", report)
+		}
+		return
+	}
+
+	p.log.Warn("fork detected, recovery scheduled for the next compile:", report)
+	p.forkLock.Lock()
+	p.fork = report
+	p.forkLock.Unlock()
+}
+
+// fetchBlock downloads the block at the current height from 'source' and
+// checks that it extends the current state before compiling it. If the
+// block isn't available yet, fetchBlock retries until blockFetchTimeout.
+// fetchBlock only locks the engine mutex while it reads the metadata and
+// while it compiles the block, so the caller must not hold it.
+func (p *Participant) fetchBlock(source network.Address) (err error) {
+	p.engineLock.RLock()
+	metadata := p.engine.Metadata()
+	p.engineLock.RUnlock()
+
+	var b delta.Block
+	start := time.Now()
+	for {
+		err = p.router.SendMessage(network.Message{
+			Dest: source,
+			Proc: "Participant.Block",
+			Args: metadata.Height,
+			Resp: &b,
+		})
+		if err == nil || time.Since(start) > blockFetchTimeout {
+			break
+		}
+		time.Sleep(StepDuration / 4)
+	}
+	if err != nil {
+		return
+	}
+
+	// The engine was unlocked during the download, so the block is checked
+	// against the metadata as it is now.
+	p.engineLock.Lock()
+	defer p.engineLock.Unlock()
+	metadata = p.engine.Metadata()
+	if b.Height != metadata.Height || b.ParentBlock != metadata.ParentBlock {
+		err = errForkedBlock
+		return
+	}
+	err = p.engine.Compile(b)
+	return
+}
+
+// rollback moves the engine back to a snapshot that matches the snapshot held
+// by 'source'. Local snapshots are preferred, and a snapshot is only
+// downloaded if neither local snapshot matches. rollback only locks the engine
+// mutex while it reads the local snapshots and while it rolls back, so the
+// caller must not hold it.
+func (p *Participant) rollback(report *ForkReport, source network.Address) (err error) {
+	p.engineLock.RLock()
+	heads := p.engine.SnapshotHeads()
+	p.engineLock.RUnlock()
+	for _, head := range heads {
+		p.engineLock.RLock()
+		localMetadata, err2 := p.engine.LoadSnapshotMetadata(head)
+		p.engineLock.RUnlock()
+		if err2 != nil {
+			continue
+		}
+		var remoteMetadata state.Metadata
+		err2 = p.router.SendMessage(network.Message{
+			Dest: source,
+			Proc: "Participant.SnapshotMetadata",
+			Args: head,
+			Resp: &remoteMetadata,
+		})
+		if err2 != nil || remoteMetadata.Height != localMetadata.Height || remoteMetadata.ParentBlock != localMetadata.ParentBlock {
+			continue
+		}
+
+		report.SnapshotHead = head
+		report.SnapshotSource = "local"
+		p.log.Info("rolling back to local snapshot:", report)
+		p.engineLock.Lock()
+		err = p.engine.RollbackToSnapshot(head)
+		p.engineLock.Unlock()
+		return
+	}
+
+	// No local snapshot is shared with the quorum, download the most recent
+	// snapshot held by the source.
+	var metadata state.Metadata
+	err = p.router.SendMessage(network.Message{
+		Dest: source,
+		Proc: "Participant.Metadata",
+		Args: struct{}{},
+		Resp: &metadata,
+	})
+	if err != nil {
+		return
+	}
+	report.SnapshotHead = metadata.RecentSnapshot
+	report.SnapshotSource = fmt.Sprintf("%v:%v", source.Host, source.Port)
+	p.log.Info("no local snapshot matches the quorum, downloading snapshot:", report)
+	err = p.downloadSnapshot(report.sources, metadata.RecentSnapshot)
+	return
+}
+
+// recoverFromFork rolls the engine back to a snapshot shared with the quorum,
+// and then downloads and compiles blocks until the participant has caught up.
+// It is called in place of a compile, so the participant ends up at the same
+// height as the rest of the quorum. The engine mutex is not held while blocks
+// and snapshots are downloaded, only while the engine is rolled back and while
+// each block is compiled, so a slow source doesn't hold up the participant.
+func (p *Participant) recoverFromFork(report *ForkReport) {
+	// Try each source in turn until one of them gets the participant back
+	// on the quorum's chain.
+	err := errNoRecoverySource
+	targetHeight := report.Height + 1
+	for _, source := range report.sources {
+		report.BlocksRecompiled = 0
+		err = p.rollback(report, source)
+		if err != nil {
+			p.log.Warn("failed to roll back using", source, "-", err)
+			continue
+		}
+
+		for m := p.metadata(); m.Height < targetHeight; m = p.metadata() {
+			if m.Height == report.Height && m.ParentBlock != report.QuorumParent {
+				err = errForkedBlock
+				break
+			}
+			err = p.fetchBlock(source)
+			if err != nil {
+				break
+			}
+			report.BlocksRecompiled++
+		}
+		if err == nil {
+			break
+		}
+		p.log.Warn("failed to catch up using", source, "-", err)
+	}
+
+	if err != nil {
+		report.Err = err.Error()
+		p.log.Error("fork recovery failed:", report)
+		return
+	}
+
+	// Make sure that the participant is still a sibling on the quorum's
+	// chain.
+	p.engineLock.Lock()
+	index := p.engine.SiblingIndex()
+	removed := index < state.QuorumSize && p.engine.Metadata().Siblings[index].PublicKey != p.publicKey
+	if removed {
+		p.setSiblingIndex(^byte(0))
+	}
+	p.engineLock.Unlock()
+	if removed {
+		report.Err = "participant was removed from the quorum before recovery finished"
+		p.log.Error("fork recovery failed:", report)
+		return
+	}
+
+	report.Recovered = true
+	p.log.Info("fork recovery finished:", report)
+}
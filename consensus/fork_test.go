@@ -0,0 +1,51 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+)
+
+// TestForkVerdict checks that a participant only considers itself forked when
+// a majority of the quorum agrees on a different parent.
+func TestForkVerdict(t *testing.T) {
+	local := siacrypto.Hash{1}
+	other := siacrypto.Hash{2}
+	third := siacrypto.Hash{3}
+
+	// Everyone agrees.
+	parent, forked := forkVerdict(local, map[byte]siacrypto.Hash{1: local, 2: local, 3: local})
+	if forked || parent != local {
+		t.Error("fork detected when every sibling agrees")
+	}
+
+	// The local participant is in the minority.
+	parent, forked = forkVerdict(local, map[byte]siacrypto.Hash{1: other, 2: other})
+	if !forked || parent != other {
+		t.Error("fork not detected when the majority disagrees")
+	}
+
+	// A single sibling disagrees.
+	parent, forked = forkVerdict(local, map[byte]siacrypto.Hash{1: other, 2: local})
+	if forked || parent != local {
+		t.Error("fork detected when the local parent has the majority")
+	}
+
+	// An even split has no majority.
+	_, forked = forkVerdict(local, map[byte]siacrypto.Hash{1: other})
+	if forked {
+		t.Error("fork detected without a majority")
+	}
+
+	// Three different parents have no majority.
+	_, forked = forkVerdict(local, map[byte]siacrypto.Hash{1: other, 2: third})
+	if forked {
+		t.Error("fork detected without a majority")
+	}
+
+	// No other siblings.
+	_, forked = forkVerdict(local, nil)
+	if forked {
+		t.Error("fork detected with no other siblings")
+	}
+}
@@ -0,0 +1,78 @@
+package consensus
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
+)
+
+// A participant's keypair is its identity on the quorum, and losing it means
+// losing the participant's sibling slot. The keypair is saved under the
+// participant's file prefix, encrypted with a key derived from a passphrase
+// chosen by the host. The identity file contains a random salt followed by
+// the encrypted keypair.
+
+const (
+	identitySaltSize = 32
+)
+
+var (
+	errBadIdentity = errors.New("could not decrypt participant identity, the passphrase may be wrong")
+)
+
+// An identity is the keypair of a participant.
+type identity struct {
+	PublicKey siacrypto.PublicKey
+	SecretKey siacrypto.SecretKey
+}
+
+// createIdentity creates a new keypair for a participant.
+func createIdentity() (id identity, err error) {
+	id.PublicKey, id.SecretKey, err = siacrypto.CreateKeyPair()
+	return
+}
+
+func identityFilename(filePrefix string) string {
+	return filePrefix + "identity"
+}
+
+// saveIdentity encrypts the participant's keypair and saves it to disk.
+func (p *Participant) saveIdentity(filePrefix string, passphrase string) (err error) {
+	encodedIdentity, err := siaencoding.Marshal(identity{
+		PublicKey: p.publicKey,
+		SecretKey: p.secretKey,
+	})
+	if err != nil {
+		return
+	}
+	salt := siacrypto.RandomByteSlice(identitySaltSize)
+	key := siacrypto.DeriveEncryptionKey([]byte(passphrase), salt)
+	ciphertext, err := key.EncryptBytes(encodedIdentity)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(identityFilename(filePrefix), append(salt, ciphertext...), 0600)
+	return
+}
+
+// loadIdentity reads and decrypts the keypair saved by saveIdentity.
+func loadIdentity(filePrefix string, passphrase string) (id identity, err error) {
+	contents, err := ioutil.ReadFile(identityFilename(filePrefix))
+	if err != nil {
+		return
+	}
+	if len(contents) < identitySaltSize {
+		err = errBadIdentity
+		return
+	}
+	key := siacrypto.DeriveEncryptionKey([]byte(passphrase), contents[:identitySaltSize])
+	encodedIdentity, err := key.DecryptBytes(contents[identitySaltSize:])
+	if err != nil {
+		err = errBadIdentity
+		return
+	}
+	err = siaencoding.Unmarshal(encodedIdentity, &id)
+	return
+}
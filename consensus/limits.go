@@ -0,0 +1,119 @@
+package consensus
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Participants limit how much any single peer can send them, since every
+// update that a participant accepts takes up room in its next heartbeat. The
+// RPCServer enforces rate and size limits on the RPCs that accept data from
+// peers, see network.RateLimit, and the script inputs waiting to be put into
+// a heartbeat are kept in a bounded queue.
+//
+// The queue is ordered by deadline. A script input that expires sooner is
+// more urgent, so when the queue is full the input with the latest deadline
+// is dropped to make room, or the new input is turned away if its own
+// deadline is the latest.
+
+const (
+	// MaxPendingScriptInputs is the number of script inputs that a
+	// participant holds for its next heartbeat, and the number of script
+	// inputs that it accepts in the update of another sibling.
+	MaxPendingScriptInputs = 64
+
+	// MaxScriptInputSize is the largest encoded ScriptInput that a
+	// participant accepts through AddScriptInput.
+	MaxScriptInputSize = 1 << 14
+
+	// maxUploadSize is the largest encoded SegmentUpload that a
+	// participant accepts. A segment holds at most a sector's worth of
+	// atoms, and the JSON encoding of a byte slice is a third larger than
+	// the slice.
+	maxUploadSize = 2 * state.AtomsPerSector * state.AtomSize
+
+	// maxSignedUpdateSize is the largest encoded SignedUpdate that a
+	// participant accepts, which leaves room for a full queue of script
+	// inputs on top of the heartbeat and signatures.
+	maxSignedUpdateSize = 2*MaxPendingScriptInputs*MaxScriptInputSize + 1<<16
+)
+
+var (
+	errExpiredScriptInput  = errors.New("script input deadline has already passed")
+	errScriptQueueFull     = errors.New("script input queue is full of inputs with earlier deadlines")
+	errTooManyScriptInputs = errors.New("update carries more than MaxPendingScriptInputs script inputs")
+	errUnpaidScriptInput   = errors.New("script input has a fee, but its script does not accept it")
+)
+
+// rateLimits holds the number of calls that a single peer can make to each
+// RPC of a single participant. A sibling sends each of its siblings one
+// update per block, so the update limit leaves room for a full quorum of
+// siblings behind one host. A dry run can cost as much as a full script run
+// and its trace, so dry runs are limited to a few a second.
+var rateLimits = map[string]network.RateLimit{
+	"Participant.AddScriptInput":     {Rate: 10, Burst: 2 * MaxPendingScriptInputs},
+	"Participant.DryRun":             {Rate: 2, Burst: 4},
+	"Participant.HandleSignedUpdate": {Rate: float64(time.Second) / float64(StepDuration), Burst: 2 * int(state.QuorumSize)},
+	"Participant.UploadSegment":      {Rate: 10, Burst: 4 * int(state.QuorumSize)},
+}
+
+// messageLimits holds the largest encoded arguments accepted by each RPC
+// that takes data from peers.
+var messageLimits = map[string]int{
+	"Participant.AddScriptInput":     MaxScriptInputSize,
+	"Participant.DryRun":             MaxScriptInputSize,
+	"Participant.HandleSignedUpdate": maxSignedUpdateSize,
+	"Participant.UploadSegment":      maxUploadSize,
+}
+
+// setLimits tells the router about the limits of the participant's RPCs.
+// Every participant on a router shares the same limits.
+func setLimits(rpcs *network.RPCServer) {
+	for proc, limit := range rateLimits {
+		rpcs.SetRateLimit(proc, limit)
+	}
+	for proc, size := range messageLimits {
+		rpcs.SetMessageLimit(proc, size)
+	}
+}
+
+// queueScriptInput adds a script input to a queue that is sorted by
+// deadline, dropping the inputs that have expired at 'height'. If the queue
+// is over MaxPendingScriptInputs, the input with the latest deadline is
+// dropped and returned.
+func queueScriptInput(queue []state.ScriptInput, si state.ScriptInput, height uint32) (newQueue []state.ScriptInput, dropped *state.ScriptInput, err error) {
+	if si.Deadline < height {
+		newQueue = queue
+		err = errExpiredScriptInput
+		return
+	}
+
+	for _, queued := range queue {
+		if queued.Deadline >= height {
+			newQueue = append(newQueue, queued)
+		}
+	}
+
+	// Inputs with the same deadline stay in the order they arrived.
+	i := sort.Search(len(newQueue), func(i int) bool {
+		return newQueue[i].Deadline > si.Deadline
+	})
+	if len(newQueue) >= MaxPendingScriptInputs && i == len(newQueue) {
+		err = errScriptQueueFull
+		return
+	}
+	newQueue = append(newQueue, state.ScriptInput{})
+	copy(newQueue[i+1:], newQueue[i:])
+	newQueue[i] = si
+
+	if len(newQueue) > MaxPendingScriptInputs {
+		last := newQueue[len(newQueue)-1]
+		dropped = &last
+		newQueue = newQueue[:MaxPendingScriptInputs]
+	}
+	return
+}
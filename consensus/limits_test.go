@@ -0,0 +1,128 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/delta"
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestScriptInputQueue fills the script input queue and checks that it stays
+// ordered by deadline, drops expired inputs, and makes room for urgent inputs
+// by dropping the input with the latest deadline.
+func TestScriptInputQueue(t *testing.T) {
+	var queue []state.ScriptInput
+	var err error
+	_, _, err = queueScriptInput(queue, state.ScriptInput{Deadline: 9}, 10)
+	if err != errExpiredScriptInput {
+		t.Fatal("expecting errExpiredScriptInput, got", err)
+	}
+
+	// Fill the queue with deadlines in reverse order.
+	for i := 0; i < MaxPendingScriptInputs; i++ {
+		var dropped *state.ScriptInput
+		queue, dropped, err = queueScriptInput(queue, state.ScriptInput{Deadline: uint32(100 + MaxPendingScriptInputs - i)}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != nil {
+			t.Fatal("dropped an input before the queue was full")
+		}
+	}
+	for i := 1; i < len(queue); i++ {
+		if queue[i-1].Deadline > queue[i].Deadline {
+			t.Fatal("queue is not ordered by deadline")
+		}
+	}
+
+	// A full queue turns away inputs with later deadlines, and drops its
+	// latest input for an earlier one.
+	latest := queue[len(queue)-1].Deadline
+	_, _, err = queueScriptInput(queue, state.ScriptInput{Deadline: latest + 1}, 10)
+	if err != errScriptQueueFull {
+		t.Fatal("expecting errScriptQueueFull, got", err)
+	}
+	queue, dropped, err := queueScriptInput(queue, state.ScriptInput{Deadline: 20, WalletID: 1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped == nil || dropped.Deadline != latest {
+		t.Fatal("expecting the latest input to be dropped, got", dropped)
+	}
+	if len(queue) != MaxPendingScriptInputs || queue[0].WalletID != 1 {
+		t.Fatal("urgent input was not put at the front of the queue")
+	}
+
+	// Expired inputs are cleared out when the next input arrives.
+	queue, _, err = queueScriptInput(queue, state.ScriptInput{Deadline: 200}, 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, si := range queue {
+		if si.Deadline < 110 {
+			t.Fatal("expired input was kept:", si.Deadline)
+		}
+	}
+	if queue[len(queue)-1].Deadline != 200 {
+		t.Error("new input was not put at the back of the queue")
+	}
+}
+
+// TestAddScriptInputFees checks that an input with a fee is only queued if the
+// script of its wallet accepts it, and that inputs without a fee are queued
+// without a dry run.
+func TestAddScriptInputFees(t *testing.T) {
+	tetherPK, tetherSK, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := network.NewRPCServerWithTransport(network.NewLoopbackNetwork().Transport("localhost"), 11600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestAddScriptInputFees"), "", 1, tetherPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	deadline := p.engine.Metadata().Height + 10
+
+	// An unsigned input with a fee is refused.
+	si := state.ScriptInput{
+		WalletID: 1,
+		Deadline: deadline,
+		Input:    make([]byte, siacrypto.SignatureSize+1),
+		Fee:      state.NewBalance(100),
+	}
+	err = p.AddScriptInput(si, nil)
+	if err != errUnpaidScriptInput {
+		t.Fatal("expecting errUnpaidScriptInput, got", err)
+	}
+
+	// The same input signed by the tether wallet is queued.
+	si.Input = []byte{0xFF}
+	err = delta.SignScriptInput(&si, tetherSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AddScriptInput(si, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unsigned input without a fee is queued, and left for the script to
+	// reject.
+	err = p.AddScriptInput(state.ScriptInput{WalletID: 1, Deadline: deadline}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.updatesLock.RLock()
+	queued := len(p.scriptInputs)
+	p.updatesLock.RUnlock()
+	if queued != 2 {
+		t.Error("expecting 2 queued script inputs, got", queued)
+	}
+}
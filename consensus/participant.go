@@ -1,6 +1,8 @@
 package consensus
 
 import (
+	"bytes"
+	"errors"
 	"sync"
 	"time"
 
@@ -21,8 +23,9 @@ type Participant struct {
 	secretKey siacrypto.SecretKey
 
 	// Network Related Variables
-	address network.Address
-	router  *network.RPCServer
+	address  network.Address
+	router   *network.RPCServer
+	peerKeys map[network.Address]siacrypto.PublicKey
 
 	// Update Variables
 	updates            [state.QuorumSize]map[siacrypto.Hash]Update
@@ -32,15 +35,37 @@ type Participant struct {
 
 	// Consensus Algorithm Status
 	ticking     bool
+	departed    bool
 	tickStart   time.Time
 	currentStep byte
 	tickLock    sync.RWMutex
 	updateStop  sync.RWMutex
 
+	// Fork Recovery Variables
+	fork     *ForkReport
+	forkLock sync.Mutex
+
+	// Segment Rebuild Variables
+	rebuild     state.RebuildProgress
+	rebuildLock sync.Mutex
+
+	// Scrubber Variables
+	scrubRate   uint32
+	scrubStatus ScrubStatusStruct
+	scrubLock   sync.RWMutex
+
+	// Shutdown Variables
+	closed    chan struct{}
+	closeOnce sync.Once
+
 	// Logger
 	log *sialog.Logger
 }
 
+var (
+	errUnauthorizedPeer = errors.New("peer is not allowed to make this call")
+)
+
 // Ping is the simplest RPC possible. It exists only to confirm that a
 // participant is reachable and listening. Ping should be called via
 // RPCServer.Ping() instead of RPCServer.SendMessage().
@@ -50,8 +75,10 @@ func (p *Participant) Ping(_ struct{}, _ *struct{}) error {
 
 // broadcast sends a message to every sibling in the quorum. It cannot be used
 // when the response value needs to be checked. It also discards any errors
-// received.
+// received. The message is sent from the participant's own identity, so
+// that the siblings know which sibling sent it.
 func (p *Participant) broadcast(message network.Message) {
+	message.Source = p.address.ID
 	// Send the message to all active and passive siblings in the quorum.
 	p.engineLock.RLock()
 	metadata := p.engine.Metadata()
@@ -64,3 +91,107 @@ func (p *Participant) broadcast(message network.Message) {
 		}
 	}
 }
+
+// Close stops the participant. The participant stops ticking, and the
+// scrubber and the repair loop exit. A closed participant can't be started
+// again, but its files can be reopened with LoadParticipant.
+func (p *Participant) Close() {
+	p.closeOnce.Do(func() {
+		p.tickLock.Lock()
+		p.departed = true
+		p.tickLock.Unlock()
+		close(p.closed)
+	})
+}
+
+// pause sleeps for 'd', returning false if the participant is closed before
+// 'd' has passed.
+func (p *Participant) pause(d time.Duration) bool {
+	select {
+	case <-p.closed:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// currentAddress returns the address of the participant under the router's
+// current hostname, which changes if the router learns a new hostname after
+// the participant was registered.
+func (p *Participant) currentAddress() (a network.Address) {
+	a = p.router.Address()
+	a.ID = p.address.ID
+	return
+}
+
+// metadata returns the metadata of the engine, locking the engine mutex while
+// it reads it.
+func (p *Participant) metadata() state.Metadata {
+	p.engineLock.RLock()
+	defer p.engineLock.RUnlock()
+	return p.engine.Metadata()
+}
+
+// expectSiblings tells the router which public key each sibling must prove
+// when the participant connects to it, so that messages meant for a sibling
+// are never delivered to whoever else answers at its address. The keys of
+// siblings that have left the quorum are forgotten. expectSiblings requires
+// the engine lock.
+func (p *Participant) expectSiblings() {
+	current := make(map[network.Address]siacrypto.PublicKey)
+	for _, sibling := range p.engine.Metadata().Siblings {
+		if !sibling.Inactive() {
+			p.router.ExpectPeer(sibling.Address, sibling.PublicKey)
+			current[sibling.Address] = sibling.PublicKey
+		}
+	}
+	for address, pk := range p.peerKeys {
+		if current[address] != pk {
+			p.router.ForgetPeer(address, pk)
+		}
+	}
+	p.peerKeys = current
+}
+
+// isSibling returns true if 'pk' belongs to an active or passive sibling of
+// the quorum. isSibling requires the engine lock.
+func (p *Participant) isSibling(pk siacrypto.PublicKey) bool {
+	if pk == (siacrypto.PublicKey{}) {
+		return false
+	}
+	for _, sibling := range p.engine.Metadata().Siblings {
+		if !sibling.Inactive() && sibling.PublicKey == pk {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize is the network.Authorizer of the participant. Updates are only
+// accepted from siblings that proved their key when they connected. Segments
+// are accepted from siblings, and from the owner of the wallet that the
+// segment belongs to, which proves the key of the wallet's default script.
+func (p *Participant) authorize(proc string, peer siacrypto.PublicKey, args interface{}) (err error) {
+	p.engineLock.RLock()
+	defer p.engineLock.RUnlock()
+	switch proc {
+	case "Participant.HandleSignedUpdate":
+		if !p.isSibling(peer) {
+			err = errUnauthorizedPeer
+		}
+	case "Participant.UploadSegment":
+		if p.isSibling(peer) {
+			return
+		}
+		upload, ok := args.(*delta.SegmentUpload)
+		if !ok || peer == (siacrypto.PublicKey{}) {
+			err = errUnauthorizedPeer
+			return
+		}
+		w, walletErr := p.engine.Wallet(upload.WalletID)
+		if walletErr != nil || !bytes.Equal(w.Script, delta.DefaultScript(peer)) {
+			err = errUnauthorizedPeer
+		}
+	}
+	return
+}
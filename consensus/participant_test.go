@@ -3,6 +3,7 @@ package consensus
 import (
 	"testing"
 
+	"github.com/NebulousLabs/Sia/delta"
 	"github.com/NebulousLabs/Sia/network"
 	"github.com/NebulousLabs/Sia/siacrypto"
 	"github.com/NebulousLabs/Sia/siafiles"
@@ -11,28 +12,32 @@ import (
 // TestNewParticipnat runs NewParticipant and checks to see that all the basic
 // items have been initialized.
 func TestNewParticipant(t *testing.T) {
+	id, err := createIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// Test calling NewParticipant with a nil message router.
-	p, err := newParticipant(nil, siafiles.TempFilename("TestNewParticipant"))
+	p, err := newParticipant(nil, siafiles.TempFilename("TestNewParticipant"), id)
 	if err == nil {
 		t.Error("Created a participant with a nil message router")
 	}
 
-	mr, err := network.NewRPCServer(11200)
+	mr, err := network.NewRPCServerWithTransport(network.NewLoopbackNetwork().Transport("localhost"), 11200)
 	if err != nil {
 		t.Fatal("Failed to initialize RPCServer:", err)
 	}
-	p, err = newParticipant(mr, siafiles.TempFilename("TestNewParticipant"))
+	p, err = newParticipant(mr, siafiles.TempFilename("TestNewParticipant"), id)
 	if err != nil {
 		t.Fatal("Failed to create participant:", err)
 	}
 
-	// Test that a keypair exists.
+	// Test that the participant has the identity it was given.
 	var emptyPublicKey siacrypto.PublicKey
-	var emptySecretKey siacrypto.SecretKey
-	if p.publicKey == emptyPublicKey {
+	if p.publicKey == emptyPublicKey || p.publicKey != id.PublicKey {
 		t.Error("Public key not properly initialized")
 	}
-	if p.secretKey == emptySecretKey {
+	if p.secretKey != id.SecretKey {
 		t.Error("Secret key not properly initialized")
 	}
 
@@ -48,3 +53,46 @@ func TestNewParticipant(t *testing.T) {
 		t.Error("Participant not reachable:", err)
 	}
 }
+
+// TestAuthorize checks that updates are only accepted from siblings, and
+// segments only from siblings and from the owner of the wallet.
+func TestAuthorize(t *testing.T) {
+	tetherPK, _, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPK, _, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := network.NewRPCServerWithTransport(network.NewLoopbackNetwork().Transport("localhost"), 11500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestAuthorize"), "", 1, tetherPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	tests := []struct {
+		proc       string
+		peer       siacrypto.PublicKey
+		authorized bool
+	}{
+		{"Participant.HandleSignedUpdate", p.publicKey, true},
+		{"Participant.HandleSignedUpdate", siacrypto.PublicKey{}, false},
+		{"Participant.HandleSignedUpdate", tetherPK, false},
+		{"Participant.UploadSegment", p.publicKey, true},
+		{"Participant.UploadSegment", tetherPK, true},
+		{"Participant.UploadSegment", otherPK, false},
+		{"Participant.UploadSegment", siacrypto.PublicKey{}, false},
+		{"Participant.Metadata", siacrypto.PublicKey{}, true},
+	}
+	for _, test := range tests {
+		err = p.authorize(test.proc, test.peer, &delta.SegmentUpload{WalletID: 1})
+		if (err == nil) != test.authorized {
+			t.Error("unexpected authorization of", test.proc, "- got", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"time"
+
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Segment Rebuilds
+//
+// A sibling that is placed into a slot whose segments were lost, either
+// because the previous sibling was tossed or because the slot was never
+// filled, has to rebuild its segment of every wallet from the other siblings.
+// The quorum marks the slot as rebuilding in the metadata and stops asking
+// the sibling for storage proofs. In the meantime, the sibling reports how
+// many segments it has rebuilt in each heartbeat, and the quorum resumes
+// storage proofs once the sibling reports that the rebuild is complete. A
+// sibling that hasn't finished within state.RebuildWindow blocks is tossed.
+
+// segmentRebuilt counts one more wallet towards the rebuild progress.
+func (p *Participant) segmentRebuilt() {
+	p.rebuildLock.Lock()
+	p.rebuild.Rebuilt++
+	p.rebuildLock.Unlock()
+}
+
+// rebuildSegments gets the participant's segment of every wallet. A successor
+// downloads the segments from its predecessor, and recovers any segments that
+// the predecessor could not provide from the rest of the quorum. Wallets that
+// could not be recovered are retried once per compile in the background, and
+// the rebuild is only reported as complete once every segment is present.
+func (p *Participant) rebuildSegments(predecessor network.Address) {
+	p.engineLock.RLock()
+	remaining := p.engine.WalletList()
+	p.engineLock.RUnlock()
+	p.rebuildLock.Lock()
+	p.rebuild = state.RebuildProgress{Total: uint32(len(remaining))}
+	p.rebuildLock.Unlock()
+
+	if predecessor != (network.Address{}) {
+		remaining = p.transferSegments(predecessor, remaining)
+	}
+	remaining = p.recoverSegments(remaining)
+	if len(remaining) == 0 {
+		p.finishRebuild()
+		return
+	}
+
+	p.log.Warn("could not rebuild", len(remaining), "segments, retrying in the background")
+	go func() {
+		for len(remaining) != 0 {
+			time.Sleep(time.Duration(NumSteps) * StepDuration)
+			p.tickLock.RLock()
+			departed := p.departed
+			p.tickLock.RUnlock()
+			if departed {
+				return
+			}
+			remaining = p.recoverSegments(remaining)
+		}
+		p.finishRebuild()
+	}()
+}
+
+// finishRebuild marks the rebuild as complete, which the quorum learns about
+// from the participant's next heartbeat.
+func (p *Participant) finishRebuild() {
+	p.rebuildLock.Lock()
+	p.rebuild.Complete = true
+	p.rebuildLock.Unlock()
+	p.log.Info("finished rebuilding segments")
+}
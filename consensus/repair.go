@@ -3,7 +3,6 @@ package consensus
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"os"
 	"time"
@@ -12,110 +11,262 @@ import (
 	"github.com/NebulousLabs/Sia/state"
 )
 
+const (
+	// maxRepairWorkers is the maximum number of repairs from the repair
+	// queue that are attempted at once.
+	maxRepairWorkers = 4
+)
+
+var (
+	errCorruptSegment  = errors.New("the participant's segment does not match the sector")
+	errNoSector        = errors.New("wallet has no sector")
+	errNotRegenerating = errors.New("sector does not use the regenerating code")
+)
+
+// recoverSegment rebuilds the participant's segment of a wallet's sector from
+// the other siblings. Sectors that use the regenerating code are first
+// repaired from the repair data of D helpers, which uses less bandwidth than
+// downloading K full segments. If that fails, or if the sector uses
+// Reed-Solomon coding, the whole sector is reconstructed from K segments.
 func (p *Participant) recoverSegment(id state.WalletID) (err error) {
 	// Get the wallet so that we know what we are downloading.
 	p.engineLock.RLock()
 	w, err := p.engine.Wallet(id)
+	siblings := p.engine.Metadata().Siblings
+	selfIndex := p.engine.SiblingIndex()
 	p.engineLock.RUnlock()
 	if err != nil {
 		return
 	}
 
 	if w.Sector.Atoms == 0 {
-		err = fmt.Errorf("wallet %v has no sector", id)
+		err = errNoSector
 		return
 	}
 
-	// Download K pieces into K readers, 'segments'.
-	var segments []io.Reader
-	var indices []byte
-	for i := range p.engine.Metadata().Siblings {
-		p.engineLock.RLock()
-		address := p.engine.Metadata().Siblings[i].Address
-		p.engineLock.RUnlock()
-		var segment []byte
-		err2 := p.router.SendMessage(network.Message{
-			Dest: address,
-			Proc: "Participant.DownloadSegment",
-			Args: id,
-			Resp: &segment,
-		})
-		if err2 != nil {
-			continue
+	var segment []byte
+	if w.Sector.Regenerating() {
+		segment, err = p.regenerateSegment(w, siblings, selfIndex)
+		if err != nil {
+			p.log.Warn("regenerating repair of wallet", id, "failed, falling back to full reconstruction:", err)
 		}
-
-		segments = append(segments, bytes.NewReader(segment))
-		indices = append(indices, byte(i))
-
-		if len(segments) >= int(w.Sector.K) {
-			break
+	}
+	if segment == nil {
+		segment, err = p.reconstructSegment(w, siblings, selfIndex)
+		if err != nil {
+			return
 		}
 	}
 
-	// Check that enough pieces were gathered.
-	if len(segments) < int(w.Sector.K) {
-		err = errors.New("could not repair segment")
+	// Reload the wallet (timing), verify the hash, and write to disk.
+	p.engineLock.RLock()
+	w, err = p.engine.Wallet(id)
+	selfIndex = p.engine.SiblingIndex()
+	p.engineLock.RUnlock()
+	if err != nil {
+		return
+	}
+	if selfIndex >= state.QuorumSize {
+		err = errNotSibling
 		return
 	}
 
-	// Have the state decode the segments into a new sector.
-	buffer := new(bytes.Buffer)
-	_, err = state.RSRecover(segments, indices, buffer, int(w.Sector.K))
+	hash, err := state.MerkleCollapse(bytes.NewReader(segment), w.Sector.Atoms)
 	if err != nil {
 		return
 	}
+	if hash != w.Sector.HashSet[selfIndex] {
+		err = errors.New("will not recover file - hash incorrect!")
+		return
+	}
 
-	// Use the writer to create the full set of segments, including the one
-	// we need.
-	fullSegments := make([][]byte, state.QuorumSize)
-	atoms, err := state.RSEncode(buffer, fullSegments, int(w.Sector.K))
+	file, err := os.Create(p.engine.SegmentFilename(id))
 	if err != nil {
 		return
 	}
+	file.Write(segment)
+	file.Close()
 
-	// Take the original segment and get its hash.
-	p.engineLock.RLock()
-	segment := fullSegments[p.engine.SiblingIndex()]
-	hash, err := state.MerkleCollapse(bytes.NewReader(segment), atoms)
-	p.engineLock.RUnlock()
-	if err != nil {
+	return
+}
+
+// regenerateSegment rebuilds the participant's segment of a sector that uses
+// the regenerating code, using the repair data of every other sibling. Each
+// sibling sends half of a segment, so the repair downloads D half segments
+// instead of K full segments. The repaired segment is checked against the
+// sector's hash set, since the repair data itself cannot be verified.
+func (p *Participant) regenerateSegment(w state.Wallet, siblings [state.QuorumSize]state.Sibling, selfIndex byte) (segment []byte, err error) {
+	type repairData struct {
+		index byte
+		data  []byte
+		err   error
+	}
+	responses := make(chan repairData, state.QuorumSize)
+	var requested int
+	for i, sibling := range siblings {
+		if sibling.Inactive() || byte(i) == selfIndex {
+			continue
+		}
+		requested++
+		go func(i byte, address network.Address) {
+			rd := repairData{index: i}
+			rd.err = p.router.SendMessage(network.Message{
+				Dest: address,
+				Proc: "Participant.DownloadRepairData",
+				Args: RepairDataRequest{WalletID: w.ID, Lost: selfIndex},
+				Resp: &rd.data,
+			})
+			responses <- rd
+		}(byte(i), sibling.Address)
+	}
+
+	var data [][]byte
+	var helpers []byte
+	for i := 0; i < requested; i++ {
+		rd := <-responses
+		if rd.err != nil {
+			continue
+		}
+		data = append(data, rd.data)
+		helpers = append(helpers, rd.index)
+	}
+	if len(data) < int(w.Sector.D) {
+		err = errors.New("not enough siblings provided repair data")
 		return
 	}
 
-	// Reload the wallet (timing), verify the hash, and write to disk.
-	p.engineLock.RLock()
-	w, err = p.engine.Wallet(id)
-	p.engineLock.RUnlock()
+	segment, err = state.RepairSegment(data, helpers, selfIndex)
+	if err != nil {
+		return
+	}
+	hash, err := state.MerkleCollapse(bytes.NewReader(segment), w.Sector.Atoms)
 	if err != nil {
 		return
 	}
+	if hash != w.Sector.HashSet[selfIndex] {
+		segment = nil
+		err = errors.New("repair data produced a bad segment")
+	}
+	return
+}
 
-	if hash != w.Sector.HashSet[p.engine.SiblingIndex()] {
-		err = errors.New("will not recover file - hash incorrect!")
+// reconstructSegment rebuilds the participant's segment of a sector by
+// downloading segments from the other siblings, decoding the sector, and
+// encoding it again. The segments are requested from every sibling at once,
+// and each one is checked against the sector's hash set before it is used.
+func (p *Participant) reconstructSegment(w state.Wallet, siblings [state.QuorumSize]state.Sibling, selfIndex byte) (segment []byte, err error) {
+	// Request a segment from every other sibling. The channel is buffered
+	// so that late responses don't block once enough segments have been
+	// gathered.
+	type piece struct {
+		index   byte
+		segment []byte
+		err     error
+	}
+	pieces := make(chan piece, state.QuorumSize)
+	var requested int
+	for i, sibling := range siblings {
+		if sibling.Inactive() || byte(i) == selfIndex {
+			continue
+		}
+		requested++
+		go func(i byte, address network.Address) {
+			pc := piece{index: i}
+			pc.err = p.router.SendMessage(network.Message{
+				Dest: address,
+				Proc: "Participant.DownloadSegment",
+				Args: w.ID,
+				Resp: &pc.segment,
+			})
+			pieces <- pc
+		}(byte(i), sibling.Address)
+	}
+
+	// Gather K verified segments.
+	var segments []io.Reader
+	var indices []byte
+	for i := 0; i < requested && len(segments) < int(w.Sector.K); i++ {
+		pc := <-pieces
+		if pc.err != nil {
+			continue
+		}
+		hash, err2 := state.MerkleCollapse(bytes.NewReader(pc.segment), w.Sector.Atoms)
+		if err2 != nil || hash != w.Sector.HashSet[pc.index] {
+			p.log.Warn("sibling", pc.index, "provided a bad segment for wallet", w.ID)
+			continue
+		}
+		segments = append(segments, bytes.NewReader(pc.segment))
+		indices = append(indices, pc.index)
+	}
+
+	// Check that enough pieces were gathered.
+	if len(segments) < int(w.Sector.K) {
+		err = errors.New("could not repair segment")
 		return
 	}
 
-	file, err := os.Create(p.engine.SegmentFilename(id))
+	// Have the state decode the segments into a new sector.
+	buffer := new(bytes.Buffer)
+	_, err = state.RecoverSector(segments, indices, buffer, w.Sector.K, w.Sector.D)
 	if err != nil {
 		return
 	}
-	file.Write(segment)
-	file.Close()
 
+	// Use the writer to create the full set of segments, including the one
+	// we need.
+	fullSegments := make([][]byte, state.QuorumSize)
+	_, err = state.EncodeSector(buffer, fullSegments, w.Sector.K, w.Sector.D)
+	if err != nil {
+		return
+	}
+	segment = fullSegments[selfIndex]
 	return
 }
 
-func (p *Participant) recoveryListen() {
-	repairChan := p.engine.RepairChan()
+// processRepairs works through the repair queue until the participant is
+// closed. Repairs are attempted by at most maxRepairWorkers goroutines at
+// once, and the queue is checked whenever a repair is added or a step has
+// passed, which is when failed repairs become due again. Nothing is repaired
+// while the participant is not a sibling.
+func (p *Participant) processRepairs() {
+	queue := p.engine.RepairQueue()
+	workers := make(chan struct{}, maxRepairWorkers)
+	for {
+		select {
+		case <-queue.Wake():
+		case <-time.After(StepDuration):
+		case <-p.closed:
+			return
+		}
 
-	for repairRequest := range repairChan {
 		p.engineLock.RLock()
-		for p.engine.SiblingIndex() == 255 {
-			p.engineLock.RUnlock()
-			time.Sleep(StepDuration)
-			p.engineLock.RLock()
-		}
+		index := p.engine.SiblingIndex()
 		p.engineLock.RUnlock()
-		go p.recoverSegment(repairRequest)
+		if index >= state.QuorumSize {
+			continue
+		}
+
+		for _, id := range queue.Due(time.Now()) {
+			workers <- struct{}{}
+			go func(id state.WalletID) {
+				defer func() { <-workers }()
+				p.repair(queue, id)
+			}(id)
+		}
+	}
+}
+
+// repair attempts a single repair from the queue and records the outcome.
+func (p *Participant) repair(queue *state.RepairQueue, id state.WalletID) {
+	var queueErr error
+	err := p.recoverSegment(id)
+	if err == nil || err == errNoSector {
+		queueErr = queue.Complete(id)
+	} else {
+		p.log.Warn("repair of wallet", id, "failed:", err)
+		queueErr = queue.Fail(id, err)
+	}
+	if queueErr != nil {
+		p.log.Error("failed to save repair queue:", queueErr)
 	}
 }
@@ -0,0 +1,65 @@
+package consensus
+
+import (
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// LoadParticipant restarts a participant that was previously created with the
+// same file prefix, keeping its keypair and its sibling slot. The engine is
+// reopened from the most recent snapshot and the active block history, and
+// the participant then catches up to the quorum by downloading the blocks it
+// missed from the other siblings before it resumes ticking.
+//
+// The participant is registered with the RPC server again, so its address
+// may differ from the address that the quorum has on record.
+func LoadParticipant(rpcs *network.RPCServer, filePrefix string, passphrase string) (p *Participant, err error) {
+	id, err := loadIdentity(filePrefix, passphrase)
+	if err != nil {
+		return
+	}
+	p, err = newParticipant(rpcs, filePrefix, id)
+	if err != nil {
+		return
+	}
+
+	// Reopen the engine and check that the participant still has its
+	// slot.
+	p.engineLock.Lock()
+	err = p.engine.Reopen()
+	if err == nil {
+		p.expectSiblings()
+	}
+	metadata := p.engine.Metadata()
+	index := p.engine.SiblingIndex()
+	p.engineLock.Unlock()
+	if err != nil {
+		return
+	}
+	if index >= state.QuorumSize || metadata.Siblings[index].PublicKey != p.publicKey || metadata.Siblings[index].Inactive() {
+		err = errNotSibling
+		return
+	}
+	p.log.Info("reopened participant at height", metadata.Height, "as sibling", index)
+	p.startBackground()
+
+	// A participant that is the only sibling has nobody to catch up with.
+	var sources []network.Address
+	for i, sibling := range metadata.Siblings {
+		if !sibling.Inactive() && byte(i) != index {
+			sources = append(sources, sibling.Address)
+		}
+	}
+	if len(sources) == 0 {
+		p.newSignedUpdate()
+		go p.tick()
+		return
+	}
+
+	err = p.catchUp(sources)
+	if err != nil {
+		return
+	}
+	p.newSignedUpdate()
+	return
+}
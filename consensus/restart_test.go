@@ -0,0 +1,98 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// TestLoadParticipant runs a bootstrap participant for a few blocks, stops it,
+// and checks that LoadParticipant brings back the same identity, sibling slot
+// and state.
+func TestLoadParticipant(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	mr, err := network.NewRPCServer(11400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	filePrefix := siafiles.TempFilename("TestLoadParticipant")
+	p, err := CreateBootstrapParticipant(mr, filePrefix, "passphrase", 1, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the participant compile a few blocks, then stop it.
+	for {
+		p.engineLock.RLock()
+		height := p.engine.Metadata().Height
+		p.engineLock.RUnlock()
+		if height >= 5 {
+			break
+		}
+		time.Sleep(StepDuration)
+	}
+	p.Close()
+	time.Sleep(2 * StepDuration)
+	p.engineLock.RLock()
+	metadataHash, err := siacrypto.HashObject(p.engine.Metadata())
+	walletCount := len(p.engine.WalletList())
+	p.engineLock.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadParticipant(mr, filePrefix, "wrong")
+	if err != errBadIdentity {
+		t.Error("expecting errBadIdentity for the wrong passphrase, got", err)
+	}
+
+	loaded, err := LoadParticipant(mr, filePrefix, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.publicKey != p.publicKey || loaded.secretKey != p.secretKey {
+		t.Error("loaded participant has a different keypair")
+	}
+	loaded.engineLock.RLock()
+	if loaded.engine.SiblingIndex() != 0 {
+		t.Error("loaded participant lost its sibling index:", loaded.engine.SiblingIndex())
+	}
+	loadedHash, err := siacrypto.HashObject(loaded.engine.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedHash != metadataHash {
+		t.Error("loaded participant has different metadata")
+	}
+	if len(loaded.engine.WalletList()) != walletCount {
+		t.Error("loaded participant has", len(loaded.engine.WalletList()), "wallets, expecting", walletCount)
+	}
+	height := loaded.engine.Metadata().Height
+	loaded.engineLock.RUnlock()
+
+	// The loaded participant was registered under a new address, which it
+	// announces in its heartbeat.
+	if loaded.address == p.address {
+		t.Fatal("loaded participant was registered under its old address")
+	}
+	for {
+		loaded.engineLock.RLock()
+		sibling := loaded.engine.Metadata().Siblings[0]
+		newHeight := loaded.engine.Metadata().Height
+		loaded.engineLock.RUnlock()
+		if sibling.Address == loaded.address {
+			break
+		}
+		if newHeight > height+2 {
+			t.Fatal("quorum did not learn the new address of the loaded participant:", sibling.Address)
+		}
+		time.Sleep(StepDuration)
+	}
+}
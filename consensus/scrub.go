@@ -0,0 +1,153 @@
+package consensus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Scrubbing
+//
+// A participant only finds out that one of its segments has been corrupted on
+// disk when a storage proof happens to land on a bad atom, at which point the
+// storage proof fails. The scrubber walks through the segment of every wallet
+// in the background, checking each segment against the sector's hash set, and
+// queues a repair for every segment that is missing or does not match. The
+// scrubber reads at most scrubRate atoms per second, so that it does not
+// compete with the rest of the participant for the disk.
+
+const (
+	// DefaultScrubRate is the number of atoms per second that the
+	// scrubber reads, unless changed with SetScrubRate.
+	DefaultScrubRate = 4096
+)
+
+// ScrubStatusStruct reports the progress of the scrubber. Passes is the number
+// of completed passes over every wallet, and Checked is the number of wallets
+// checked so far in the current pass, out of Total. Corrupted and Missing
+// count the segments, across all passes, that did not match the sector or
+// were not on disk.
+type ScrubStatusStruct struct {
+	Passes       uint32
+	Checked      uint32
+	Total        uint32
+	AtomsChecked uint64
+	Corrupted    uint64
+	Missing      uint64
+	PassStarted  time.Time
+}
+
+// SetScrubRate sets the number of atoms per second that the scrubber reads.
+// A rate of 0 pauses the scrubber.
+func (p *Participant) SetScrubRate(atomsPerSecond uint32) {
+	p.scrubLock.Lock()
+	p.scrubRate = atomsPerSecond
+	p.scrubLock.Unlock()
+}
+
+// ScrubStatus is an RPC that returns the progress of the scrubber.
+func (p *Participant) ScrubStatus(_ struct{}, ss *ScrubStatusStruct) (err error) {
+	p.scrubLock.RLock()
+	*ss = p.scrubStatus
+	p.scrubLock.RUnlock()
+	return
+}
+
+// scrub runs the scrubber until the participant is closed. Nothing is
+// scrubbed while the participant is not a sibling or is rebuilding its
+// segments, since segments are expected to be missing at those times.
+func (p *Participant) scrub() {
+	for {
+		p.engineLock.RLock()
+		index := p.engine.SiblingIndex()
+		walletList := p.engine.WalletList()
+		rebuilding := index < state.QuorumSize && p.engine.Metadata().Rebuilding(index)
+		p.engineLock.RUnlock()
+		if index >= state.QuorumSize || rebuilding {
+			if !p.pause(time.Duration(NumSteps) * StepDuration) {
+				return
+			}
+			continue
+		}
+
+		p.scrubLock.Lock()
+		p.scrubStatus.Checked = 0
+		p.scrubStatus.Total = uint32(len(walletList))
+		p.scrubStatus.PassStarted = time.Now()
+		p.scrubLock.Unlock()
+
+		for _, id := range walletList {
+			atoms, corrupted, missing := p.scrubSegment(id)
+			if corrupted || missing {
+				err := p.engine.RepairQueue().Add(id)
+				if err != nil {
+					p.log.Error("failed to save repair queue:", err)
+				}
+			}
+			if corrupted {
+				p.log.Warn("scrubber found a corrupted segment for wallet", id)
+			}
+
+			p.scrubLock.Lock()
+			p.scrubStatus.Checked++
+			p.scrubStatus.AtomsChecked += uint64(atoms)
+			if corrupted {
+				p.scrubStatus.Corrupted++
+			}
+			if missing {
+				p.scrubStatus.Missing++
+			}
+			rate := p.scrubRate
+			p.scrubLock.Unlock()
+
+			// Wait long enough to stay under the scrub rate.
+			for rate == 0 {
+				if !p.pause(StepDuration) {
+					return
+				}
+				p.scrubLock.RLock()
+				rate = p.scrubRate
+				p.scrubLock.RUnlock()
+			}
+			if !p.pause(time.Duration(atoms) * time.Second / time.Duration(rate)) {
+				return
+			}
+		}
+
+		p.scrubLock.Lock()
+		p.scrubStatus.Passes++
+		p.scrubLock.Unlock()
+		if !p.pause(time.Duration(NumSteps) * StepDuration) {
+			return
+		}
+	}
+}
+
+// scrubSegment checks the participant's segment of a wallet against the
+// sector's hash set. The segment is read while the engine is locked, so that
+// the sector can't be updated between reading the segment and reading the
+// hash set.
+func (p *Participant) scrubSegment(id state.WalletID) (atoms uint16, corrupted bool, missing bool) {
+	p.engineLock.RLock()
+	defer p.engineLock.RUnlock()
+	w, err := p.engine.Wallet(id)
+	if err != nil || w.Sector.Atoms == 0 {
+		return
+	}
+	atoms = w.Sector.Atoms
+
+	segment, err := ioutil.ReadFile(p.engine.SegmentFilename(id))
+	if os.IsNotExist(err) {
+		missing = true
+		return
+	} else if err != nil {
+		p.log.Warn("scrubber could not read segment for wallet", id, "-", err)
+		return
+	}
+	hash, err := state.MerkleCollapse(bytes.NewReader(segment), atoms)
+	corrupted = err != nil || hash != w.Sector.HashSet[p.engine.SiblingIndex()]
+	return
+}
@@ -0,0 +1,325 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/delta"
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Snapshot Synchronization
+//
+// A participant that needs the state of the quorum (a joining participant, or
+// a participant recovering from a fork) downloads a snapshot from the other
+// siblings. The snapshot header (the metadata, the wallet list, and the hash
+// of every wallet) is requested from every sibling, and only the header that a
+// majority of the siblings agree on is used. The wallets are then split
+// between the agreeing siblings and downloaded in parallel, with each wallet
+// checked against the hash in the header. Verified wallets are written to a
+// sync stage on disk, so that an interrupted sync does not need to download
+// them again. Once the state is in place, the sector segments are recovered
+// in parallel as well.
+
+const (
+	// maxSyncWorkers is the maximum number of wallets or segments that
+	// are downloaded at the same time.
+	maxSyncWorkers = 16
+)
+
+var (
+	errNoSnapshotSources    = errors.New("no sibling could provide the snapshot")
+	errSnapshotDisagreement = errors.New("siblings do not agree on the contents of the snapshot")
+)
+
+// A snapshotHeader contains all of the information about a snapshot that is
+// cross-checked between siblings before any wallets are downloaded.
+type snapshotHeader struct {
+	Metadata     state.Metadata
+	WalletList   []state.WalletID
+	WalletHashes []siacrypto.Hash
+}
+
+// fetchSnapshotHeader downloads the snapshot header at 'snapshotHead' from
+// 'source'.
+func (p *Participant) fetchSnapshotHeader(source network.Address, snapshotHead uint32) (header snapshotHeader, err error) {
+	err = p.router.SendMessage(network.Message{
+		Dest: source,
+		Proc: "Participant.SnapshotMetadata",
+		Args: snapshotHead,
+		Resp: &header.Metadata,
+	})
+	if err != nil {
+		return
+	}
+	err = p.router.SendMessage(network.Message{
+		Dest: source,
+		Proc: "Participant.SnapshotWalletList",
+		Args: snapshotHead,
+		Resp: &header.WalletList,
+	})
+	if err != nil {
+		return
+	}
+	err = p.router.SendMessage(network.Message{
+		Dest: source,
+		Proc: "Participant.SnapshotWalletHashes",
+		Args: snapshotHead,
+		Resp: &header.WalletHashes,
+	})
+	if err != nil {
+		return
+	}
+	if len(header.WalletHashes) != len(header.WalletList) {
+		err = errSnapshotDisagreement
+	}
+	return
+}
+
+// agreeOnSnapshotHeader fetches the snapshot header from every source at once,
+// and returns the header held by a majority of the sources that responded,
+// along with the sources that hold it.
+func (p *Participant) agreeOnSnapshotHeader(sources []network.Address, snapshotHead uint32) (header snapshotHeader, headerHash siacrypto.Hash, agreeing []network.Address, err error) {
+	type response struct {
+		source network.Address
+		header snapshotHeader
+		err    error
+	}
+	responses := make(chan response, len(sources))
+	for _, source := range sources {
+		go func(source network.Address) {
+			r := response{source: source}
+			r.header, r.err = p.fetchSnapshotHeader(source, snapshotHead)
+			responses <- r
+		}(source)
+	}
+
+	// Group the sources by the hash of the header they provided.
+	headers := make(map[siacrypto.Hash]snapshotHeader)
+	holders := make(map[siacrypto.Hash][]network.Address)
+	var responded int
+	for range sources {
+		r := <-responses
+		if r.err != nil {
+			p.log.Debug("could not fetch snapshot header from", r.source, "-", r.err)
+			continue
+		}
+		h, err2 := siacrypto.HashObject(r.header)
+		if err2 != nil {
+			continue
+		}
+		headers[h] = r.header
+		holders[h] = append(holders[h], r.source)
+		responded++
+	}
+	if responded == 0 {
+		err = errNoSnapshotSources
+		return
+	}
+
+	for h, addresses := range holders {
+		if len(addresses)*2 > responded {
+			header = headers[h]
+			headerHash = h
+			agreeing = addresses
+			return
+		}
+	}
+	err = errSnapshotDisagreement
+	return
+}
+
+// downloadWallets downloads the wallets at the given indices of the header's
+// wallet list, using up to maxSyncWorkers workers. Wallet i is first requested
+// from source i % len(sources), which spreads the load evenly over the
+// sources, and is requested from the remaining sources if the first source
+// fails or provides a wallet that doesn't match the header. Verified wallets
+// are added to the stage as they arrive.
+func (p *Participant) downloadWallets(sources []network.Address, snapshotHead uint32, header snapshotHeader, indices []int, stage *delta.SyncStage) (wallets []state.Wallet, failed []state.WalletID) {
+	jobs := make(chan int)
+	type result struct {
+		index  int
+		wallet state.Wallet
+		err    error
+	}
+	results := make(chan result)
+
+	workers := maxSyncWorkers
+	if len(indices) < workers {
+		workers = len(indices)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range jobs {
+				r := result{index: index}
+				r.wallet, r.err = p.downloadWallet(sources, snapshotHead, header, index)
+				if r.err == nil {
+					r.err = stage.StageWallet(r.wallet)
+				}
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		for _, index := range indices {
+			jobs <- index
+		}
+		close(jobs)
+	}()
+
+	for range indices {
+		r := <-results
+		if r.err != nil {
+			p.log.Warn("could not download wallet", header.WalletList[r.index], "-", r.err)
+			failed = append(failed, header.WalletList[r.index])
+			continue
+		}
+		wallets = append(wallets, r.wallet)
+	}
+	return
+}
+
+// downloadWallet downloads wallet 'index' of the header's wallet list,
+// checking it against the header's wallet hashes.
+func (p *Participant) downloadWallet(sources []network.Address, snapshotHead uint32, header snapshotHeader, index int) (wallet state.Wallet, err error) {
+	swa := SnapshotWalletArg{
+		SnapshotHead: snapshotHead,
+		WalletID:     header.WalletList[index],
+	}
+	err = errNoSnapshotSources
+	for i := range sources {
+		source := sources[(index+i)%len(sources)]
+		err = p.router.SendMessage(network.Message{
+			Dest: source,
+			Proc: "Participant.SnapshotWallet",
+			Args: swa,
+			Resp: &wallet,
+		})
+		if err != nil {
+			continue
+		}
+		var walletHash siacrypto.Hash
+		walletHash, err = siacrypto.HashObject(wallet)
+		if err != nil {
+			continue
+		}
+		if walletHash != header.WalletHashes[index] {
+			err = fmt.Errorf("sibling %v provided a wallet that does not match the snapshot", source)
+			continue
+		}
+		return
+	}
+	return
+}
+
+// downloadSnapshot replaces the state of the engine with the snapshot at
+// 'snapshotHead', downloading it from 'sources'. If some wallets can't be
+// downloaded, an error is returned and the state is left untouched; calling
+// downloadSnapshot again for the same snapshot will only download the wallets
+// that are still missing. downloadSnapshot only locks the engine mutex while
+// it replaces the state, so the caller must not hold it.
+func (p *Participant) downloadSnapshot(sources []network.Address, snapshotHead uint32) (err error) {
+	start := time.Now()
+	header, headerHash, agreeing, err := p.agreeOnSnapshotHeader(sources, snapshotHead)
+	if err != nil {
+		return
+	}
+
+	// Open the stage, and figure out which wallets still need to be
+	// downloaded.
+	stage, staged, err := p.engine.OpenSyncStage(snapshotHead, headerHash)
+	if err != nil {
+		return
+	}
+	defer stage.Close()
+	wallets := make(map[state.WalletID]state.Wallet)
+	for _, w := range staged {
+		wallets[w.ID] = w
+	}
+	var missing []int
+	for i, id := range header.WalletList {
+		if _, exists := wallets[id]; !exists {
+			missing = append(missing, i)
+		}
+	}
+
+	downloaded, failed := p.downloadWallets(agreeing, snapshotHead, header, missing, stage)
+	if len(failed) != 0 {
+		err = fmt.Errorf("could not download %v of %v wallets, the sync can be resumed", len(failed), len(header.WalletList))
+		return
+	}
+	for _, w := range downloaded {
+		wallets[w.ID] = w
+	}
+
+	// Every wallet is available, replace whatever state the engine
+	// currently has.
+	p.engineLock.Lock()
+	defer p.engineLock.Unlock()
+	p.engine.ClearState()
+	p.engine.BootstrapSetMetadata(header.Metadata)
+	for _, id := range header.WalletList {
+		err = p.engine.BootstrapInsertWallet(wallets[id])
+		if err != nil {
+			return
+		}
+	}
+
+	// Event downloading will be implemented later.
+
+	// At this point, saveBlock() in package delta is expecting the active
+	// history file to be available, but this file hasn't been created yet
+	// because the inital values for snapshot weren't established
+	// correctly. It's a bit of a hack and should be refactored at some
+	// point, but we've got to set those variables so that compile(),
+	// saveBlock(), and saveSnapshot() work as expected.
+	err = p.engine.BootstrapJoinSetup()
+	if err != nil {
+		return
+	}
+
+	stage.Close()
+	err = p.engine.RemoveSyncStage(snapshotHead)
+	p.log.Info("snapshot", snapshotHead, "synchronized:", len(header.WalletList), "wallets,", len(staged), "resumed from the stage,", len(agreeing), "sources, took", time.Since(start))
+	return
+}
+
+// recoverSegments recovers the segments of the given wallets, using up to
+// maxSyncWorkers workers. Wallets without a sector are skipped. The wallets
+// whose segments could not be recovered are returned.
+func (p *Participant) recoverSegments(ids []state.WalletID) (failed []state.WalletID) {
+	jobs := make(chan state.WalletID)
+	var wg sync.WaitGroup
+	var failedLock sync.Mutex
+	workers := maxSyncWorkers
+	if len(ids) < workers {
+		workers = len(ids)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				err := p.recoverSegment(id)
+				if err != nil && err != errNoSector {
+					p.log.Warn("could not recover segment for wallet", id, "-", err)
+					failedLock.Lock()
+					failed = append(failed, id)
+					failedLock.Unlock()
+					continue
+				}
+				p.segmentRebuilt()
+			}
+		}()
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	return
+}
@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"github.com/NebulousLabs/Sia/siacrypto"
 	"github.com/NebulousLabs/Sia/state"
 )
 
@@ -34,3 +35,12 @@ func (p *Participant) SnapshotWallet(swa SnapshotWalletArg, wallet *state.Wallet
 	*wallet, err = p.engine.LoadSnapshotWallet(swa.SnapshotHead, swa.WalletID)
 	return
 }
+
+// SnapshotWalletHashes is an RPC that returns the hash of every wallet in the
+// snapshot at a given snapshot head, in the same order as SnapshotWalletList.
+func (p *Participant) SnapshotWalletHashes(snapshotHead uint32, hashes *[]siacrypto.Hash) (err error) {
+	p.engineLock.RLock()
+	*hashes, err = p.engine.LoadSnapshotWalletHashes(snapshotHead)
+	p.engineLock.RUnlock()
+	return
+}
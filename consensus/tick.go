@@ -28,6 +28,14 @@ func (p *Participant) tick() {
 	ticker := time.Tick(StepDuration)
 	p.tickLock.Unlock() // Unlock the mutex before entering the tick loop.
 	for _ = range ticker {
+		// A participant that has left the quorum stops ticking.
+		p.tickLock.RLock()
+		departed := p.departed
+		p.tickLock.RUnlock()
+		if departed {
+			return
+		}
+
 		// Once cryptographic synchronization is implemented, there
 		// will be an additional sleep placed here for some volume of
 		// seconds that will keep the participant synchronized to a
@@ -46,20 +54,51 @@ func (p *Participant) tick() {
 				// Condense the list of updates into a block.
 				block := p.condenseBlock()
 
+				// If a fork was detected during this block, the
+				// block is discarded and the participant
+				// recovers by downloading the quorum's blocks
+				// instead.
+				p.forkLock.Lock()
+				report := p.fork
+				p.fork = nil
+				p.forkLock.Unlock()
+				if report != nil {
+					p.recoverFromFork(report)
+					p.newSignedUpdate()
+					return
+				}
+
 				// Compile the block.
 				p.engineLock.Lock()
 				err := p.engine.Compile(block)
+				p.expectSiblings()
 				p.engineLock.Unlock()
 				if err != nil {
 					fmt.Println(err)
 				}
 
+				// A participant that is no longer a sibling
+				// stops sending updates.
+				if p.checkDeparture() {
+					p.tickLock.Lock()
+					p.departed = true
+					p.tickLock.Unlock()
+					return
+				}
+
 				// Broadcast a new update to the quorum.
 				p.newSignedUpdate()
 			}()
 		} else {
 			p.currentStep++
+			step := p.currentStep
 			p.tickLock.Unlock()
+
+			// Partway through the block, compare parent blocks
+			// with the rest of the quorum.
+			if step == forkCheckStep {
+				go p.checkForFork()
+			}
 		}
 	}
 }
@@ -14,11 +14,11 @@ import (
 // Participant.tick() runs without error when the participant is synchronized
 // to the quorum.
 func TestSynchronizedTick(t *testing.T) {
-	mr, err := network.NewRPCServer(11300)
+	mr, err := network.NewRPCServerWithTransport(network.NewLoopbackNetwork().Transport("localhost"), 11300)
 	if err != nil {
 		t.Fatal(err)
 	}
-	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestSynchronizedTick"), 1, siacrypto.PublicKey{})
+	p, err := CreateBootstrapParticipant(mr, siafiles.TempFilename("TestSynchronizedTick"), "", 1, siacrypto.PublicKey{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -12,6 +12,7 @@ func (e *Engine) SegmentFilename(id state.WalletID) string {
 	return e.state.SectorFilename(id)
 }
 
-func (e *Engine) RepairChan() chan state.WalletID {
-	return e.state.RepairChan
+// RepairQueue returns the queue of segments that need to be repaired.
+func (e *Engine) RepairQueue() *state.RepairQueue {
+	return e.state.Repairs
 }
@@ -12,11 +12,25 @@ const (
 
 // A Heartbeat is the set of information that siblings are required to submit
 // every block. Each block contains an array of [state.QuorumSize] heartbeats,
-// and sets the value to 'nil' if nothing was submitted.
+// and sets the value to 'nil' if nothing was submitted. A sibling that is
+// rebuilding its segments reports its progress instead of a storage proof. A
+// sibling whose address has changed attaches a signed AddressUpdate, which is
+// nil otherwise.
 type Heartbeat struct {
-	ParentBlock  siacrypto.Hash
-	Entropy      state.Entropy
-	StorageProof state.StorageProof
+	ParentBlock   siacrypto.Hash
+	Entropy       state.Entropy
+	StorageProof  state.StorageProof
+	Rebuild       state.RebuildProgress
+	AddressUpdate *state.SignedAddressUpdate
+}
+
+// DefaultHeartbeat returns the heartbeat that the quorum uses for a sibling
+// that fails to provide a heartbeat during its handoff block. It contributes
+// no entropy and proves no storage.
+func DefaultHeartbeat(parentBlock siacrypto.Hash) Heartbeat {
+	return Heartbeat{
+		ParentBlock: parentBlock,
+	}
 }
 
 // A Block contains all the data that is necessary to move the quorum from one
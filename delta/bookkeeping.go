@@ -0,0 +1,91 @@
+package delta
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/NebulousLabs/Sia/siaencoding"
+)
+
+// The engine keeps a few variables outside of the state that are needed to
+// find its snapshots and block histories on disk. They are saved after every
+// compile, so that a participant that restarts can reopen the engine from
+// disk instead of downloading the quorum again. The sibling index is saved
+// along with the rest of the bookkeeping.
+
+// bookkeeping contains the engine variables that are saved to disk.
+type bookkeeping struct {
+	SiblingIndex        byte
+	RecentSnapshot      uint32
+	RecentHistoryHead   uint32
+	ActiveHistoryLength uint32
+}
+
+func (e *Engine) bookkeepingFilename() string {
+	return e.filePrefix + "bookkeeping"
+}
+
+// saveBookkeeping writes the bookkeeping to a temporary file and then moves
+// it into place, so that a crash in the middle of the write leaves the
+// previous bookkeeping intact.
+func (e *Engine) saveBookkeeping() (err error) {
+	encodedBookkeeping, err := siaencoding.Marshal(bookkeeping{
+		SiblingIndex:        e.siblingIndex,
+		RecentSnapshot:      e.state.Metadata.RecentSnapshot,
+		RecentHistoryHead:   e.recentHistoryHead,
+		ActiveHistoryLength: e.activeHistoryLength,
+	})
+	if err != nil {
+		return
+	}
+	tempFilename := e.bookkeepingFilename() + ".tmp"
+	err = ioutil.WriteFile(tempFilename, encodedBookkeeping, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tempFilename, e.bookkeepingFilename())
+	return
+}
+
+// Reopen restores an engine that was previously running with the same file
+// prefix. The state is loaded from the most recent snapshot, and the blocks in
+// the active history are compiled again, which brings the engine back to the
+// height it was at when it stopped. Reopen should be called on an engine that
+// has just been initialized.
+func (e *Engine) Reopen() (err error) {
+	encodedBookkeeping, err := ioutil.ReadFile(e.bookkeepingFilename())
+	if err != nil {
+		return
+	}
+	var bk bookkeeping
+	err = siaencoding.Unmarshal(encodedBookkeeping, &bk)
+	if err != nil {
+		return
+	}
+	e.siblingIndex = bk.SiblingIndex
+	e.recentHistoryHead = bk.RecentHistoryHead
+	e.activeHistoryLength = bk.ActiveHistoryLength
+	e.state.Metadata.RecentSnapshot = bk.RecentSnapshot
+
+	// Load the active history before rolling back, since compiling the
+	// blocks again rewrites the active history file.
+	blocks := make([]Block, bk.ActiveHistoryLength)
+	for i := range blocks {
+		blocks[i], err = e.LoadBlock(bk.RecentSnapshot + uint32(i))
+		if err != nil {
+			return
+		}
+	}
+
+	err = e.RollbackToSnapshot(bk.RecentSnapshot)
+	if err != nil {
+		return
+	}
+	for _, b := range blocks {
+		err = e.Compile(b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestReopen compiles blocks across a snapshot boundary and checks that a new
+// engine with the same file prefix reopens to the same state.
+func TestReopen(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filePrefix := siafiles.TempFilename("TestReopen")
+	var e Engine
+	e.Initialize(nil, filePrefix)
+	err = e.Bootstrap(state.Sibling{
+		WalletID:  1,
+		PublicKey: pk,
+	}, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetSiblingIndex(0)
+	for i := 0; i < SnapshotLength+2; i++ {
+		err = e.Compile(bootstrapBlock(t, &e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reopened Engine
+	reopened.Initialize(nil, filePrefix)
+	err = reopened.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.SiblingIndex() != 0 {
+		t.Error("sibling index was not restored")
+	}
+	if reopened.recentHistoryHead != e.recentHistoryHead || reopened.activeHistoryLength != e.activeHistoryLength {
+		t.Error("history bookkeeping was not restored")
+	}
+	expected, err := siacrypto.HashObject(e.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := siacrypto.HashObject(reopened.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Error("reopened engine has different metadata")
+	}
+	if len(reopened.WalletList()) != len(e.WalletList()) {
+		t.Error("reopened engine has a different wallet list")
+	}
+
+	// The reopened engine should be able to keep compiling.
+	err = reopened.Compile(bootstrapBlock(t, &reopened, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reopened.LoadBlock(reopened.Metadata().Height - 1)
+	if err != nil {
+		t.Error(err)
+	}
+}
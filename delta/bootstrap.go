@@ -35,8 +35,14 @@ func (e *Engine) Bootstrap(sib state.Sibling, tetherWalletPublicKey siacrypto.Pu
 	if err != nil {
 		return
 	}
-	e.AddSibling(&sibWallet, sib)
-	e.state.Metadata.Siblings[0].Status = 0
+
+	// The bootstrap sibling skips the hopeful list and is active
+	// immediately. There are no sectors yet, so it has nothing to rebuild.
+	sib.Status = 0
+	sib.Index = 0
+	sib.WalletID = sibWallet.ID
+	e.state.Metadata.Siblings[0] = sib
+	e.state.Metadata.Rebuilds[0] = state.Rebuild{}
 
 	// Set to SnapshotLength to trigger saving a snapshot at the first
 	// compile.
@@ -47,6 +47,15 @@ func (e *Engine) Compile(b Block) (err error) {
 	var externalEntropy state.Entropy // will be pulled from block
 	e.state.MergeExternalEntropy(externalEntropy)
 
+	// A sibling that takes too long to rebuild its segments is tossed. Every
+	// slot is checked, not just the siblings that sent a heartbeat, so that
+	// a passive sibling can't run out the clock.
+	for i := range e.state.Metadata.Siblings {
+		if e.state.Metadata.RebuildExpired(byte(i)) {
+			e.state.TossSibling(byte(i))
+		}
+	}
+
 	// Next each heartbeat is iterated through and processed, checking that all
 	// the vital information has been correctly assembled.
 	var siblingEntropy []byte
@@ -56,8 +65,16 @@ func (e *Engine) Compile(b Block) (err error) {
 			continue
 		}
 
-		// Verify the signature on the heartbeat.
+		// Verify the signature on the heartbeat. If the signature is bad
+		// during the sibling's handoff block, the sibling most likely has
+		// not finished synchronizing, so the default heartbeat is used in
+		// place of tossing the sibling.
 		verified, err := e.state.Metadata.Siblings[i].PublicKey.VerifyObject(b.HeartbeatSignatures[i], heartbeat)
+		if (err != nil || !verified) && e.state.Metadata.Siblings[i].HandoffBlock(e.state.Metadata.Height) {
+			heartbeat = DefaultHeartbeat(e.state.Metadata.ParentBlock)
+			siblingEntropy = append(siblingEntropy, heartbeat.Entropy[:]...)
+			continue
+		}
 		if err != nil {
 			continue
 		}
@@ -90,7 +107,7 @@ func (e *Engine) Compile(b Block) (err error) {
 		// Verify the parent block of the heartbeat.
 		if heartbeat.ParentBlock != e.state.Metadata.ParentBlock {
 			if debug {
-				fmt.Println("Tossing sibling for invalid parent block")
+				fmt.Println("Demoting sibling for invalid parent block")
 				fmt.Println(e.siblingIndex)
 				fmt.Println(i)
 				fmt.Println(b.Height)
@@ -110,17 +127,35 @@ func (e *Engine) Compile(b Block) (err error) {
 				fmt.Println("Finished printing block.")
 			}
 
-			e.state.TossSibling(byte(i))
+			// A sibling on the wrong parent has most likely forked
+			// away from the quorum. It is demoted instead of tossed
+			// so that it has a chance to recover.
+			e.state.DemoteSibling(byte(i))
 			continue
 		}
 
-		// Verify the storage proof.
-		verified, err = e.state.VerifyStorageProof(byte(i), heartbeat.StorageProof)
-		if err != nil {
-			// Something
+		// Verify the storage proof. Storage proofs are suspended while
+		// the sibling is rebuilding its segments, apart from the proof
+		// that comes with the report that the rebuild is done.
+		if e.state.Metadata.Rebuilding(byte(i)) {
+			e.state.ReportRebuild(byte(i), heartbeat.Rebuild, heartbeat.StorageProof)
 		} else {
-			if !verified {
-				// Also something
+			verified, err = e.state.VerifyStorageProof(byte(i), heartbeat.StorageProof)
+			if err != nil {
+				// Something
+			} else {
+				if !verified {
+					// Also something
+				}
+			}
+		}
+
+		// Move the sibling to its new address. A sibling can only move
+		// itself through its heartbeat.
+		if heartbeat.AddressUpdate != nil && heartbeat.AddressUpdate.AddressUpdate.SiblingIndex == byte(i) {
+			err = e.state.UpdateAddress(*heartbeat.AddressUpdate)
+			if err != nil && debug {
+				fmt.Println("Rejected address update:", err)
 			}
 		}
 
@@ -140,17 +175,21 @@ func (e *Engine) Compile(b Block) (err error) {
 		e.state.AdvanceUpdate(ua)
 	}
 
-	// Process all of the expiring events in the event list. Right now this
-	// just deletes 'known' scripts whose deadlines have passed, but
-	// eventually it can also add additional script inputs to the
-	// processing queue.
+	// Process all of the expiring events in the event list. This deletes
+	// 'known' scripts whose deadlines have passed, resolves sector updates,
+	// and queues the scheduled inputs that are due, which are run before the
+	// script inputs of the block.
 	e.state.ProcessExpiringEvents()
+	for _, si := range e.state.TakeScheduledInputs() {
+		e.ExecuteScheduled(si)
+	}
 
-	// Process all of the script inputs. Right now, every script input is
-	// processed every block, with only a few protections against inifinite loops
-	// and scripting DOS attacks. The future will hold a probabilistic
-	// distribution of resources based on price paid for tickets.
-	for _, si := range b.ScriptInputs {
+	// Process the script inputs that fit into the cost budget of the block,
+	// highest fee density first, see SelectScriptInputs. The participants
+	// only put inputs that fit into their blocks, but the budget is enforced
+	// here as well so that every block is bounded.
+	selected, _ := e.SelectScriptInputs(b.ScriptInputs)
+	for _, si := range selected {
 		e.HandleScriptInput(si)
 	}
 
@@ -166,6 +205,12 @@ func (e *Engine) Compile(b Block) (err error) {
 		}
 	}
 
+	// Advance the departing siblings, and then move any hopefuls into empty
+	// sibling slots. This happens after the passive windows are reduced so
+	// that new siblings are passive for the full SiblingPassiveWindow.
+	e.processDepartures()
+	e.placeHopefuls()
+
 	// Update the metadata of the quorum.
 	blockHash, err := siacrypto.HashObject(b)
 	if err != nil {
@@ -176,5 +221,11 @@ func (e *Engine) Compile(b Block) (err error) {
 	e.state.Metadata.Height++
 	e.state.Metadata.PoStorageSeed = e.state.Metadata.Germ
 
+	// Save the bookkeeping, so that the engine can be reopened at this
+	// height.
+	bookkeepingErr := e.saveBookkeeping()
+	if bookkeepingErr != nil {
+		e.log.Error("failed to save engine bookkeeping:", bookkeepingErr)
+	}
 	return
 }
@@ -0,0 +1,248 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/sialog"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// signAddressUpdate signs an update that moves sibling 0 to 'address'.
+func signAddressUpdate(t *testing.T, sk siacrypto.SecretKey, address network.Address, height uint32) *state.SignedAddressUpdate {
+	au := state.AddressUpdate{
+		Address: address,
+		Height:  height,
+	}
+	signature, err := sk.SignObject(au)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &state.SignedAddressUpdate{AddressUpdate: au, Signature: signature}
+}
+
+// TestAddressUpdate moves the bootstrap sibling to new addresses, first
+// through its heartbeat and then through a script input, and checks that old
+// updates can't move it back.
+func TestAddressUpdate(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestAddressUpdate"))
+	e.SetLogger(sialog.Default)
+	err = e.Bootstrap(state.Sibling{
+		WalletID:  1,
+		PublicKey: pk,
+		Address:   network.Address{Host: "old", Port: 9988, ID: 1},
+	}, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = e.Compile(bootstrapBlock(t, &e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Move the sibling through its heartbeat.
+	heartbeatAddress := network.Address{Host: "heartbeat", Port: 9988, ID: 1}
+	staleUpdate := signAddressUpdate(t, sk, network.Address{Host: "stale", Port: 9988, ID: 1}, 1)
+	b := bootstrapBlock(t, &e, sk)
+	b.Heartbeats[0].AddressUpdate = signAddressUpdate(t, sk, heartbeatAddress, e.Metadata().Height)
+	b.HeartbeatSignatures[0], err = sk.SignObject(b.Heartbeats[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Compile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Metadata().Siblings[0].Address != heartbeatAddress {
+		t.Fatal("heartbeat did not move the sibling:", e.Metadata().Siblings[0].Address)
+	}
+
+	// Move the sibling through a script input submitted by another wallet.
+	err = e.state.InsertWallet(state.Wallet{
+		ID:      2,
+		Balance: state.NewBalance(1000),
+		Script:  []byte{0x38}, // transfer control to input
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit := func(sau *state.SignedAddressUpdate) error {
+		encodedUpdate, err := siaencoding.Marshal(*sau)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Execute(state.ScriptInput{
+			WalletID: 2,
+			Input: append([]byte{
+				0xE6, 0xFD, // move data pointer past the marker
+				0xE4, //       push the update
+				0x47, //       update address
+				0xFF, //       exit
+				0xFD, //       marker
+			}, encodedUpdate...),
+		})
+	}
+	err = submit(staleUpdate)
+	if err == nil || e.Metadata().Siblings[0].Address != heartbeatAddress {
+		t.Error("an old address update was accepted")
+	}
+	forged := signAddressUpdate(t, sk, network.Address{Host: "forged", Port: 9988, ID: 1}, e.Metadata().Height)
+	forged.AddressUpdate.Address.Port++
+	err = submit(forged)
+	if err == nil || e.Metadata().Siblings[0].Address != heartbeatAddress {
+		t.Error("an address update with a bad signature was accepted")
+	}
+	scriptAddress := network.Address{Host: "script", Port: 9988, ID: 1}
+	err = submit(signAddressUpdate(t, sk, scriptAddress, e.Metadata().Height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Metadata().Siblings[0].Address != scriptAddress {
+		t.Error("script input did not move the sibling:", e.Metadata().Siblings[0].Address)
+	}
+}
+
+// TestScheduledInputs schedules inputs for two wallets, one directly and one
+// through a script, and checks that the inputs run at the scheduled heights
+// with the wallet as their own caller.
+func TestScheduledInputs(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestScheduledInputs"))
+	e.SetLogger(sialog.Default)
+	err = e.Bootstrap(state.Sibling{
+		WalletID:  1,
+		PublicKey: pk,
+	}, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = e.Compile(bootstrapBlock(t, &e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wallet 2 only runs inputs that it scheduled itself, and wallet 3 runs
+	// any input.
+	selfScript := appendAll(
+		[]byte{
+			0x4B,             // push caller
+			0x33, 0xF8, 0xFF, // move data pointer to own id
+			0x34, 0x08, //       push own id
+			0x16, //             equal
+			0xE5, //             if not equal, reject
+			0x38, //             execute input
+		},
+		siaencoding.EncUint64(2),
+	)
+	e.state.InsertWallet(state.Wallet{ID: 2, Balance: state.NewBalance(1000), Script: selfScript}, true)
+	e.state.InsertWallet(state.Wallet{ID: 3, Balance: state.NewBalance(100000), Script: []byte{0x38}}, true)
+	e.state.InsertWallet(state.Wallet{ID: 4, Balance: state.NewBalance(1000)}, true)
+	e.state.InsertWallet(state.Wallet{ID: 5, Balance: state.NewBalance(1000)}, true)
+	checkBalance := func(id state.WalletID, expected uint64) {
+		w, err := e.state.LoadWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(expected) {
+			t.Fatal("expecting wallet", id, "to have a balance of", expected, "got", w.Balance)
+		}
+	}
+
+	// Schedule a payment from wallet 2 at heights 4 and 6, prepaying for
+	// both runs.
+	o := e.state.NewOverlay()
+	w, err := o.LoadWallet(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Schedule(o, &w, state.ScheduledInput{
+		Height:    4,
+		Period:    2,
+		Repeats:   1,
+		CostLimit: 100,
+		Input:     SendCoinInput(4, state.NewBalance(10)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(2, 1000-2*ScheduleCost)
+
+	// Inputs can't be scheduled for the current height.
+	o = e.state.NewOverlay()
+	err = e.Schedule(o, &w, state.ScheduledInput{Height: 2})
+	if err == nil {
+		t.Error("able to schedule an input for the current height")
+	}
+
+	// Schedule a payment from wallet 3 at height 3 through its script.
+	input, err := ScheduleInput(state.ScheduledInput{
+		Height:    3,
+		CostLimit: 100,
+		Input:     SendCoinInput(4, state.NewBalance(5)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Execute(state.ScriptInput{WalletID: 3, Input: input, CostLimit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wallet 4 receives the payments, and wallet 5 is paying the same
+	// storage fees without receiving anything.
+	expected := []uint64{0, 5, 15, 15, 25, 25}
+	for i, received := range expected {
+		err = e.Compile(bootstrapBlock(t, &e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Metadata().Height != uint32(i+3) {
+			t.Fatal("unexpected height", e.Metadata().Height)
+		}
+		w4, err := e.state.LoadWallet(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w5, err := e.state.LoadWallet(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w4.Balance.Subtract(w5.Balance)
+		if w4.Balance != state.NewBalance(received) {
+			t.Fatal("expecting wallet 4 to have received", received, "by height", i+3, "got", w4.Balance)
+		}
+	}
+	w, err = e.state.LoadWallet(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.ScheduledInputs) != 0 {
+		t.Error("scheduled input was not removed after its last run")
+	}
+}
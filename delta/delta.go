@@ -36,10 +36,16 @@ type Engine struct {
 	log *sialog.Logger
 }
 
+// Initialize sets up the engine and its state, opening the repair queue that
+// is kept under the file prefix.
 func (e *Engine) Initialize(logger *sialog.Logger, filePrefix string) {
 	e.SetLogger(logger)
 	e.SetFilePrefix(filePrefix)
 	e.state.Initialize()
+	err := e.state.Repairs.Open(filePrefix + "repairs")
+	if err != nil {
+		e.log.Error("failed to open repair queue:", err)
+	}
 }
 
 func (e *Engine) SetLogger(logger *sialog.Logger) {
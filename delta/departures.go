@@ -0,0 +1,81 @@
+package delta
+
+import (
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// refundCollateral returns a sibling's collateral to the wallet that the
+// sibling is tethered to.
+func (e *Engine) refundCollateral(sib state.Sibling) {
+	w, err := e.state.LoadWallet(sib.WalletID)
+	if err != nil {
+		e.log.Error("failed to load wallet for collateral refund:", err)
+		return
+	}
+	w.Balance.Add(sib.Collateral)
+	err = e.state.SaveWallet(w)
+	if err != nil {
+		e.log.Error("failed to save wallet for collateral refund:", err)
+	}
+}
+
+// placeSuccessor moves the successor of slot i into slot i. The successor
+// starts as a passive sibling, the same way as a hopeful placed by
+// placeHopefuls. If the departed sibling had been tossed, or had not finished
+// rebuilding, the successor has to rebuild the slot's segments.
+func (e *Engine) placeSuccessor(i byte) {
+	sib := e.state.Metadata.Successors[i].Sibling
+	sib.Status = state.SiblingPassiveWindow
+	sib.ForkStrikes = 0
+	sib.Departing = 0
+	sib.JoinHeight = e.state.Metadata.Height
+	sib.AddressHeight = e.state.Metadata.Height
+	sib.Index = i
+	e.state.Metadata.Siblings[i] = sib
+	e.state.Metadata.Successors[i] = state.Hopeful{}
+	e.state.ScheduleRebuild(i)
+}
+
+// processDepartures is called at the end of each compile, before hopefuls are
+// placed. Each departing sibling without a successor recruits the first
+// hopeful on the hopeful list. When a sibling's departure window runs out, its
+// collateral is refunded and its successor takes over its slot, which means
+// the successor is responsible for the same segments as the departed sibling.
+// If no hopeful was available during the whole window, the slot is left
+// empty.
+//
+// If a departing sibling gets tossed before it finishes departing, its
+// successor takes over the empty slot right away.
+func (e *Engine) processDepartures() {
+	for i := range e.state.Metadata.Siblings {
+		sib := &e.state.Metadata.Siblings[i]
+		if sib.Inactive() {
+			if !e.state.Metadata.Successors[i].Empty() {
+				e.placeSuccessor(byte(i))
+			}
+			continue
+		}
+		if sib.Departing == 0 {
+			continue
+		}
+
+		// Recruit a successor from the front of the hopeful list.
+		if e.state.Metadata.Successors[i].Empty() && !e.state.Metadata.Hopefuls[0].Empty() {
+			e.state.Metadata.Successors[i] = e.state.Metadata.Hopefuls[0]
+			copy(e.state.Metadata.Hopefuls[:], e.state.Metadata.Hopefuls[1:])
+			e.state.Metadata.Hopefuls[state.MaxHopefuls-1] = state.Hopeful{}
+		}
+
+		sib.Departing--
+		if sib.Departing != 0 {
+			continue
+		}
+
+		e.refundCollateral(*sib)
+		if e.state.Metadata.Successors[i].Empty() {
+			e.state.TossSibling(byte(i))
+		} else {
+			e.placeSuccessor(byte(i))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestDepartures has one sibling leave with a successor recruited from the
+// hopeful list, and another sibling leave through a signed script input with
+// no successor available, checking that collateral is charged and refunded.
+func TestDepartures(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tetherPK, tetherSK, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestDepartures"))
+	err = e.Bootstrap(state.Sibling{
+		WalletID:  1,
+		PublicKey: pk,
+	}, tetherPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := e.Wallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initialBalance := w.Balance
+	o := e.state.NewOverlay()
+
+	// Only the tether wallet of an existing sibling can make it leave.
+	err = e.LeaveSibling(o, &w, 1)
+	if err != errNotTethered {
+		t.Error("expecting errNotTethered for an empty slot, got", err)
+	}
+	err = e.LeaveSibling(o, &state.Wallet{ID: 2}, 0)
+	if err != errNotTethered {
+		t.Error("expecting errNotTethered for the wrong wallet, got", err)
+	}
+
+	// Add two hopefuls and have the bootstrap sibling leave. The first
+	// hopeful should be recruited as the successor, and the second should
+	// be placed into an empty slot.
+	for i := byte(1); i <= 2; i++ {
+		err = e.AddSibling(o, &w, state.Sibling{PublicKey: siacrypto.PublicKey{i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = e.LeaveSibling(o, &w, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.LeaveSibling(o, &w, 0)
+	if err != errDeparting {
+		t.Error("expecting errDeparting, got", err)
+	}
+	charged := initialBalance
+	charged.Subtract(state.NewBalance(2 * SiblingCollateral))
+	if w.Balance != charged {
+		t.Error("expecting collateral to be charged for both hopefuls")
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.Compile(bootstrapBlock(t, &e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := e.Metadata()
+	if md.Successors[0].Sibling.PublicKey[0] != 1 {
+		t.Fatal("first hopeful was not recruited as the successor")
+	}
+	var slot byte
+	for i, sib := range md.Siblings {
+		if sib.PublicKey[0] == 2 {
+			slot = byte(i)
+		}
+	}
+	if slot == 0 {
+		t.Fatal("second hopeful was not placed")
+	}
+
+	// Have the second sibling leave using a script input. There are no
+	// hopefuls left, so it gets no successor.
+	si, err := LeaveSiblingInput(1, e.Metadata().Height, slot, tetherSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bootstrapBlock(t, &e, sk)
+	b.ScriptInputs = append(b.ScriptInputs, si)
+	err = e.Compile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Siblings[slot].IsDeparting() {
+		t.Fatal("leave request did not start the departure")
+	}
+
+	// The bootstrap sibling finishes departing, and its successor takes
+	// over the slot.
+	err = e.Compile(bootstrapBlock(t, &e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md = e.Metadata()
+	if md.Siblings[0].PublicKey[0] != 1 || md.Siblings[0].Status != state.SiblingPassiveWindow || md.Siblings[0].Index != 0 {
+		t.Error("successor did not take over the slot:", md.Siblings[0])
+	}
+	if !md.Successors[0].Empty() {
+		t.Error("successor was not cleared after taking over")
+	}
+
+	// The second sibling finishes departing, leaving its slot empty and
+	// getting its collateral back.
+	w, err = e.Wallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := w.Balance
+	expected.Add(state.NewBalance(SiblingCollateral))
+	err = e.Compile(bootstrapBlock(t, &e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Siblings[slot].Inactive() {
+		t.Error("departed sibling without a successor still has a slot")
+	}
+	w, err = e.Wallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Balance != expected {
+		t.Error("expecting collateral refund to bring the balance to", expected, "got", w.Balance)
+	}
+}
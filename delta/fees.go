@@ -0,0 +1,94 @@
+package delta
+
+import (
+	"sort"
+
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// Every block has a budget for the cost of the script inputs that it runs,
+// which keeps a flood of script inputs from making a block take too long to
+// compile. An input counts against the budget with its whole cost limit,
+// whether it uses all of it or not, so that the inputs of a block can be
+// selected before any of them are run.
+//
+// Inputs are selected by fee density, which is the fee of the input divided
+// by its cost limit. The inputs that pay the most for each unit of cost are
+// selected first, and an input that doesn't fit into what is left of the
+// budget is skipped in favor of smaller inputs that do. Inputs with the same
+// fee density keep the order that they have in the block. Inputs that aren't
+// selected are left for the participants to resubmit in a later block, until
+// their deadline passes.
+//
+// The fee of an input is only checked by the script of its wallet, so an input
+// only competes if its wallet can pay the fee, along with the fees of the
+// inputs of the same wallet that were selected before it. Inputs whose fees
+// can't be paid are dropped. A fee is only paid if the script of the input
+// exits successfully, see Engine.Execute, so the participants dry run an input
+// with a fee before they queue it, and refuse it unless its script accepts it.
+// Otherwise anyone could crowd the inputs of others out of a block with fees
+// that no wallet ever agreed to pay.
+//
+// Scheduled inputs are prepaid, see Engine.Schedule, and don't count against
+// the budget.
+
+const (
+	// MaxBlockCost is the total cost limit of the script inputs run in a
+	// single block.
+	MaxBlockCost = 32 * maxCost
+)
+
+// byFeeDensity sorts script inputs by fee density, highest first.
+type byFeeDensity []state.ScriptInput
+
+func (s byFeeDensity) Len() int      { return len(s) }
+func (s byFeeDensity) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Less compares fee densities by cross-multiplying, so that no precision is
+// lost to division.
+func (s byFeeDensity) Less(i, j int) bool {
+	a := s[i].Fee
+	a.Multiply(state.NewBalance(uint64(cappedCost(s[j].CostLimit))))
+	b := s[j].Fee
+	b.Multiply(state.NewBalance(uint64(cappedCost(s[i].CostLimit))))
+	return a.Compare(b) > 0
+}
+
+// SelectScriptInputs picks the script inputs that fit into the cost budget of
+// a block, by fee density. 'selected' is in the order that the inputs should
+// be run, and 'deferred' holds the inputs that didn't fit. Inputs whose wallets
+// can't pay their fees are in neither. Selecting from an already selected set
+// of inputs selects all of them in the same order, as long as the balances of
+// the wallets haven't dropped in between.
+func (e *Engine) SelectScriptInputs(inputs []state.ScriptInput) (selected, deferred []state.ScriptInput) {
+	sorted := make([]state.ScriptInput, len(inputs))
+	copy(sorted, inputs)
+	sort.Stable(byFeeDensity(sorted))
+
+	balances := make(map[state.WalletID]state.Balance)
+	budget := MaxBlockCost
+	for _, si := range sorted {
+		balance, loaded := balances[si.WalletID]
+		if !loaded {
+			w, err := e.state.LoadWallet(si.WalletID)
+			if err != nil {
+				continue
+			}
+			balance = w.Balance
+		}
+		if balance.Compare(si.Fee) < 0 {
+			continue
+		}
+
+		cost := cappedCost(si.CostLimit)
+		if cost > budget {
+			deferred = append(deferred, si)
+			continue
+		}
+		budget -= cost
+		balance.Subtract(si.Fee)
+		balances[si.WalletID] = balance
+		selected = append(selected, si)
+	}
+	return
+}
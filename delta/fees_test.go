@@ -0,0 +1,64 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestSelectScriptInputs checks that script inputs are selected by fee
+// density within the block budget, that only inputs whose fees can be paid
+// are selected, and that selection is stable.
+func TestSelectScriptInputs(t *testing.T) {
+	e, _ := initEnv()
+
+	// Fill more than the budget with free inputs of the default cost
+	// limit, then add a small input and two paying inputs.
+	var inputs []state.ScriptInput
+	for i := 0; i < MaxBlockCost/maxCost+2; i++ {
+		inputs = append(inputs, state.ScriptInput{WalletID: 1, Deadline: uint32(i)})
+	}
+	small := state.ScriptInput{WalletID: 1, Deadline: 100, CostLimit: 10}
+	dense := state.ScriptInput{WalletID: 1, Deadline: 101, CostLimit: 10, Fee: state.NewBalance(10)}
+	large := state.ScriptInput{WalletID: 1, Deadline: 102, Fee: state.NewBalance(1000)}
+	inputs = append(inputs, small, dense, large)
+
+	// Inputs with fees that their wallets can't pay, along with the fees
+	// of the inputs selected before them, don't compete.
+	paid := state.ScriptInput{WalletID: 1, Deadline: 103, CostLimit: 1, Fee: state.NewBalance(8000)}
+	unpaid := state.ScriptInput{WalletID: 1, Deadline: 104, CostLimit: 1, Fee: state.NewBalance(8000)}
+	missing := state.ScriptInput{WalletID: 2, Deadline: 105, CostLimit: 1, Fee: state.NewBalance(1)}
+	inputs = append(inputs, paid, unpaid, missing)
+
+	selected, deferred := e.SelectScriptInputs(inputs)
+	if len(selected) != MaxBlockCost/maxCost+2 || len(deferred) != 4 {
+		t.Fatal("unexpected selection sizes:", len(selected), len(deferred))
+	}
+
+	// 'paid' has the highest fee density, and leaves the wallet enough to
+	// pay for 'dense' and 'large' but not for 'unpaid'. 'dense' pays 1 per
+	// unit of cost and 'large' pays 0.1, so 'dense' goes next. The small
+	// free input fits into the room left by 'paid' and 'dense'.
+	if selected[0].Deadline != paid.Deadline || selected[1].Deadline != dense.Deadline || selected[2].Deadline != large.Deadline {
+		t.Error("paying inputs were not selected first:", selected[0], selected[1], selected[2])
+	}
+	for i, si := range selected[3 : len(selected)-1] {
+		if si.Deadline != uint32(i) {
+			t.Fatal("free inputs did not keep their order")
+		}
+	}
+	if selected[len(selected)-1].Deadline != small.Deadline {
+		t.Error("small input did not fill the remaining budget")
+	}
+
+	// Selecting again keeps everything, in the same order.
+	reselected, deferred := e.SelectScriptInputs(selected)
+	if len(deferred) != 0 || len(reselected) != len(selected) {
+		t.Fatal("selection is not stable")
+	}
+	for i := range selected {
+		if reselected[i].Deadline != selected[i].Deadline {
+			t.Fatal("selection is not stable")
+		}
+	}
+}
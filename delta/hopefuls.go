@@ -0,0 +1,62 @@
+package delta
+
+import (
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// placeHopefuls is called at the end of each compile, and moves hopefuls into
+// empty sibling slots. Hopefuls are considered in the order that they appear
+// on the hopeful list, and the slot that each hopeful lands in is chosen
+// using the germ, which means that every sibling will make the same placement
+// but nobody can pick their slot ahead of time.
+//
+// Placed hopefuls start as passive siblings, and become active after
+// SiblingPassiveWindow blocks. Hopefuls that have not been placed by their
+// deadline are dropped from the list, and their collateral is refunded. A
+// hopeful placed into a slot whose segments were lost is scheduled to rebuild
+// them.
+func (e *Engine) placeHopefuls() {
+	var remaining []state.Hopeful
+	for i, h := range e.state.Metadata.Hopefuls {
+		if h.Empty() {
+			continue
+		}
+
+		// Find all of the empty sibling slots.
+		var emptySlots []byte
+		for j, sib := range e.state.Metadata.Siblings {
+			if sib.Inactive() {
+				emptySlots = append(emptySlots, byte(j))
+			}
+		}
+		if len(emptySlots) == 0 {
+			if h.Deadline >= e.state.Metadata.Height {
+				remaining = append(remaining, h)
+			} else {
+				e.refundCollateral(h.Sibling)
+			}
+			continue
+		}
+
+		// Use the germ to pick a slot.
+		seed := siacrypto.HashBytes(append(e.state.Metadata.Germ[:], byte(i)))
+		slot := emptySlots[siaencoding.DecUint64(seed[:8])%uint64(len(emptySlots))]
+
+		sib := h.Sibling
+		sib.Status = state.SiblingPassiveWindow
+		sib.ForkStrikes = 0
+		sib.JoinHeight = e.state.Metadata.Height
+		sib.AddressHeight = e.state.Metadata.Height
+		sib.Index = slot
+		e.state.Metadata.Siblings[slot] = sib
+		e.state.ScheduleRebuild(slot)
+	}
+
+	// Rebuild the hopeful list out of the hopefuls that are still waiting,
+	// preserving their order.
+	var hopefuls [state.MaxHopefuls]state.Hopeful
+	copy(hopefuls[:], remaining)
+	e.state.Metadata.Hopefuls = hopefuls
+}
@@ -0,0 +1,159 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// bootstrapBlock returns the next block for an engine, containing a signed
+// heartbeat for the bootstrap sibling so that it doesn't get tossed.
+func bootstrapBlock(t *testing.T, e *Engine, sk siacrypto.SecretKey) (b Block) {
+	b.Height = e.Metadata().Height
+	b.ParentBlock = e.Metadata().ParentBlock
+	b.Heartbeats[0] = Heartbeat{ParentBlock: b.ParentBlock}
+	signature, err := sk.SignObject(b.Heartbeats[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.HeartbeatSignatures[0] = signature
+	return
+}
+
+// TestHopefulPlacement adds hopefuls to a bootstrapped engine and checks that
+// they are placed into distinct empty slots, become active after the passive
+// window, and that placement is deterministic.
+func TestHopefulPlacement(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var engines [2]Engine
+	for i := range engines {
+		e := &engines[i]
+		e.Initialize(nil, siafiles.TempFilename("TestHopefulPlacement"))
+		err := e.Bootstrap(state.Sibling{
+			WalletID:  1,
+			PublicKey: pk,
+		}, siacrypto.PublicKey{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := e.Wallet(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := e.state.NewOverlay()
+		for j := byte(1); j < state.QuorumSize; j++ {
+			err = e.AddSibling(o, &w, state.Sibling{PublicKey: siacrypto.PublicKey{j}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Adding a hopeful twice, or adding a hopeful to a full list,
+		// should fail.
+		err = e.AddSibling(o, &w, state.Sibling{PublicKey: siacrypto.PublicKey{1}})
+		if err != errKnownSibling {
+			t.Error("expecting errKnownSibling, got", err)
+		}
+		err = e.AddSibling(o, &w, state.Sibling{PublicKey: siacrypto.PublicKey{9}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = e.AddSibling(o, &w, state.Sibling{PublicKey: siacrypto.PublicKey{10}})
+		if err != errNoEmptyHopefuls {
+			t.Error("expecting errNoEmptyHopefuls, got", err)
+		}
+		err = o.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = e.Compile(bootstrapBlock(t, e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both engines should have made the same placement.
+	if engines[0].Metadata().Siblings != engines[1].Metadata().Siblings {
+		t.Error("hopeful placement is not deterministic")
+	}
+
+	e := &engines[0]
+	seen := make(map[byte]bool)
+	for i, sib := range e.Metadata().Siblings {
+		if i == 0 {
+			continue
+		}
+		if sib.Status != state.SiblingPassiveWindow {
+			t.Error("placed sibling is not passive:", sib.Status)
+		}
+		if sib.Index != byte(i) || sib.WalletID != 1 || sib.JoinHeight != 0 {
+			t.Error("placed sibling has bad fields:", sib)
+		}
+		if sib.PublicKey[0] == 0 || seen[sib.PublicKey[0]] {
+			t.Error("hopeful placed more than once, or not at all")
+		}
+		seen[sib.PublicKey[0]] = true
+	}
+
+	// The hopeful that didn't fit should still be waiting.
+	md := e.Metadata()
+	if md.Hopefuls[0].Sibling.PublicKey[0] != 9 || !md.Hopefuls[1].Empty() {
+		t.Error("unplaced hopeful was not kept on the hopeful list")
+	}
+
+	// Compile until the new siblings are active. The handoff block is the
+	// first block in which they are active.
+	for i := uint32(1); i <= state.SiblingPassiveWindow; i++ {
+		err := e.Compile(bootstrapBlock(t, e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sib := e.Metadata().Siblings[1]
+	if !sib.Active() {
+		t.Fatal("sibling not active after the passive window")
+	}
+	if !sib.HandoffBlock(e.Metadata().Height) {
+		t.Error("expecting block", e.Metadata().Height, "to be the handoff block")
+	}
+
+	// Compiling the handoff block without heartbeats should use the default
+	// heartbeats rather than tossing the new siblings.
+	err = e.Compile(bootstrapBlock(t, e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < int(state.QuorumSize); i++ {
+		if !e.Metadata().Siblings[i].Active() {
+			t.Error("sibling", i, "was tossed during its handoff block")
+		}
+	}
+
+	// After the handoff, missing heartbeats get the siblings tossed.
+	err = e.Compile(bootstrapBlock(t, e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Siblings[1].Inactive() {
+		t.Error("sibling without a heartbeat was not tossed after the handoff block")
+	}
+
+	// The waiting hopeful should have been placed into a freed slot.
+	var placed bool
+	for _, sib := range e.Metadata().Siblings {
+		if sib.PublicKey[0] == 9 {
+			placed = true
+		}
+	}
+	if !placed {
+		t.Error("waiting hopeful was not placed after slots opened up")
+	}
+}
@@ -9,6 +9,12 @@ import (
 	"github.com/NebulousLabs/Sia/state"
 )
 
+const (
+	// verifyCost is the cost of checking a single signature. check_multisig
+	// pays it for every public key that it tries.
+	verifyCost = 9
+)
+
 var (
 	errExit     = errors.New("exited")
 	errRejected = errors.New("rejected input")
@@ -63,12 +69,28 @@ var opTable = map[byte]instruction{
 	0x37: instruction{"data_paste", 1, op_data_paste, 2},
 	0x38: instruction{"transfer", 0, op_transfer, 1},
 	// function opcodes
-	0x40: instruction{"verify", 0, op_verify, 9},
+	0x40: instruction{"verify", 0, op_verify, verifyCost},
 	0x41: instruction{"add_sibling", 0, op_add_sibling, 5},
-	0x42: instruction{"add_wallet", 0, op_add_wallet, 5},
 	0x43: instruction{"send", 0, op_send, 5},
 	0x44: instruction{"update_sector", 0, op_update_sector, 9},
+	0x45: instruction{"leave_sibling", 0, op_leave_sibling, 5},
 	0x46: instruction{"deadline", 0, op_deadline, 2},
+	0x47: instruction{"update_address", 0, op_update_address, 9},
+	0x48: instruction{"hash", 0, op_hash, 3},
+	0x49: instruction{"check_multisig", 0, op_check_multisig, verifyCost},
+	0x4B: instruction{"caller", 0, op_caller, 2},
+	0x4C: instruction{"schedule", 0, op_schedule, 9},
+	0x4D: instruction{"fee", 0, op_fee, 2},
+	// introspection opcodes
+	0x50: instruction{"height", 0, op_height, 2},
+	0x51: instruction{"balance", 0, op_balance, 2},
+	0x52: instruction{"sector_atoms", 0, op_sector_atoms, 2},
+	0x53: instruction{"sector_hashset", 0, op_sector_hashset, 3},
+	0x54: instruction{"storage_price", 0, op_storage_price, 2},
+	0x55: instruction{"script_price", 0, op_script_price, 2},
+	0x56: instruction{"sibling_status", 0, op_sibling_status, 2},
+	0x57: instruction{"less_balance", 0, op_less_balance, 3},
+	0x58: instruction{"greater_balance", 0, op_greater_balance, 3},
 	// convenience opcodes
 	0xE0: instruction{"switch", 2, op_switch, 3},
 	0xE1: instruction{"store_prefix", 1, op_store_prefix, 2},
@@ -82,6 +104,22 @@ var opTable = map[byte]instruction{
 	0xFF: instruction{"exit", 0, op_exit, 0},
 }
 
+// op_call runs scripts through opTable, and the wallet opcodes verify scripts
+// through opTable, so they are added to the table during init to avoid an
+// initialization loop. opcodeMap is built from the whole table right after,
+// so that it doesn't depend on the order that the files are initialized in.
+func init() {
+	opTable[0x42] = instruction{"add_wallet", 0, op_add_wallet, 5}
+	opTable[0x4A] = instruction{"call", 0, op_call, 9}
+	opTable[0x4E] = instruction{"add_verified_wallet", 0, op_add_verified_wallet, 9}
+
+	// build name -> opcode map
+	opcodeMap = make(map[string]byte)
+	for opcode, op := range opTable {
+		opcodeMap[op.name] = opcode
+	}
+}
+
 // helper functions
 
 func v2i(b []byte) int64 {
@@ -94,6 +132,18 @@ func i2v(i int64) []byte {
 	return siaencoding.EncInt64(i)
 }
 
+// v2bal turns a stack value into a Balance. Shorter values are zero-padded
+// like they are for v2i, but values that are longer than a Balance are
+// rejected instead of being cut short.
+func v2bal(b []byte) (bal state.Balance, err error) {
+	if len(b) > len(bal) {
+		err = errors.New("invalid parameter")
+		return
+	}
+	copy(bal[:], b)
+	return
+}
+
 func v2f(b []byte) float64 {
 	p := make([]byte, 8)
 	copy(p, b)
@@ -552,11 +602,35 @@ func op_add_sibling(env *scriptEnv, args []byte) (err error) {
 		return
 	}
 
-	env.engine.AddSibling(env.wallet, sib)
+	env.engine.AddSibling(env.overlay, env.wallet, sib)
+	return
+}
+
+func op_leave_sibling(env *scriptEnv, args []byte) (err error) {
+	index, err := env.pop()
+	if err != nil {
+		return
+	}
+	if len(index) != 1 {
+		err = errors.New("invalid parameter")
+		return
+	}
+
+	err = env.engine.LeaveSibling(env.overlay, env.wallet, index[0])
 	return
 }
 
 func op_add_wallet(env *scriptEnv, args []byte) (err error) {
+	return addWallet(env, false)
+}
+
+func op_add_verified_wallet(env *scriptEnv, args []byte) (err error) {
+	return addWallet(env, true)
+}
+
+// addWallet pops the arguments of add_wallet and add_verified_wallet, which
+// only differ in whether the script of the new wallet is verified.
+func addWallet(env *scriptEnv, verify bool) (err error) {
 	// pop values
 	script, _ := env.pop()
 	balb, _ := env.pop()
@@ -573,7 +647,7 @@ func op_add_wallet(env *scriptEnv, args []byte) (err error) {
 	id := state.WalletID(siaencoding.DecUint64(encUint64))
 
 	// call API function
-	return env.engine.CreateWallet(env.wallet, id, bal, script)
+	return env.engine.CreateWallet(env.overlay, env.wallet, id, bal, script, verify)
 }
 
 func op_send(env *scriptEnv, args []byte) (err error) {
@@ -587,7 +661,7 @@ func op_send(env *scriptEnv, args []byte) (err error) {
 	copy(bal[:], balb)
 	id := state.WalletID(siaencoding.DecUint64(idb))
 
-	err = env.engine.SendCoin(env.wallet, bal, id)
+	err = env.engine.SendCoin(env.overlay, env.wallet, bal, id)
 	return
 }
 
@@ -611,6 +685,104 @@ func op_verify(env *scriptEnv, args []byte) (err error) {
 	return
 }
 
+func op_hash(env *scriptEnv, args []byte) (err error) {
+	v, err := env.pop()
+	if err != nil {
+		return
+	}
+	h := siacrypto.HashBytes(v)
+	err = env.push(h[:])
+	return
+}
+
+// op_check_multisig checks that at least M of the popped signatures sign the
+// message, each with a different one of the popped public keys. The keys and
+// signatures are concatenated, and the signatures must be in the same order
+// as their keys. Every key after the first costs another verification.
+func op_check_multisig(env *scriptEnv, args []byte) (err error) {
+	msg, _ := env.pop()
+	sigBytes, _ := env.pop()
+	keyBytes, _ := env.pop()
+	mv, err := env.pop()
+	if err != nil {
+		return
+	}
+
+	m := v2i(mv)
+	if len(sigBytes)%siacrypto.SignatureSize != 0 ||
+		len(keyBytes)%siacrypto.PublicKeySize != 0 ||
+		m < 1 || m > int64(len(keyBytes)/siacrypto.PublicKeySize) {
+		err = errors.New("invalid parameter")
+		return
+	}
+
+	// Each signature is checked against the keys that follow the key of the
+	// previous signature, so no key can sign twice.
+	var signed int64
+	for tried := 0; len(sigBytes) > 0 && len(keyBytes) > 0; tried++ {
+		if tried > 0 {
+			err = env.deductCost(verifyCost)
+			if err != nil {
+				return
+			}
+		}
+		var pk siacrypto.PublicKey
+		copy(pk[:], keyBytes)
+		var sig siacrypto.Signature
+		copy(sig[:], sigBytes)
+		keyBytes = keyBytes[siacrypto.PublicKeySize:]
+		if pk.Verify(sig, msg) {
+			signed++
+			sigBytes = sigBytes[siacrypto.SignatureSize:]
+		}
+	}
+
+	// push success value
+	err = env.push(b2v(len(sigBytes) == 0 && signed >= m))
+	return
+}
+
+func op_call(env *scriptEnv, args []byte) (err error) {
+	input, _ := env.pop()
+	idb, _ := env.pop()
+	limit, err := env.pop()
+	if err != nil {
+		return
+	}
+
+	encUint64 := make([]byte, 8)
+	copy(encUint64, idb)
+	id := state.WalletID(siaencoding.DecUint64(encUint64))
+	success, err := env.call(id, input, int(v2i(limit)))
+	if err != nil {
+		return
+	}
+
+	// push success value
+	err = env.push(b2v(success))
+	return
+}
+
+func op_caller(env *scriptEnv, args []byte) (err error) {
+	return env.push(env.caller)
+}
+
+func op_schedule(env *scriptEnv, args []byte) (err error) {
+	encSchedule, err := env.pop()
+	if err != nil {
+		return
+	}
+
+	var si state.ScheduledInput
+	err = siaencoding.Unmarshal(encSchedule, &si)
+	if err != nil {
+		return
+	}
+
+	err = env.engine.Schedule(env.overlay, env.wallet, si)
+	return
+}
+
 func op_update_sector(env *scriptEnv, args []byte) (err error) {
 	deadline, _ := env.pop()
 	confreq, _ := env.pop()
@@ -646,7 +818,23 @@ func op_update_sector(env *scriptEnv, args []byte) (err error) {
 	}
 	su.Event.Deadline = siaencoding.DecUint32(deadline)
 
-	err = env.engine.UpdateSector(env.wallet, su)
+	err = env.engine.UpdateSector(env.overlay, env.wallet, su)
+	return
+}
+
+func op_update_address(env *scriptEnv, args []byte) (err error) {
+	encUpdate, err := env.pop()
+	if err != nil {
+		return
+	}
+
+	var sau state.SignedAddressUpdate
+	err = siaencoding.Unmarshal(encUpdate, &sau)
+	if err != nil {
+		return
+	}
+
+	err = env.engine.UpdateAddress(env.overlay, env.wallet, sau)
 	return
 }
 
@@ -654,6 +842,92 @@ func op_deadline(env *scriptEnv, args []byte) (err error) {
 	return env.push(siaencoding.EncUint32(env.deadline))
 }
 
+func op_fee(env *scriptEnv, args []byte) (err error) {
+	return env.push(env.fee[:])
+}
+
+// introspection opcodes
+//
+// These opcodes read the quorum as the script sees it, which includes the
+// changes that the script has made so far.
+
+func op_height(env *scriptEnv, args []byte) (err error) {
+	return env.push(siaencoding.EncUint32(env.overlay.Metadata.Height))
+}
+
+func op_balance(env *scriptEnv, args []byte) (err error) {
+	return env.push(env.wallet.Balance[:])
+}
+
+func op_sector_atoms(env *scriptEnv, args []byte) (err error) {
+	return env.push(siaencoding.EncUint16(env.wallet.Sector.Atoms))
+}
+
+func op_sector_hashset(env *scriptEnv, args []byte) (err error) {
+	hashset := make([]byte, 0, int(state.QuorumSize)*siacrypto.HashSize)
+	for _, h := range env.wallet.Sector.HashSet {
+		hashset = append(hashset, h[:]...)
+	}
+	return env.push(hashset)
+}
+
+func op_storage_price(env *scriptEnv, args []byte) (err error) {
+	return env.push(env.overlay.Metadata.StoragePrice[:])
+}
+
+func op_script_price(env *scriptEnv, args []byte) (err error) {
+	return env.push(env.overlay.Metadata.ScriptPrice[:])
+}
+
+func op_sibling_status(env *scriptEnv, args []byte) (err error) {
+	index, err := env.pop()
+	if err != nil {
+		return
+	}
+	if len(index) != 1 || index[0] >= state.QuorumSize {
+		err = errors.New("invalid parameter")
+		return
+	}
+
+	return env.push([]byte{env.overlay.Metadata.Siblings[index[0]].Status})
+}
+
+// op_less_balance and op_greater_balance compare two Balances, which can't be
+// compared with less_int and greater_int once they don't fit in 63 bits.
+func op_less_balance(env *scriptEnv, args []byte) (err error) {
+	a, _ := env.pop()
+	b, err := env.pop()
+	if err != nil {
+		return
+	}
+	x, err := v2bal(a)
+	if err != nil {
+		return
+	}
+	y, err := v2bal(b)
+	if err != nil {
+		return
+	}
+	return env.push(b2v(x.Compare(y) < 0))
+}
+
+func op_greater_balance(env *scriptEnv, args []byte) (err error) {
+	a, _ := env.pop()
+	b, err := env.pop()
+	if err != nil {
+		return
+	}
+	x, err := v2bal(a)
+	if err != nil {
+		return
+	}
+	y, err := v2bal(b)
+	if err != nil {
+		return
+	}
+	return env.push(b2v(x.Compare(y) > 0))
+}
+
 // convenience opcodes
 
 func op_switch(env *scriptEnv, args []byte) (err error) {
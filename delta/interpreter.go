@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/NebulousLabs/Sia/siaencoding"
 	"github.com/NebulousLabs/Sia/state"
 )
 
 const (
 	maxInstructions = 10000
+	maxCost         = 10000
+	maxCallDepth    = 8
 	maxMemory       = 1 << 14 // 16 KB
 	maxStackLen     = 1 << 16
 	debug           = false
@@ -62,16 +65,21 @@ func (s *stackElem) print() string {
 		if p == nil {
 			break
 		}
-		if len(p.val) > 5 {
-			str += fmt.Sprint(p.val[:5]) + "... "
-		} else {
-			str += fmt.Sprint(p.val) + " "
-		}
+		str += printValue(p.val) + " "
 	}
 	str += "}"
 	return str
 }
 
+// printValue prints a value in the stack or a register, cutting it short if
+// it is long.
+func printValue(v []byte) string {
+	if len(v) > 5 {
+		return fmt.Sprint(v[:5]) + "..."
+	}
+	return fmt.Sprint(v)
+}
+
 // environment variables necessary for script execution
 type scriptEnv struct {
 	script     []byte
@@ -80,8 +88,17 @@ type scriptEnv struct {
 	stack      *stackElem
 	stackLen   int
 	wallet     *state.Wallet
+	overlay    *state.Overlay
 	engine     *Engine
 	deadline   uint32
+	fee        state.Balance
+	// the encoded ID of the calling wallet, nil unless the script was
+	// started by op_call or scheduled, and the number of calls leading to
+	// the script
+	caller []byte
+	depth  int
+	// called after every instruction if set, see DryRun
+	trace func(TraceStep)
 	// resource pools
 	instBalance int
 	costBalance int
@@ -116,39 +133,239 @@ func (env *scriptEnv) deductResources(op instruction) error {
 	}
 }
 
+// deductCost deducts the extra cost of an opcode whose cost depends on its
+// arguments, and returns an error if the cost pool is exhausted.
+func (env *scriptEnv) deductCost(cost int) error {
+	env.costBalance -= cost
+	if env.costBalance < 0 {
+		return errors.New("balance exhausted")
+	}
+	return nil
+}
+
 // Execute loads the requested script, appends the script input data, sets up
 // an execution environment, and interprets bytecodes until a termination
 // condition is reached.
+//
+// The wallet pays ScriptPrice for every unit of cost that the script uses.
+// Before the script runs, the price of its whole cost budget is set aside
+// from the wallet balance so that the script can't spend it, and once the
+// script terminates the wallet is charged for the cost that was actually
+// used. A script that rejects its input pays nothing.
+//
+// The fee of the input is set aside along with the cost budget, but it is only
+// paid into the script fees of the quorum if the script exits successfully.
+// The fee is only checked by the script that runs it, so anyone can send an
+// input with a fee that the owner never agreed to; such an input is rejected
+// or fails, and the fee goes back to the wallet. An input whose wallet can't
+// pay its fee is not run, and nothing is charged.
+//
+// Scripts are atomic. The script API makes its changes to an overlay of the
+// state, which is only committed if the script exits or reaches the end of
+// its input. If the script fails or rejects its input, the overlay is thrown
+// away along with every change the script made to the quorum, though a
+// failed script still pays for the cost it used.
 func (e *Engine) Execute(si state.ScriptInput) (err error) {
+	return e.execute(si, nil)
+}
+
+// ExecuteScheduled runs a scheduled input, see state.ScheduledInput. The
+// script is run like any other input, except that it sees its own wallet as
+// the caller, which lets it tell the inputs that it scheduled itself apart
+// from inputs submitted by anyone else.
+func (e *Engine) ExecuteScheduled(si state.ScriptInput) (err error) {
+	return e.execute(si, si.WalletID.Bytes())
+}
+
+// execute runs a script input on behalf of 'caller', see Execute.
+func (e *Engine) execute(si state.ScriptInput, caller []byte) (err error) {
 	// load wallet
 	w, err := e.state.LoadWallet(si.WalletID)
 	if err != nil {
 		return
 	}
 
-	// initialize execution environment
-	env := scriptEnv{
+	// set aside the fee and the price of the cost budget
+	price := e.state.Metadata.ScriptPrice
+	if w.Balance.Compare(si.Fee) < 0 {
+		err = errInsufficientFee
+		return
+	}
+	w.Balance.Subtract(si.Fee)
+	costLimit := scriptCostLimit(price, w.Balance, si.CostLimit)
+	reserved := scriptCharge(price, costLimit)
+	w.Balance.Subtract(reserved)
+
+	// run script
+	env := e.newEnv(e.state.NewOverlay(), &w, si, caller, costLimit)
+	e.log.Debug("executing script:", env.script)
+	err = env.run()
+	used := costLimit - env.costBalance
+	if used > costLimit {
+		used = costLimit
+	}
+	charge := scriptCharge(price, used)
+	if err != nil {
+		if err != errRejected {
+			e.chargeFailedScript(si.WalletID, charge)
+		}
+		err = fmt.Errorf("wallet %x script execution failed: %v\n\tstack: %s",
+			si.WalletID, err, env.stack.print())
+		e.log.Info(err)
+		return
+	}
+
+	// return the unused part of the reserve, collect the fee, and commit
+	// the changes made by the script
+	w.Balance.Add(reserved)
+	w.Balance.Subtract(charge)
+	env.overlay.Metadata.ScriptFees.Add(si.Fee)
+	err = env.overlay.SaveWallet(w)
+	if err == nil {
+		err = env.overlay.Commit()
+	}
+	if err != nil {
+		e.log.Error("failed to commit script changes:", err)
+	}
+	return
+}
+
+// newEnv returns an environment that runs the script of 'w' on the input
+// 'si', making its changes to 'o'. The fee and the price of 'costLimit' should
+// already be set aside from the wallet.
+func (e *Engine) newEnv(o *state.Overlay, w *state.Wallet, si state.ScriptInput, caller []byte, costLimit int) scriptEnv {
+	return scriptEnv{
 		script:   append(w.Script, si.Input...),
 		dptr:     len(w.Script),
-		wallet:   &w,
+		wallet:   w,
+		overlay:  o,
 		engine:   e,
 		deadline: si.Deadline,
+		fee:      si.Fee,
+		caller:   caller,
 		// these values will likely be stored as part of the wallet
 		instBalance: maxInstructions,
-		costBalance: 10000,
+		costBalance: costLimit,
 		memUsage:    0,
 	}
+}
 
-	// run script
-	e.log.Debug("executing script:", env.script)
-	if err = env.run(); err != nil {
-		err = fmt.Errorf("wallet %x script execution failed: %v\n\tstack: %s",
-			si.WalletID, err, env.stack.print())
-		e.log.Info(err)
+// scriptCostLimit returns the cost budget of a script run: the limit
+// requested by the input, capped at maxCost and at the cost that 'balance'
+// can pay for at 'price'.
+func scriptCostLimit(price state.Balance, balance state.Balance, requested uint32) int {
+	limit := cappedCost(requested)
+	if price == (state.Balance{}) {
+		return limit
+	}
+	affordable := balance
+	affordable.Divide(price)
+	if affordable.Compare(state.NewBalance(uint64(limit))) < 0 {
+		limit = int(siaencoding.DecUint64(affordable[:8]))
+	}
+	return limit
+}
+
+// cappedCost returns the cost limit requested by an input, capped at maxCost.
+// A request of 0 gets maxCost.
+func cappedCost(requested uint32) int {
+	if requested != 0 && requested < maxCost {
+		return int(requested)
+	}
+	return maxCost
+}
+
+// scriptCharge returns the price of 'cost' units of script cost at 'price'.
+func scriptCharge(price state.Balance, cost int) (charge state.Balance) {
+	charge = price
+	charge.Multiply(state.NewBalance(uint64(cost)))
+	return
+}
+
+// chargeFailedScript charges a wallet for the cost used by a script that
+// failed. The charge is made to the wallet as it was before the script ran.
+func (e *Engine) chargeFailedScript(id state.WalletID, charge state.Balance) {
+	w, err := e.state.LoadWallet(id)
+	if err != nil {
+		e.log.Error("failed to load wallet to charge a failed script:", err)
 		return
 	}
+	w.Balance.Subtract(charge)
+	err = e.state.SaveWallet(w)
+	if err != nil {
+		e.log.Error("failed to save wallet to charge a failed script:", err)
+	}
+}
+
+// call runs the script of wallet 'id' on 'input', as a call made by the
+// running script. The callee shares the deadline of the caller, and its
+// resources are drawn from the caller's pools, at most 'costLimit' of cost. A
+// costLimit of 0 lets the callee use all of the caller's remaining cost.
+//
+// The callee works on an overlay of the caller's overlay, so that its changes
+// are only kept if it succeeds. The callee failing or rejecting its input is
+// not an error for the caller; 'success' is false and execution continues.
+func (env *scriptEnv) call(id state.WalletID, input []byte, costLimit int) (success bool, err error) {
+	if env.depth >= maxCallDepth {
+		err = errors.New("call depth limit reached")
+		return
+	}
+	if costLimit <= 0 || costLimit > env.costBalance {
+		costLimit = env.costBalance
+	}
 
-	e.state.SaveWallet(w)
+	// The callee sees the caller's wallet as it is now.
+	err = env.overlay.SaveWallet(*env.wallet)
+	if err != nil {
+		return
+	}
+	overlay := env.overlay.NewOverlay()
+	w, err := overlay.LoadWallet(id)
+	if err != nil {
+		return
+	}
+	callee := scriptEnv{
+		script:      append(w.Script, input...),
+		dptr:        len(w.Script),
+		wallet:      &w,
+		overlay:     overlay,
+		engine:      env.engine,
+		deadline:    env.deadline,
+		caller:      env.wallet.ID.Bytes(),
+		depth:       env.depth + 1,
+		trace:       env.trace,
+		instBalance: env.instBalance,
+		costBalance: costLimit,
+	}
+	runErr := callee.run()
+
+	// draw the resources used by the callee from the caller's pools
+	used := costLimit - callee.costBalance
+	if used > costLimit {
+		used = costLimit
+	}
+	env.costBalance -= used
+	env.instBalance = callee.instBalance
+	if runErr != nil {
+		env.engine.log.Debug("wallet", id, "call failed:", runErr)
+		return
+	}
+
+	// keep the changes of the callee, which may include changes to the
+	// caller's wallet
+	err = overlay.SaveWallet(w)
+	if err != nil {
+		return
+	}
+	err = overlay.Commit()
+	if err != nil {
+		return
+	}
+	*env.wallet, err = env.overlay.LoadWallet(env.wallet.ID)
+	if err != nil {
+		return
+	}
+	success = true
 	return
 }
 
@@ -175,12 +392,17 @@ func (env *scriptEnv) run() error {
 		}
 
 		// read bytes into argument array and advance env.iptr
+		start, opcode := env.iptr, env.script[env.iptr]
 		fnArgs := make([]byte, op.argBytes)
 		env.iptr++
 		env.iptr += copy(fnArgs, env.script[env.iptr:])
 
 		// call associated opcode function and check for error
-		switch err := op.fn(env, fnArgs); err {
+		err := op.fn(env, fnArgs)
+		if env.trace != nil {
+			env.trace(env.traceStep(start, opcode, fnArgs, err))
+		}
+		switch err {
 		case nil:
 			continue
 		case errExit:
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
 	"github.com/NebulousLabs/Sia/siafiles"
 	"github.com/NebulousLabs/Sia/sialog"
 	"github.com/NebulousLabs/Sia/state"
@@ -164,3 +165,519 @@ func TestExhaustion(t *testing.T) {
 		t.Error("expected resource exhaustion error")
 	}
 }
+
+// TestScriptCharges checks that a wallet pays for the cost used by its script,
+// that a rejected input costs nothing, and that the cost budget is capped by
+// the input's CostLimit and by the wallet's balance.
+func TestScriptCharges(t *testing.T) {
+	e, si := initEnv()
+	e.state.Metadata.ScriptPrice = state.NewBalance(1)
+	checkBalance := func(expected uint64) {
+		w, err := e.state.LoadWallet(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(expected) {
+			t.Fatal("expecting a balance of", expected, "got", w.Balance)
+		}
+	}
+
+	// transfer, push_byte and exit cost 3 in total
+	si.Input = []byte{
+		0x01, 0x02, // push 2
+		0xFF, //       exit
+	}
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(14997)
+
+	// rejecting the input costs nothing
+	si.Input = []byte{
+		0x01, 0x02, // push 2
+		0xFE, //       reject
+	}
+	if e.Execute(si) == nil {
+		t.Fatal("expected rejection")
+	}
+	checkBalance(14997)
+
+	// an exhausted script pays for the whole of its cost limit
+	si.Input = []byte{
+		0x21, 0x01, 0x00, // goto 01 (infinite loop)
+	}
+	si.CostLimit = 100
+	if e.Execute(si) == nil {
+		t.Fatal("expected resource exhaustion error")
+	}
+	checkBalance(14897)
+
+	// the budget of a wallet that can't pay for the limit is what it can
+	// afford
+	e.state.Metadata.ScriptPrice = state.NewBalance(1000)
+	si.CostLimit = 0
+	if e.Execute(si) == nil {
+		t.Fatal("expected resource exhaustion error")
+	}
+	checkBalance(897)
+}
+
+// TestInputFees checks that the fee of an input is only collected if the
+// script exits successfully, and that the default script rejects inputs whose
+// fee has been changed since they were signed, or that were never signed,
+// without charging the wallet anything.
+func TestInputFees(t *testing.T) {
+	e, si := initEnv()
+	e.state.Metadata.ScriptPrice = state.NewBalance(1)
+	checkFees := func(id state.WalletID, balance, fees uint64) {
+		w, err := e.state.LoadWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(balance) {
+			t.Fatal("expecting wallet", id, "to have a balance of", balance, "got", w.Balance)
+		}
+		if e.state.Metadata.ScriptFees != state.NewBalance(fees) {
+			t.Fatal("expecting", fees, "in script fees, got", e.state.Metadata.ScriptFees)
+		}
+	}
+
+	// a successful script pays its fee on top of its cost
+	si.Fee = state.NewBalance(100)
+	si.Input = []byte{
+		0x01, 0x02, // push 2
+		0xFF, //       exit
+	}
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkFees(1, 14897, 100)
+
+	// a rejected input pays no fee
+	si.Input = []byte{0xFE}
+	if e.Execute(si) == nil {
+		t.Fatal("expected rejection")
+	}
+	checkFees(1, 14897, 100)
+
+	// a failed script pays for its cost, but not its fee
+	si.Input = []byte{
+		0x21, 0x01, 0x00, // goto 01 (infinite loop)
+	}
+	si.CostLimit = 100
+	if e.Execute(si) == nil {
+		t.Fatal("expected resource exhaustion error")
+	}
+	checkFees(1, 14797, 100)
+
+	// an input whose fee can't be paid is not run
+	si.Fee = state.NewBalance(20000)
+	si.Input = []byte{0xFF}
+	if e.Execute(si) != errInsufficientFee {
+		t.Fatal("expected errInsufficientFee")
+	}
+	checkFees(1, 14797, 100)
+
+	// the fee is part of the signed message
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.state.InsertWallet(state.Wallet{ID: 2, Balance: state.NewBalance(1000), Script: DefaultScript(pk)}, true)
+	signed := state.ScriptInput{
+		WalletID:  2,
+		Input:     []byte{0xFF},
+		CostLimit: 100,
+		Fee:       state.NewBalance(10),
+	}
+	if err := SignScriptInput(&signed, sk); err != nil {
+		t.Fatal(err)
+	}
+	raised := signed
+	raised.Fee = state.NewBalance(500)
+	if e.Execute(raised) == nil {
+		t.Error("input with a raised fee was accepted")
+	}
+	checkFees(2, 1000, 100)
+
+	// an input that was never signed costs the wallet nothing, whatever fee
+	// it carries
+	unsigned := state.ScriptInput{
+		WalletID: 2,
+		Input:    make([]byte, siacrypto.SignatureSize+1),
+		Fee:      state.NewBalance(900),
+	}
+	if e.Execute(unsigned) == nil {
+		t.Error("unsigned input was accepted")
+	}
+	checkFees(2, 1000, 100)
+	unsigned.Fee = state.NewBalance(5000)
+	if e.Execute(unsigned) != errInsufficientFee {
+		t.Error("expected errInsufficientFee")
+	}
+	checkFees(2, 1000, 100)
+
+	// an unsigned input that runs out of cost pays for the cost that it used,
+	// and not its fee
+	unsigned.Fee = state.NewBalance(900)
+	unsigned.CostLimit = 1
+	if e.Execute(unsigned) == nil {
+		t.Error("unsigned input was accepted")
+	}
+	checkFees(2, 999, 100)
+
+	// the signed input pays its fee, and 26 units of cost for checking the
+	// signature
+	if err := e.Execute(signed); err != nil {
+		t.Fatal(err)
+	}
+	checkFees(2, 963, 110)
+}
+
+// TestAtomicScripts checks that the changes a script makes to other wallets
+// are only kept if the script succeeds.
+func TestAtomicScripts(t *testing.T) {
+	e, si := initEnv()
+	e.state.InsertWallet(state.Wallet{ID: 2}, true)
+	checkBalance := func(id state.WalletID, expected uint64) {
+		w, err := e.state.LoadWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(expected) {
+			t.Fatal("expecting wallet", id, "to have a balance of", expected, "got", w.Balance)
+		}
+	}
+
+	// send coins and then fail
+	amount := state.NewBalance(100)
+	si.Input = appendAll(
+		[]byte{
+			0xE6, 0xFF, // move data pointer to dest
+			0x34, 0x08, // push dest
+			0x34, 0x10, // push balance
+			0x43, //       call Send
+			0x03, //       pop from an empty stack (error)
+			0xFF, //       exit
+		},
+		siaencoding.EncUint64(2),
+		amount[:],
+	)
+	if e.Execute(si) == nil {
+		t.Fatal("expected stack empty error")
+	}
+	checkBalance(1, 15000)
+	checkBalance(2, 0)
+
+	// send coins and succeed
+	si.Input = SendCoinInput(2, amount)
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(1, 14900)
+	checkBalance(2, 100)
+
+	// create a wallet and then reject
+	si.Input = appendAll(
+		[]byte{
+			0xE6, 0xFF, // move data pointer to id
+			0x34, 0x08, // push id
+			0x01, 0x05, // push balance
+			0x01, 0xFF, // push script
+			0x42, //       call CreateWallet
+			0xFE, //       reject
+			0xFF, //       exit
+		},
+		siaencoding.EncUint64(3),
+	)
+	if e.Execute(si) == nil {
+		t.Fatal("expected rejection")
+	}
+	if _, err := e.state.LoadWallet(3); err == nil {
+		t.Error("wallet created by a rejected script exists")
+	}
+	checkBalance(1, 14900)
+}
+
+// TestIntrospection checks the values pushed by the introspection opcodes.
+func TestIntrospection(t *testing.T) {
+	e, si := initEnv()
+	e.state.Metadata.Height = 12
+	e.state.Metadata.StoragePrice = state.NewBalance(45)
+	e.state.Metadata.ScriptPrice = state.NewBalance(2)
+	e.state.Metadata.Siblings[2].Status = state.SiblingPassiveWindow
+	w, err := e.state.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Balance = state.NewBalance(100000)
+	w.Sector.Atoms = 5
+	w.Sector.HashSet[1][0] = 7
+	err = e.state.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check runs 'code', and compares the value it pushes with 'expected'
+	check := func(name string, code []byte, expected []byte) {
+		offl, offh := short(len(code) + 8)
+		si.Input = appendAll(
+			code,
+			[]byte{
+				0x33, offl, offh, //        move data pointer to expected value
+				0x34, byte(len(expected)), // push expected value
+				0x16, //                    equal
+				0xE5, //                    if not equal, reject
+				0xFF, //                    exit
+			},
+			expected,
+		)
+		if err := e.Execute(si); err != nil {
+			t.Error(name, "pushed the wrong value:", err)
+		}
+	}
+
+	// The cost budget of the run is set aside before the balance is read.
+	// Every run is charged, so the balance is checked first.
+	budget := state.NewBalance(2 * maxCost)
+	balance := state.NewBalance(100000)
+	balance.Subtract(budget)
+	var hashset []byte
+	for _, h := range w.Sector.HashSet {
+		hashset = append(hashset, h[:]...)
+	}
+	scriptPrice := state.NewBalance(2)
+	storagePrice := state.NewBalance(45)
+
+	check("balance", []byte{0x51}, balance[:])
+	check("height", []byte{0x50}, siaencoding.EncUint32(12))
+	check("sector_atoms", []byte{0x52}, siaencoding.EncUint16(5))
+	check("sector_hashset", []byte{0x53}, hashset)
+	check("storage_price", []byte{0x54}, storagePrice[:])
+	check("script_price", []byte{0x55}, scriptPrice[:])
+	check("sibling_status", []byte{0x01, 0x02, 0x56}, []byte{state.SiblingPassiveWindow})
+
+	// A sibling index out of range is an error.
+	si.Input = []byte{
+		0x01, 0x04, // push 4
+		0x56, //       sibling status
+		0xFF, //       exit
+	}
+	if e.Execute(si) == nil {
+		t.Error("expected an error for a sibling index out of range")
+	}
+
+	// Balances that don't fit in 64 bits are compared in full.
+	large := state.NewStringBalance("18446744073709551616") // 2^64
+	si.Input = append([]byte{
+		0x33, 0x09, 0x00, // move data pointer to the large balance
+		0x34, 0x10, //       push the large balance
+		0x51, //             balance
+		0x57, //             less balance
+		0xE5, //             if not less, reject
+		0xFF, //             exit
+	}, large[:]...)
+	if err := e.Execute(si); err != nil {
+		t.Error("less_balance did not compare the whole balance:", err)
+	}
+	si.Input = append([]byte{
+		0x33, 0x0A, 0x00, // move data pointer to the large balance
+		0x34, 0x10, //       push the large balance
+		0x51, //             balance
+		0x58, //             greater balance
+		0x1C, //             not
+		0xE5, //             if greater, reject
+		0xFF, //             exit
+	}, large[:]...)
+	if err := e.Execute(si); err != nil {
+		t.Error("greater_balance did not compare the whole balance:", err)
+	}
+
+	// A value longer than a Balance is an error.
+	si.Input = []byte{
+		0x01, 0x00, // push 0
+		0x51, //       balance
+		0x23, //       concat
+		0x51, //       balance
+		0x57, //       less balance
+		0xFF, //       exit
+	}
+	if e.Execute(si) == nil {
+		t.Error("expected an error for a value longer than a Balance")
+	}
+}
+
+// TestHash checks that op_hash pushes the hash of the popped value.
+func TestHash(t *testing.T) {
+	e, si := initEnv()
+	h := siacrypto.HashBytes([]byte{0x07})
+	si.Input = appendAll(
+		[]byte{
+			0x33, 0x0B, 0x00, // move data pointer to expected hash
+			0x34, 0x20, //       push expected hash
+			0x01, 0x07, //       push 7
+			0x48, //             hash
+			0x16, //             equal
+			0xE5, //             if not equal, reject
+			0xFF, //             exit
+		},
+		h[:],
+	)
+	if err := e.Execute(si); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMultisig runs a 2-of-3 multisig wallet with different sets of
+// signatures.
+func TestMultisig(t *testing.T) {
+	e, _ := initEnv()
+	pks := make([]siacrypto.PublicKey, 3)
+	sks := make([]siacrypto.SecretKey, 3)
+	for i := range pks {
+		var err error
+		pks[i], sks[i], err = siacrypto.CreateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.state.InsertWallet(state.Wallet{
+		ID:      2,
+		Balance: state.NewBalance(15000),
+		Script:  MultisigScript(2, pks),
+	}, true)
+	e.state.InsertWallet(state.Wallet{ID: 3}, true)
+
+	tests := []struct {
+		name    string
+		signers []siacrypto.SecretKey
+		success bool
+	}{
+		{"two keys", []siacrypto.SecretKey{sks[0], sks[2]}, true},
+		{"all keys", sks, true},
+		{"one key", []siacrypto.SecretKey{sks[1]}, false},
+		{"keys out of order", []siacrypto.SecretKey{sks[2], sks[0]}, false},
+		{"one key twice", []siacrypto.SecretKey{sks[1], sks[1]}, false},
+	}
+	var sent uint64
+	for _, test := range tests {
+		si := state.ScriptInput{
+			WalletID: 2,
+			Deadline: 5,
+			Input:    SendCoinInput(3, state.NewBalance(10)),
+		}
+		err := SignMultisigInput(&si, test.signers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = e.Execute(si)
+		if test.success && err != nil {
+			t.Error(test.name, "was not accepted:", err)
+		} else if !test.success && err == nil {
+			t.Error(test.name, "was accepted")
+		}
+		if test.success {
+			sent += 10
+		}
+	}
+
+	w, err := e.state.LoadWallet(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Balance != state.NewBalance(sent) {
+		t.Error("expecting", sent, "coins to be sent, got", w.Balance)
+	}
+}
+
+// TestCall has wallets call an escrow wallet that only pays out when called
+// by wallet 1, and has a wallet call itself until it reaches the depth limit.
+func TestCall(t *testing.T) {
+	e, si := initEnv()
+	escrowScript := appendAll(
+		[]byte{
+			0x4B,             // push caller
+			0x33, 0xF8, 0xFF, // move data pointer to authorized caller
+			0x34, 0x08, //       push authorized caller
+			0x16, //             equal
+			0xE5, //             if not equal, reject
+			0x38, //             execute input
+		},
+		siaencoding.EncUint64(1),
+	)
+	e.state.InsertWallet(state.Wallet{ID: 2, Balance: state.NewBalance(100), Script: escrowScript}, true)
+	e.state.InsertWallet(state.Wallet{ID: 3}, true)
+	e.state.InsertWallet(state.Wallet{ID: 4, Script: []byte{0x38}}, true)
+	checkBalance := func(id state.WalletID, expected uint64) {
+		w, err := e.state.LoadWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(expected) {
+			t.Fatal("expecting wallet", id, "to have a balance of", expected, "got", w.Balance)
+		}
+	}
+
+	// callInput calls wallet 2 with 'input', and pushes the success value
+	// of the call, which 'check' should reject or accept.
+	callInput := func(input []byte, check ...byte) []byte {
+		code := appendAll(
+			[]byte{
+				0x01, 0x00, //       push cost limit
+				0x33, 0x00, 0x00, // move data pointer to callee id
+				0x34, 0x08, //       push callee id
+				0xE3, //             push callee input
+				0x4A, //             call
+			},
+			check,
+			[]byte{0xFF},
+		)
+		code[3], code[4] = short(len(code))
+		inl, inh := short(len(input))
+		return appendAll(code, siaencoding.EncUint64(2), []byte{inl, inh}, input)
+	}
+
+	// wallet 1 is allowed to make the escrow pay wallet 3
+	si.Input = callInput(SendCoinInput(3, state.NewBalance(10)), 0xE5)
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(2, 90)
+	checkBalance(3, 10)
+
+	// wallet 4 isn't, but the failed call doesn't stop it
+	si.WalletID = 4
+	si.Input = callInput(SendCoinInput(3, state.NewBalance(10)), 0x1C, 0xE5)
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(2, 90)
+	checkBalance(3, 10)
+
+	// the escrow can pay its caller
+	si.WalletID = 1
+	si.Input = callInput(SendCoinInput(1, state.NewBalance(10)), 0xE5)
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(1, 15010)
+	checkBalance(2, 80)
+
+	// a wallet that calls itself stops at the depth limit
+	e.state.InsertWallet(state.Wallet{ID: 5, Script: appendAll(
+		[]byte{
+			0x01, 0x00, //       push cost limit
+			0x33, 0xF8, 0xFF, // move data pointer to own id
+			0x34, 0x08, //       push own id
+			0x01, 0x00, //       push input
+			0x4A, //             call
+			0xFF, //             exit
+		},
+		siaencoding.EncUint64(5),
+	)}, true)
+	si.WalletID = 5
+	si.Input = nil
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+}
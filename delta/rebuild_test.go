@@ -0,0 +1,188 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestRebuild places a sibling into an empty slot and checks that the slot is
+// rebuilt from the progress that the sibling reports in its heartbeats, that
+// the rebuild only ends with a valid storage proof, that tossing the sibling
+// marks the slot for a rebuild again, and that a sibling that doesn't finish
+// rebuilding in time is tossed, whether it reports its progress or not.
+func TestRebuild(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinPK, joinSK, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestRebuild"))
+	err = e.Bootstrap(state.Sibling{
+		WalletID:  1,
+		PublicKey: pk,
+	}, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := e.Metadata()
+	if md.Rebuilds[0].Pending || !md.Rebuilds[1].Pending {
+		t.Fatal("expecting only the empty slots to need a rebuild")
+	}
+
+	// Place a new sibling, which should be scheduled to rebuild its slot.
+	w, err := e.Wallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := e.state.NewOverlay()
+	err = e.AddSibling(o, &w, state.Sibling{PublicKey: joinPK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Compile(bootstrapBlock(t, &e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slot byte
+	for i, sib := range e.Metadata().Siblings {
+		if sib.PublicKey == joinPK {
+			slot = byte(i)
+		}
+	}
+	if slot == 0 {
+		t.Fatal("hopeful was not placed")
+	}
+	if !e.Metadata().Rebuilding(slot) {
+		t.Fatal("new sibling was not scheduled to rebuild its slot")
+	}
+	for i := 0; i < state.SiblingPassiveWindow; i++ {
+		err = e.Compile(bootstrapBlock(t, &e, sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rebuildBlock creates a block where the new sibling reports 'progress'
+	// along with 'proof'.
+	rebuildBlock := func(progress state.RebuildProgress, proof state.StorageProof) (b Block) {
+		b = bootstrapBlock(t, &e, sk)
+		b.Heartbeats[slot] = Heartbeat{
+			ParentBlock:  b.ParentBlock,
+			StorageProof: proof,
+			Rebuild:      progress,
+		}
+		signature, err := joinSK.SignObject(b.Heartbeats[slot])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.HeartbeatSignatures[slot] = signature
+		return
+	}
+
+	// Give the quorum a single atom to store, so that the slot has a segment
+	// to prove.
+	var segment [state.AtomSize]byte
+	segment[0] = 1
+	w, err = e.Wallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Sector.Atoms = 1
+	w.Sector.HashSet[slot] = siacrypto.HashBytes(segment[:])
+	err = e.state.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Report partial progress, then completion without a valid proof, and
+	// then completion with one.
+	partial := state.RebuildProgress{Rebuilt: 1, Total: 3}
+	err = e.Compile(rebuildBlock(partial, state.StorageProof{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md = e.Metadata()
+	if !md.Siblings[slot].Active() {
+		t.Fatal("rebuilding sibling was tossed")
+	}
+	if !md.Rebuilding(slot) || md.Rebuilds[slot].Progress != partial {
+		t.Error("partial progress was not recorded:", md.Rebuilds[slot])
+	}
+	complete := state.RebuildProgress{Rebuilt: 3, Total: 3, Complete: true}
+	err = e.Compile(rebuildBlock(complete, state.StorageProof{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Rebuilding(slot) {
+		t.Fatal("rebuild ended without a storage proof")
+	}
+	err = e.Compile(rebuildBlock(complete, state.StorageProof{AtomBase: segment}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Metadata().Rebuilds[slot].Pending {
+		t.Error("rebuild did not end after the sibling reported completion")
+	}
+	joined := e.Metadata().Siblings[slot]
+
+	// Tossing the sibling loses its segments again.
+	height := e.Metadata().Height
+	e.state.TossSibling(slot)
+	md = e.Metadata()
+	if !md.Rebuilds[slot].Pending || md.Rebuilds[slot].Vacated != height {
+		t.Error("tossed slot was not marked for a rebuild:", md.Rebuilds[slot])
+	}
+	if md.Rebuilding(slot) {
+		t.Error("empty slot is reported as rebuilding")
+	}
+	// Put the sibling back into the slot, and have it report partial
+	// progress until it runs out of time.
+	e.state.Metadata.Siblings[slot] = joined
+	e.state.ScheduleRebuild(slot)
+	for i := 0; i <= state.RebuildWindow; i++ {
+		err = e.Compile(rebuildBlock(partial, state.StorageProof{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !e.Metadata().Siblings[slot].Active() {
+			t.Fatal("sibling was tossed after", i, "blocks of rebuilding")
+		}
+	}
+	err = e.Compile(rebuildBlock(partial, state.StorageProof{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Siblings[slot].Inactive() {
+		t.Error("sibling was not tossed after running out of time to rebuild")
+	}
+
+	// A passive sibling sends no heartbeats, and is tossed all the same once
+	// it runs out of time.
+	e.state.Metadata.Siblings[slot] = joined
+	e.state.ScheduleRebuild(slot)
+	e.state.Metadata.Rebuilds[slot].Started -= state.RebuildWindow + 1
+	e.state.Metadata.Siblings[slot].Status = state.SiblingPassiveWindow
+	err = e.Compile(bootstrapBlock(t, &e, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Metadata().Siblings[slot].Inactive() {
+		t.Error("passive sibling was not tossed after running out of time to rebuild")
+	}
+}
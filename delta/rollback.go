@@ -0,0 +1,78 @@
+package delta
+
+import (
+	"fmt"
+
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// SnapshotHeads returns the heads of the snapshots that are currently on disk,
+// most recent first. When recovering from a fork, these are the points that
+// the engine can roll back to without downloading anything.
+func (e *Engine) SnapshotHeads() (heads []uint32) {
+	heads = append(heads, e.state.Metadata.RecentSnapshot)
+	if e.recentHistoryHead != ^uint32(0) && e.recentHistoryHead != e.state.Metadata.RecentSnapshot {
+		heads = append(heads, e.recentHistoryHead)
+	}
+	return
+}
+
+// RollbackToSnapshot discards the current state and replaces it with the
+// state stored in the snapshot at 'snapshotHead'. Only the two snapshots
+// listed by SnapshotHeads can be rolled back to. After a rollback, the engine
+// is at the height of the snapshot and the blocks following the snapshot need
+// to be compiled again.
+//
+// The snapshot is loaded into memory before the state is cleared, because
+// openSnapshot relies on the current metadata to find the snapshot file.
+func (e *Engine) RollbackToSnapshot(snapshotHead uint32) (err error) {
+	if snapshotHead != e.state.Metadata.RecentSnapshot && (snapshotHead != e.recentHistoryHead || snapshotHead == ^uint32(0)) {
+		err = fmt.Errorf("cannot roll back to snapshot %v, it is not on disk", snapshotHead)
+		return
+	}
+
+	// Load everything from the snapshot.
+	metadata, err := e.LoadSnapshotMetadata(snapshotHead)
+	if err != nil {
+		return
+	}
+	walletList, err := e.LoadSnapshotWalletList(snapshotHead)
+	if err != nil {
+		return
+	}
+	wallets := make([]state.Wallet, len(walletList))
+	for i, id := range walletList {
+		wallets[i], err = e.LoadSnapshotWallet(snapshotHead, id)
+		if err != nil {
+			return
+		}
+	}
+
+	// Replace the state with the contents of the snapshot.
+	e.state.ClearWallets()
+	e.state.Metadata = metadata
+	for _, w := range wallets {
+		err = e.state.InsertWallet(w, false)
+		if err != nil {
+			return
+		}
+	}
+
+	// Reset the block history so that the next compiled block is written
+	// to the start of the history file belonging to the snapshot. If the
+	// older snapshot was used, the history preceding it has already been
+	// deleted, so there is no recent history anymore.
+	if snapshotHead == e.recentHistoryHead {
+		e.recentHistoryHead = ^uint32(0)
+	}
+	e.activeHistoryLength = 0
+	return
+}
+
+// ClearState removes every wallet and event from the state, in preparation for
+// a snapshot being downloaded from another sibling. It should be followed by
+// calls to BootstrapSetMetadata, BootstrapInsertWallet, and
+// BootstrapJoinSetup.
+func (e *Engine) ClearState() {
+	e.state.ClearWallets()
+}
@@ -0,0 +1,87 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestRollbackToSnapshot compiles a few snapshots worth of blocks, then rolls
+// back to each snapshot on disk and recompiles the blocks that followed,
+// checking that the engine ends up in the same place.
+func TestRollbackToSnapshot(t *testing.T) {
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestRollbackToSnapshot"))
+	err := e.Bootstrap(state.Sibling{
+		WalletID: 1,
+	}, siacrypto.PublicKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < 2*SnapshotLength+1; i++ {
+		err = e.Compile(Block{
+			Height:      i,
+			ParentBlock: e.Metadata().ParentBlock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	finalMetadata := e.Metadata()
+
+	heads := e.SnapshotHeads()
+	if len(heads) != 2 {
+		t.Fatal("expecting two snapshots on disk, got", heads)
+	}
+
+	// Roll back to the oldest snapshot last, because rolling back to it
+	// forgets the history that precedes it.
+	for _, head := range heads {
+		// Load the blocks that need to be recompiled before they are
+		// overwritten.
+		var blocks []Block
+		for height := head; height < finalMetadata.Height; height++ {
+			b, err := e.LoadBlock(height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks = append(blocks, b)
+		}
+
+		err = e.RollbackToSnapshot(head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Metadata().Height != head {
+			t.Fatal("expecting height", head, "after rollback, got", e.Metadata().Height)
+		}
+		if len(e.WalletList()) != 2 {
+			t.Error("expecting 2 wallets after rollback, got", len(e.WalletList()))
+		}
+
+		for _, b := range blocks {
+			err = e.Compile(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if e.Metadata() != finalMetadata {
+			t.Error("metadata after recompiling from snapshot", head, "does not match original")
+		}
+		for height := head; height < finalMetadata.Height; height++ {
+			_, err = e.LoadBlock(height)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// Rolling back to a snapshot that isn't on disk should fail.
+	err = e.RollbackToSnapshot(finalMetadata.Height + 1)
+	if err == nil {
+		t.Error("rolled back to a snapshot that does not exist")
+	}
+}
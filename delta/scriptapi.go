@@ -6,18 +6,40 @@ import (
 	"github.com/NebulousLabs/Sia/state"
 )
 
+// The script API is the set of calls that scripts use to change the quorum.
+// Every call makes its changes through an overlay of the state, so that the
+// changes of a script only take effect if the whole script succeeds, see
+// Execute. The wallet running the script is passed separately, and it is up
+// to the caller to save it.
+
 // TODO: add docstring
 // If these are really constants, they should be moved to instructions.go
 const (
 	CreateWalletCost = 8
 	SendCost         = 6
 	AddSiblingCost   = 500
+
+	// SiblingCollateral is the volume of siacoins that a wallet puts up
+	// when adding a sibling. The collateral is returned if the sibling
+	// leaves gracefully or never gets placed, and is forfeited if the
+	// sibling is tossed.
+	SiblingCollateral = 1000
+
+	// ScheduleCost is the cost, in units of script cost, that a wallet
+	// prepays for every run of a scheduled input. The runs themselves are
+	// charged like any other script input.
+	ScheduleCost = 50
 )
 
 var (
 	errInsufficientBalance = errors.New("Insufficient balance to create a wallet with the given balance.")
+	errScheduleFee         = errors.New("Insufficient balance to prepay for every run of the scheduled input.")
+	errInsufficientFee     = errors.New("Insufficient balance to pay the fee of the script input.")
 
-	errNoEmptySiblings = errors.New("There are no empty spots in the quorum.")
+	errNoEmptyHopefuls = errors.New("There are no empty spots on the hopeful list.")
+	errKnownSibling    = errors.New("The sibling is already a sibling or hopeful of the quorum.")
+	errNotTethered     = errors.New("The sibling is not tethered to this wallet.")
+	errDeparting       = errors.New("The sibling is already departing.")
 
 	errInvalidK             = errors.New("K must hold either a value of 1 or 2.")
 	errTooFewAtoms          = errors.New("A sector must have more than QuorumSize atoms.")
@@ -31,37 +53,98 @@ var (
 	errLongDeadline         = errors.New("The deadline is too far in the future.")
 )
 
-// AddSibling tries to add the new sibling to the existing quorum
-// and throws the sibling out if there's no space. Once quorums are
-// communicating, the AddSibling routine will always succeed.
-func (e *Engine) AddSibling(w *state.Wallet, sib state.Sibling) (err error) {
-	// Down payment stuff will be added here.
-
-	// Look through the quorum for an empty sibling.
-	for i := byte(0); i < state.QuorumSize; i++ {
-		if e.state.Metadata.Siblings[i].Inactive() {
-			sib.Status = state.SiblingPassiveWindow
-			sib.Index = i
+// AddSibling adds the new sibling to the quorum's list of hopefuls. The
+// hopeful is placed into an empty sibling slot at the end of a compile, see
+// placeHopefuls. A participant can only be on the hopeful list once, and
+// cannot be on the hopeful list if it is already a sibling. Once quorums are
+// communicating, hopefuls that don't fit will be sent to other quorums.
+func (e *Engine) AddSibling(o *state.Overlay, w *state.Wallet, sib state.Sibling) (err error) {
+	// Check that the wallet can cover the collateral.
+	collateral := state.NewBalance(SiblingCollateral)
+	if w.Balance.Compare(collateral) < 0 {
+		err = errInsufficientBalance
+		return
+	}
+
+	// Check that the sibling isn't already known to the quorum.
+	for _, s := range o.Metadata.Siblings {
+		if !s.Inactive() && s.PublicKey == sib.PublicKey {
+			err = errKnownSibling
+			return
+		}
+	}
+	for _, h := range o.Metadata.Hopefuls {
+		if !h.Empty() && h.Sibling.PublicKey == sib.PublicKey {
+			err = errKnownSibling
+			return
+		}
+	}
+
+	for _, h := range o.Metadata.Successors {
+		if !h.Empty() && h.Sibling.PublicKey == sib.PublicKey {
+			err = errKnownSibling
+			return
+		}
+	}
+
+	// Look for an empty spot on the hopeful list, and charge the wallet
+	// the collateral.
+	for i := range o.Metadata.Hopefuls {
+		if o.Metadata.Hopefuls[i].Empty() {
+			w.Balance.Subtract(collateral)
 			sib.WalletID = w.ID
-			e.state.Metadata.Siblings[i] = sib
-			break
+			sib.Collateral = collateral
+			o.Metadata.Hopefuls[i] = state.Hopeful{
+				Sibling:  sib,
+				Deadline: o.Metadata.Height + state.HopefulLifetime,
+			}
+			return
 		}
 	}
+	err = errNoEmptyHopefuls
+	return
+}
 
-	if sib.Inactive() {
-		err = errNoEmptySiblings
+// LeaveSibling starts the departure of a sibling from the quorum. Only the
+// wallet that the sibling is tethered to can make the sibling leave. The
+// sibling keeps participating in consensus for SiblingDepartureWindow blocks,
+// during which a successor is recruited from the hopeful list, see
+// processDepartures.
+func (e *Engine) LeaveSibling(o *state.Overlay, w *state.Wallet, index byte) (err error) {
+	if index >= state.QuorumSize || o.Metadata.Siblings[index].Inactive() || o.Metadata.Siblings[index].WalletID != w.ID {
+		err = errNotTethered
+		return
+	}
+	if o.Metadata.Siblings[index].IsDeparting() {
+		err = errDeparting
 		return
 	}
 
-	// Charge the wallet some volume that's required as a down payment.
-
+	o.Metadata.Siblings[index].Departing = state.SiblingDepartureWindow
 	return
 }
 
+// UpdateAddress moves a sibling to the address in a signed address update.
+// Any wallet can submit the update, since the update is signed by the sibling
+// itself. This lets a sibling that can no longer reach its quorum announce
+// its new address through another participant.
+func (e *Engine) UpdateAddress(o *state.Overlay, w *state.Wallet, sau state.SignedAddressUpdate) (err error) {
+	return o.UpdateAddress(sau)
+}
+
 // CreateWallet takes an id, a Balance, and an initial script and uses
 // those to create a new wallet that gets stored in stable memory.
-// If a wallet of that id already exists then the process aborts.
-func (e *Engine) CreateWallet(w *state.Wallet, childID state.WalletID, childBalance state.Balance, childScript []byte) (err error) {
+// If a wallet of that id already exists then the process aborts. If 'verify'
+// is set, the script is first checked with VerifyScript, and a script with
+// problems is rejected.
+func (e *Engine) CreateWallet(o *state.Overlay, w *state.Wallet, childID state.WalletID, childBalance state.Balance, childScript []byte, verify bool) (err error) {
+	if verify {
+		err = VerifyScript(childScript)
+		if err != nil {
+			return
+		}
+	}
+
 	// Check that the wallet making the call has enough funds to deposit into the
 	// wallet being created, and then subtract the funds from the parent wallet.
 	if w.Balance.Compare(childBalance) < 0 {
@@ -78,7 +161,7 @@ func (e *Engine) CreateWallet(w *state.Wallet, childID state.WalletID, childBala
 	}
 
 	// Insert the child wallet.
-	err = e.state.InsertWallet(childWallet, true)
+	err = o.InsertWallet(childWallet)
 	if err != nil {
 		return
 	}
@@ -88,7 +171,7 @@ func (e *Engine) CreateWallet(w *state.Wallet, childID state.WalletID, childBala
 
 // Send is a call that sends siacoins from the source wallet to the destination
 // wallet.
-func (e *Engine) SendCoin(w *state.Wallet, amount state.Balance, destID state.WalletID) (err error) {
+func (e *Engine) SendCoin(o *state.Overlay, w *state.Wallet, amount state.Balance, destID state.WalletID) (err error) {
 	// Check that the source wallet contains enough to send the desired
 	// amount.
 	if w.Balance.Compare(amount) < 0 {
@@ -97,7 +180,7 @@ func (e *Engine) SendCoin(w *state.Wallet, amount state.Balance, destID state.Wa
 	}
 
 	// Check that the destination wallet is available.
-	destWallet, err := e.state.LoadWallet(destID)
+	destWallet, err := o.LoadWallet(destID)
 	if err != nil {
 		return
 	}
@@ -105,18 +188,37 @@ func (e *Engine) SendCoin(w *state.Wallet, amount state.Balance, destID state.Wa
 	// Commit the send.
 	w.Balance.Subtract(amount)
 	destWallet.Balance.Add(amount)
-	e.state.SaveWallet(destWallet)
+	err = o.SaveWallet(destWallet)
 
 	return
 }
 
+// Schedule schedules an input to the wallet's own script, prepaying
+// ScheduleCost for every run of the input. When the input runs, the script
+// sees its own wallet as the caller.
+func (e *Engine) Schedule(o *state.Overlay, w *state.Wallet, si state.ScheduledInput) (err error) {
+	fee := o.Metadata.ScriptPrice
+	fee.Multiply(state.NewBalance(ScheduleCost * uint64(si.Runs())))
+	if w.Balance.Compare(fee) < 0 {
+		err = errScheduleFee
+		return
+	}
+
+	err = o.InsertScheduledInput(w, si)
+	if err != nil {
+		return
+	}
+	w.Balance.Subtract(fee)
+	return
+}
+
 // UpdateSector takes a different approach, which is essentially to completely
 // outsource the function to the state package, reporting an error if needed. I
 // have no idea if this is a good approach, but at somepoint we'll need to
 // standardize around a single approach. It's probably better that this file is
 // the leaner.
-func (e *Engine) UpdateSector(w *state.Wallet, su state.SectorUpdate) (err error) {
-	err = e.state.InsertSectorUpdate(w, su)
+func (e *Engine) UpdateSector(o *state.Overlay, w *state.Wallet, su state.SectorUpdate) (err error) {
+	err = o.InsertSectorUpdate(w, su)
 	if err != nil {
 		return
 	}
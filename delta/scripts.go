@@ -26,11 +26,18 @@ func appendAll(slices ...[]byte) []byte {
 	return all
 }
 
+// signedMessage returns the part of a ScriptInput that is signed, which is
+// what the default scripts verify: the Deadline, the Fee, and the Input. The
+// fee is signed so that whoever passes the input on can't raise it.
+func signedMessage(si *state.ScriptInput) []byte {
+	return appendAll(siaencoding.EncUint32(si.Deadline), si.Fee[:], si.Input)
+}
+
 // SignScriptInput modifies a ScriptInput to contain a signature of its own
-// data. Currently, only the Input and Deadline fields are included in the
-// signature.
+// data. Currently, only the Input, Deadline and Fee fields are included in
+// the signature.
 func SignScriptInput(si *state.ScriptInput, secretKey siacrypto.SecretKey) (err error) {
-	sig, err := secretKey.Sign(append(siaencoding.EncUint32(si.Deadline), si.Input...))
+	sig, err := secretKey.Sign(signedMessage(si))
 	if err != nil {
 		return
 	}
@@ -71,6 +78,22 @@ func AddSiblingInput(wid state.WalletID, deadline uint32, sib state.Sibling, sk
 	return
 }
 
+// LeaveSiblingInput returns a signed ScriptInput that calls the LeaveSibling
+// function for the sibling at 'index'. Like AddSiblingInput, it is intended to
+// be passed to a script that transfers execution to the input.
+func LeaveSiblingInput(wid state.WalletID, deadline uint32, index byte, sk siacrypto.SecretKey) (si state.ScriptInput, err error) {
+	si.WalletID = wid
+	si.Input = []byte{
+		0x01, index, // push sibling index
+		0x45, //        call LeaveSibling
+		0xFF, //        exit
+	}
+	si.Deadline = deadline
+
+	err = SignScriptInput(&si, sk)
+	return
+}
+
 // TransactionInput returns a script that calls the Send function. It is
 // intended to be passed to a script that transfers execution to the input.
 func SendCoinInput(dest state.WalletID, amount state.Balance) []byte {
@@ -87,6 +110,25 @@ func SendCoinInput(dest state.WalletID, amount state.Balance) []byte {
 	)
 }
 
+// ScheduleInput returns a script that calls the Schedule function. It is
+// intended to be passed to a script that transfers execution to the input.
+func ScheduleInput(si state.ScheduledInput) (input []byte, err error) {
+	encSchedule, err := siaencoding.Marshal(si)
+	if err != nil {
+		return
+	}
+	input = appendAll(
+		[]byte{
+			0xE6, 0xFF, // move data pointer to encoded schedule
+			0xE4, //       push encoded schedule
+			0x4C, //       call Schedule
+			0xFF, //       exit
+		},
+		encSchedule,
+	)
+	return
+}
+
 // UpdateSectorInput returns a script that calls the UpdateSector function. It
 // is intended to be passed to a script that transfers execution to the input.
 func UpdateSectorInput(su state.SectorUpdate) []byte {
@@ -137,10 +179,63 @@ func DefaultScript(publicKey siacrypto.PublicKey) []byte {
 		0x34, keyl, //       03 push public key
 		0x34, sigl, //       04 push signature
 		0x46, //             06 push deadline
-		0xE4, //             07 push input
-		0x23, //             08 concatenate deadline and input
-		0x40, //             09 verify signature
-		0xE5, //             10 if invalid signature, reject
-		0x38, //             11 execute input
+		0x4D, //             07 push fee
+		0x23, //             08 concatenate deadline and fee
+		0xE4, //             09 push input
+		0x23, //             10 concatenate with input
+		0x40, //             11 verify signature
+		0xE5, //             12 if invalid signature, reject
+		0x38, //             13 execute input
 	}, publicKey[:]...)
 }
+
+// MultisigScript returns a script that transfers control to the input if the
+// input is signed by at least 'm' of 'publicKeys'. The public keys are stored
+// at the end of the script. Inputs for the script are signed with
+// SignMultisigInput.
+func MultisigScript(m byte, publicKeys []siacrypto.PublicKey) []byte {
+	keys := make([]byte, 0, len(publicKeys)*siacrypto.PublicKeySize)
+	for _, pk := range publicKeys {
+		keys = append(keys, pk[:]...)
+	}
+	keyl, keyh := short(len(keys))
+	negl, negh := short(-len(keys) - 2)
+	return appendAll(
+		[]byte{
+			0x01, m, //          00 push number of required signatures
+			0x33, negl, negh, // 02 move data pointer to public keys
+			0xE3, //             05 push public keys
+			0xE3, //             06 push signatures
+			0x46, //             07 push deadline
+			0x4D, //             08 push fee
+			0x23, //             09 concatenate deadline and fee
+			0xE4, //             10 push input
+			0x23, //             11 concatenate with input
+			0x49, //             12 check signatures
+			0xE5, //             13 if too few valid signatures, reject
+			0x38, //             14 execute input
+		},
+		[]byte{keyl, keyh},
+		keys,
+	)
+}
+
+// SignMultisigInput modifies a ScriptInput to contain signatures of its own
+// data, for use with a script created by MultisigScript. The secret keys must
+// be in the same order as their public keys in the script. Like
+// SignScriptInput, only the Input, Deadline and Fee fields are signed.
+func SignMultisigInput(si *state.ScriptInput, secretKeys []siacrypto.SecretKey) (err error) {
+	msg := signedMessage(si)
+	sigs := make([]byte, 0, len(secretKeys)*siacrypto.SignatureSize)
+	for _, sk := range secretKeys {
+		var sig siacrypto.Signature
+		sig, err = sk.Sign(msg)
+		if err != nil {
+			return
+		}
+		sigs = append(sigs, sig[:]...)
+	}
+	sigl, sigh := short(len(sigs))
+	si.Input = appendAll([]byte{sigl, sigh}, sigs, si.Input)
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/NebulousLabs/Sia/siacrypto"
 	"github.com/NebulousLabs/Sia/siaencoding"
 	"github.com/NebulousLabs/Sia/siafiles"
 	"github.com/NebulousLabs/Sia/state"
@@ -352,3 +353,28 @@ func (e *Engine) LoadSnapshotWallet(snapshotHead uint32, walletID state.WalletID
 	err = errors.New("wallet is not stored within this snapshot")
 	return
 }
+
+// LoadSnapshotWalletHashes returns the hash of every wallet in a snapshot, in
+// the same order as LoadSnapshotWalletList. Participants downloading a
+// snapshot use the hashes to check wallets downloaded from one sibling
+// against the snapshots held by the other siblings.
+func (e *Engine) LoadSnapshotWalletHashes(snapshotHead uint32) (hashes []siacrypto.Hash, err error) {
+	walletList, err := e.LoadSnapshotWalletList(snapshotHead)
+	if err != nil {
+		return
+	}
+
+	hashes = make([]siacrypto.Hash, len(walletList))
+	for i, id := range walletList {
+		var w state.Wallet
+		w, err = e.LoadSnapshotWallet(snapshotHead, id)
+		if err != nil {
+			return
+		}
+		hashes[i], err = siacrypto.HashObject(w)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package delta
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// A sync stage is an on-disk record of the wallets that have been downloaded
+// and verified while synchronizing to a snapshot held by other siblings. If
+// the synchronization is interrupted, the wallets in the stage do not need to
+// be downloaded again.
+//
+// The layout of a sync stage file is as follows:
+// 1. The hash of the snapshot header that the wallets were verified against.
+// 2. A list of wallets, each prefixed by the length of the encoded wallet.
+//
+// A wallet that was only partially written when the sync was interrupted is
+// discarded when the stage is reopened.
+
+const (
+	syncStageRecordPrefixLength = 4
+)
+
+var (
+	errSyncStageClosed = errors.New("sync stage has been closed")
+)
+
+// A SyncStage is an open sync stage file. Wallets can be staged from multiple
+// goroutines at once.
+type SyncStage struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+func (e *Engine) syncStageFilename(snapshotHead uint32) string {
+	return fmt.Sprintf("%ssync.%v", e.filePrefix, snapshotHead)
+}
+
+// OpenSyncStage opens the sync stage for the snapshot at 'snapshotHead',
+// returning any wallets that were staged by an earlier sync. If the existing
+// stage was created for a different snapshot header, it is discarded and a
+// new stage is started.
+func (e *Engine) OpenSyncStage(snapshotHead uint32, headerHash siacrypto.Hash) (stage *SyncStage, staged []state.Wallet, err error) {
+	filename := e.syncStageFilename(snapshotHead)
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+
+	// Check that the stage belongs to the same snapshot header.
+	var stagedHash siacrypto.Hash
+	_, err = io.ReadFull(file, stagedHash[:])
+	if err != nil || stagedHash != headerHash {
+		err = file.Truncate(0)
+		if err != nil {
+			file.Close()
+			return
+		}
+		_, err = file.WriteAt(headerHash[:], 0)
+		if err != nil {
+			file.Close()
+			return
+		}
+		_, err = file.Seek(int64(siacrypto.HashSize), 0)
+		if err != nil {
+			file.Close()
+			return
+		}
+		stage = &SyncStage{file: file}
+		return
+	}
+
+	// Read wallets until the end of the file, stopping at the first record
+	// that is incomplete or corrupt.
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	validLength := int64(siacrypto.HashSize)
+	for {
+		prefix := make([]byte, syncStageRecordPrefixLength)
+		_, err = io.ReadFull(file, prefix)
+		if err != nil {
+			break
+		}
+		walletLength := int64(siaencoding.DecUint32(prefix))
+		if validLength+syncStageRecordPrefixLength+walletLength > info.Size() {
+			break
+		}
+		encodedWallet := make([]byte, walletLength)
+		_, err = io.ReadFull(file, encodedWallet)
+		if err != nil {
+			break
+		}
+		var w state.Wallet
+		err = siaencoding.Unmarshal(encodedWallet, &w)
+		if err != nil {
+			break
+		}
+		staged = append(staged, w)
+		validLength += int64(syncStageRecordPrefixLength + len(encodedWallet))
+	}
+
+	// Drop anything following the last complete wallet.
+	err = file.Truncate(validLength)
+	if err != nil {
+		file.Close()
+		return
+	}
+	_, err = file.Seek(validLength, 0)
+	if err != nil {
+		file.Close()
+		return
+	}
+	stage = &SyncStage{file: file}
+	return
+}
+
+// StageWallet appends a verified wallet to the stage.
+func (ss *SyncStage) StageWallet(w state.Wallet) (err error) {
+	encodedWallet, err := siaencoding.Marshal(w)
+	if err != nil {
+		return
+	}
+	record := append(siaencoding.EncUint32(uint32(len(encodedWallet))), encodedWallet...)
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.file == nil {
+		err = errSyncStageClosed
+		return
+	}
+	_, err = ss.file.Write(record)
+	return
+}
+
+// Close closes the stage file, keeping it on disk so that the sync can be
+// resumed.
+func (ss *SyncStage) Close() (err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.file == nil {
+		return
+	}
+	err = ss.file.Close()
+	ss.file = nil
+	return
+}
+
+// RemoveSyncStage deletes the sync stage for the snapshot at 'snapshotHead',
+// which should happen once the sync has finished.
+func (e *Engine) RemoveSyncStage(snapshotHead uint32) error {
+	return siafiles.Remove(e.syncStageFilename(snapshotHead))
+}
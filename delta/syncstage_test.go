@@ -0,0 +1,91 @@
+package delta
+
+import (
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siafiles"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestSyncStage stages wallets, interrupts the stage partway through a write,
+// and checks that reopening the stage returns exactly the complete wallets.
+func TestSyncStage(t *testing.T) {
+	var e Engine
+	e.Initialize(nil, siafiles.TempFilename("TestSyncStage"))
+	headerHash := siacrypto.HashBytes([]byte("header"))
+
+	stage, staged, err := e.OpenSyncStage(5, headerHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 0 {
+		t.Fatal("new stage contains wallets")
+	}
+	for i := 1; i <= 3; i++ {
+		err = stage.StageWallet(state.Wallet{ID: state.WalletID(i), Script: []byte{byte(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stage.Close()
+	err = stage.StageWallet(state.Wallet{ID: 4})
+	if err != errSyncStageClosed {
+		t.Error("expecting errSyncStageClosed, got", err)
+	}
+
+	// Simulate a wallet that was only partially written.
+	file, err := os.OpenFile(e.syncStageFilename(5), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 200, '{'})
+	file.Close()
+
+	stage, staged, err = e.OpenSyncStage(5, headerHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 3 {
+		t.Fatal("expecting 3 staged wallets, got", len(staged))
+	}
+	for i, w := range staged {
+		if w.ID != state.WalletID(i+1) || w.Script[0] != byte(i+1) {
+			t.Error("staged wallet", i, "does not match:", w)
+		}
+	}
+
+	// Wallets staged after the partial write should be readable.
+	err = stage.StageWallet(state.Wallet{ID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage.Close()
+	stage, staged, err = e.OpenSyncStage(5, headerHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage.Close()
+	if len(staged) != 4 {
+		t.Error("expecting 4 staged wallets, got", len(staged))
+	}
+
+	// A stage for a different header should start out empty.
+	stage, staged, err = e.OpenSyncStage(5, siacrypto.HashBytes([]byte("other")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage.Close()
+	if len(staged) != 0 {
+		t.Error("stage for a different header was reused")
+	}
+
+	err = e.RemoveSyncStage(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if siafiles.Exists(e.syncStageFilename(5)) {
+		t.Error("stage was not removed")
+	}
+}
@@ -0,0 +1,195 @@
+package delta
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/sialog"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// A dry run executes a script input the same way that Execute does, but
+// keeps none of the changes that the script makes, charges nothing, and
+// records a TraceStep for every instruction that the script runs. This lets
+// script authors see what their scripts do without spending any siacoins and
+// without reading hex. Instructions run by the scripts of called wallets are
+// recorded as well, with a greater Depth.
+//
+// Dry runs can be requested by anyone, so a trace holds at most
+// maxTraceSteps steps, and each step holds at most the top maxTraceStack
+// values of the stack. The steps that don't fit are counted, but not kept.
+
+const (
+	maxTraceSteps = 1 << 12
+	maxTraceStack = 32
+)
+
+// registerArg holds the index of the argument that names a register, for
+// every opcode that reads or writes a register.
+var registerArg = map[byte]int{
+	0x30: 0, // store
+	0x31: 0, // load
+	0x35: 1, // data_store
+	0x36: 0, // data_copy
+	0x37: 0, // data_paste
+	0xE1: 0, // store_prefix
+	0xE2: 0, // store_rest
+}
+
+// A TraceStep is the state of a script right after one of its instructions
+// has run.
+type TraceStep struct {
+	WalletID state.WalletID // wallet whose script ran the instruction
+	Depth    int            // number of calls leading to the script
+
+	Iptr   int // offset of the instruction in the script and input
+	Opcode byte
+	Args   []byte
+
+	Stack     [][]byte        // the top of the stack, top first
+	StackLen  int             // the number of values on the stack
+	Registers map[byte][]byte // the registers that the instruction read or wrote
+	Dptr      int
+
+	// the resources left, and the memory in use when the instruction
+	// started
+	InstBalance int
+	CostBalance int
+	MemUsage    int
+
+	// the error of the instruction, if it failed or rejected the input
+	Err string
+}
+
+// A ScriptTrace is the result of a dry run. Err is the error that ended the
+// script, if there was one, and CostUsed is the cost that the wallet would
+// have been charged for. Dropped is the number of steps that ran after the
+// trace was full.
+type ScriptTrace struct {
+	Steps    []TraceStep
+	Dropped  int
+	CostUsed int
+	Err      string
+}
+
+// traceStep records the state of the script after the instruction at 'iptr'
+// has run. The opcode is passed in, since the instruction may have changed
+// the script. Values are copied, since opcodes can change popped values in
+// place.
+func (env *scriptEnv) traceStep(iptr int, opcode byte, args []byte, err error) (ts TraceStep) {
+	ts = TraceStep{
+		WalletID:    env.wallet.ID,
+		Depth:       env.depth,
+		Iptr:        iptr,
+		Opcode:      opcode,
+		Args:        args,
+		Dptr:        env.dptr,
+		InstBalance: env.instBalance,
+		CostBalance: env.costBalance,
+		MemUsage:    env.memUsage,
+	}
+	for p := env.stack; p != nil; p = p.next {
+		if ts.StackLen < maxTraceStack {
+			ts.Stack = append(ts.Stack, append([]byte(nil), p.val...))
+		}
+		ts.StackLen++
+	}
+	if i, ok := registerArg[ts.Opcode]; ok {
+		r := args[i]
+		ts.Registers = map[byte][]byte{r: append([]byte(nil), env.registers[r]...)}
+	}
+	if err != nil && err != errExit {
+		ts.Err = err.Error()
+	}
+	return
+}
+
+// String prints a step on a single line: the offset and the instruction,
+// followed by what the instruction left behind.
+func (ts TraceStep) String() string {
+	op := opTable[ts.Opcode]
+	s := fmt.Sprintf("%*s%5d  %-24s stack: {", 2*ts.Depth, "", ts.Iptr, op.print(ts.Args))
+	for _, v := range ts.Stack {
+		s += " " + printValue(v)
+	}
+	if ts.StackLen > len(ts.Stack) {
+		s += fmt.Sprintf(" ...%d more", ts.StackLen-len(ts.Stack))
+	}
+	s += " }"
+	for r, v := range ts.Registers {
+		s += fmt.Sprintf("  r%d: %s", r, printValue(v))
+	}
+	s += fmt.Sprintf("  dptr: %d  inst: %d  cost: %d", ts.Dptr, ts.InstBalance, ts.CostBalance)
+	if ts.Err != "" {
+		s += "  error: " + ts.Err
+	}
+	return s
+}
+
+// dryRun runs a script input against the wallets in 'o', see the top of this
+// file. 'err' is only set if the input can't be run at all. dryRun only reads
+// the engine's state through 'o', and the script price from o.Metadata.
+func (e *Engine) dryRun(o *state.Overlay, si state.ScriptInput) (trace ScriptTrace, err error) {
+	w, err := o.LoadWallet(si.WalletID)
+	if err != nil {
+		return
+	}
+	if w.Balance.Compare(si.Fee) < 0 {
+		err = errInsufficientFee
+		return
+	}
+	w.Balance.Subtract(si.Fee)
+	price := o.Metadata.ScriptPrice
+	costLimit := scriptCostLimit(price, w.Balance, si.CostLimit)
+	w.Balance.Subtract(scriptCharge(price, costLimit))
+
+	env := e.newEnv(o, &w, si, nil, costLimit)
+	env.trace = func(ts TraceStep) {
+		if len(trace.Steps) >= maxTraceSteps {
+			trace.Dropped++
+			return
+		}
+		trace.Steps = append(trace.Steps, ts)
+	}
+	runErr := env.run()
+	trace.CostUsed = costLimit - env.costBalance
+	if trace.CostUsed > costLimit {
+		trace.CostUsed = costLimit
+	}
+	if runErr != nil {
+		trace.Err = runErr.Error()
+	}
+	return
+}
+
+// DryRun runs a script input against the current state of the engine without
+// keeping any of its changes, and returns the trace of the run. If 'lock' is
+// not nil, it is the lock that guards the engine, and the dry run only holds
+// it while it reads from the engine's state rather than for the whole run.
+// The caller should not hold 'lock'.
+func (e *Engine) DryRun(si state.ScriptInput, lock sync.Locker) (trace ScriptTrace, err error) {
+	if lock == nil {
+		return e.dryRun(e.state.NewOverlay(), si)
+	}
+	lock.Lock()
+	o := e.state.NewLockedOverlay(lock)
+	lock.Unlock()
+	return e.dryRun(o, si)
+}
+
+// DryRunWallet runs a script input against a single wallet, such as a wallet
+// loaded from a snapshot or built by a script author, in a quorum with the
+// metadata 'm'. No other wallets exist during the run, so sending coins or
+// calling other wallets fails.
+func DryRunWallet(w state.Wallet, m state.Metadata, si state.ScriptInput) (trace ScriptTrace, err error) {
+	var e Engine
+	e.SetLogger(sialog.Default)
+	e.state.Metadata = m
+	o := e.state.NewOverlay()
+	err = o.InsertWallet(w)
+	if err != nil {
+		return
+	}
+	si.WalletID = w.ID
+	return e.dryRun(o, si)
+}
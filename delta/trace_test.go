@@ -0,0 +1,122 @@
+package delta
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestDryRun checks the steps recorded by a dry run, and that a dry run
+// leaves the state untouched.
+func TestDryRun(t *testing.T) {
+	e, si := initEnv()
+	e.state.Metadata.ScriptPrice = state.NewBalance(1)
+	e.state.InsertWallet(state.Wallet{ID: 2}, true)
+	checkBalance := func(id state.WalletID, expected uint64) {
+		w, err := e.state.LoadWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != state.NewBalance(expected) {
+			t.Error("expecting wallet", id, "to have a balance of", expected, "got", w.Balance)
+		}
+	}
+
+	// a send that succeeds is not committed or charged
+	si.Input = SendCoinInput(2, state.NewBalance(100))
+	trace, err := e.DryRun(si, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.Err != "" {
+		t.Fatal(trace.Err)
+	}
+	if len(trace.Steps) != 6 {
+		t.Fatal("expecting 6 steps, got", len(trace.Steps))
+	}
+	if trace.Steps[0].Opcode != 0x38 || trace.Steps[5].Opcode != 0xFF {
+		t.Error("expecting the trace to go from transfer to exit, got", trace.Steps)
+	}
+	if trace.CostUsed != trace.Steps[0].CostBalance+opTable[0x38].cost-trace.Steps[5].CostBalance {
+		t.Error("cost used doesn't match the steps:", trace.CostUsed)
+	}
+	checkBalance(1, 15000)
+	checkBalance(2, 0)
+
+	// a failing script records the failing instruction and the registers
+	si.Input = []byte{
+		0x01, 0x07, // push 7
+		0x30, 0x01, // store r1
+		0x31, 0x01, // load r1
+		0x08, //       sub_int
+	}
+	trace, err = e.DryRun(si, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.Err == "" || len(trace.Steps) != 5 {
+		t.Fatal("expecting the fifth step to fail, got", trace)
+	}
+	push, store, load, sub := trace.Steps[1], trace.Steps[2], trace.Steps[3], trace.Steps[4]
+	if push.Iptr != 1 || len(push.Stack) != 1 || !bytes.Equal(push.Stack[0], []byte{7}) {
+		t.Error("unexpected push step:", push)
+	}
+	if len(store.Stack) != 0 || !bytes.Equal(store.Registers[1], []byte{7}) {
+		t.Error("unexpected store step:", store)
+	}
+	if len(load.Stack) != 1 || load.Registers[1] == nil {
+		t.Error("unexpected load step:", load)
+	}
+	if sub.Err == "" || !strings.Contains(sub.String(), "sub_int") {
+		t.Error("unexpected sub_int step:", sub)
+	}
+	checkBalance(1, 15000)
+
+	// a wallet on its own can be run, but can't reach other wallets
+	w, err := e.state.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ID = 7
+	trace, err = DryRunWallet(w, state.Metadata{}, state.ScriptInput{Input: []byte{0x01, 0x01, 0xFF}})
+	if err != nil || trace.Err != "" || len(trace.Steps) != 3 {
+		t.Error("unexpected trace of a standalone wallet:", trace, err)
+	}
+	trace, err = DryRunWallet(w, state.Metadata{}, state.ScriptInput{Input: SendCoinInput(2, state.NewBalance(1))})
+	if err != nil || trace.Err == "" {
+		t.Error("expecting a send to a missing wallet to fail, got", trace, err)
+	}
+}
+
+// TestDryRunLimits checks that a trace keeps at most maxTraceSteps steps and
+// the top maxTraceStack values of each stack, and that a dry run under a lock
+// doesn't hold the lock while the script runs.
+func TestDryRunLimits(t *testing.T) {
+	e, si := initEnv()
+	si.Input = []byte{
+		0x01, 0x07, 0x04, // push 7, dup
+		0x21, 0x04, 0x00, // goto dup
+	}
+	var lock sync.RWMutex
+	trace, err := e.DryRun(si, lock.RLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Steps) != maxTraceSteps || trace.Dropped == 0 {
+		t.Fatal("expecting the trace to be capped, got", len(trace.Steps), "steps and", trace.Dropped, "dropped")
+	}
+	last := trace.Steps[len(trace.Steps)-1]
+	if len(last.Stack) != maxTraceStack || last.StackLen <= maxTraceStack {
+		t.Error("expecting the stack to be capped, got", len(last.Stack), "of", last.StackLen)
+	}
+	if !strings.Contains(last.String(), "more") {
+		t.Error("capped stack is not marked:", last)
+	}
+
+	// The lock is free again once the dry run returns.
+	lock.Lock()
+	lock.Unlock()
+}
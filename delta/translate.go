@@ -1,12 +1,44 @@
 package delta
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/state"
 )
 
+// WordsToBytes is an assembler for the bytecode. A program has one statement
+// per line, and everything after a ';' is a comment. A statement is an opcode
+// name followed by its arguments, or a directive starting with a '.'. Any
+// statement can be preceded by labels, which are names followed by a ':'.
+//
+// Opcodes that take a two byte argument (goto, if_goto, move, if_move,
+// push_short, data_goto and data_move) take a single number between -32768
+// and 65535, and other opcodes take one number between 0 and 255 for each
+// argument byte. Numbers can be written in decimal or in hex with a 0x
+// prefix. A label can be used in place of the argument of goto, if_goto,
+// move, if_move, data_goto and push_short, and is turned into whatever
+// argument makes the opcode jump to, or point at, the label. Constants and
+// registers can be named with directives and used in place of any number:
+//
+//	.const NAME VALUE    names a number
+//	.reg NAME INDEX      names a register
+//	.base ADDRESS        sets the address of the first byte, which is 0 for
+//	                     a wallet script and the length of the wallet script
+//	                     for an input; it must come before any code or data
+//	.data KIND VALUE...  puts data into the script
+//
+// The kinds of data are 'hex' for hex bytes, 'string' for quoted strings,
+// 'int8', 'int16', 'int32' and 'int64' for little-endian integers, 'pubkey'
+// for hex-encoded public keys, and 'balance' for decimal Balances.
+//
+// Errors are reported with the line that caused them.
+
 const hextable = "0123456789ABCDEF"
 
 func encodeHex(b []byte) string {
@@ -19,18 +51,26 @@ func encodeHex(b []byte) string {
 	return string(dst[:len(dst)-1])
 }
 
-// not perfect, but usually good enough
+// findDataSection walks the instructions of a script, and guesses that the
+// data starts after the last instruction that ends execution before the walk
+// runs into something that isn't an instruction. This is not always
+// accurate, but a wrong guess only changes how the script is printed.
 func findDataSection(script []byte) (index int) {
-	index = len(script)
-	for i, b := range script {
-		// exit, reject, cond_reject, transfer
-		if b == 0xFF || b == 0xFE || b == 0xE5 || b == 0x38 {
-			index = i + 1
-		}
-		// these are good indicators that we're inside a data block
-		if int(b) >= len(opTable) || i+opTable[b].argBytes >= len(script) {
+	index = -1
+	i := 0
+	for i < len(script) {
+		op, ok := opTable[script[i]]
+		if !ok || i+op.argBytes >= len(script) {
 			break
 		}
+		i += 1 + op.argBytes
+		// exit, reject, cond_reject, transfer
+		if b := script[i-1-op.argBytes]; b == 0xFF || b == 0xFE || b == 0xE5 || b == 0x38 {
+			index = i
+		}
+	}
+	if index == -1 {
+		index = i
 	}
 	return
 }
@@ -38,6 +78,8 @@ func findDataSection(script []byte) (index int) {
 // this might be added as a field in the instruction type later
 var shortArg [256]bool
 
+// opcodeMap maps the names of opcodes to their bytes. It is built in the same
+// init as the last entries of opTable, see instructions.go.
 var opcodeMap map[string]byte
 
 func init() {
@@ -48,41 +90,72 @@ func init() {
 	shortArg[0x22] = true // move
 	shortArg[0x32] = true // data_goto
 	shortArg[0x33] = true // data_move
+}
 
-	// build name -> opcode map
-	opcodeMap = make(map[string]byte)
-	for opcode, op := range opTable {
-		opcodeMap[op.name] = opcode
+// labelTarget returns the address that the argument of a short-argument
+// opcode at 'offset' points to, or false if the argument isn't an address.
+func labelTarget(opcode byte, arg int, offset int) (target int, ok bool) {
+	switch opcode {
+	case 0x1F, 0x21: // if_goto, goto
+		return arg - 1, true
+	case 0x20, 0x22: // if_move, move
+		return offset + 2 + arg, true
+	case 0x32: // data_goto
+		return arg, true
 	}
+	return
 }
 
-// BytesToWords converts a script to human-readable opcodes.
-// If a 'data section' is located, it is separated from the opcodes
-// by a divider. This is not always accurate.
+// labelArg is the inverse of labelTarget, returning the argument that makes
+// a short-argument opcode at 'offset' point to 'target'. push_short pushes
+// the address itself.
+func labelArg(opcode byte, target int, offset int) (arg int, ok bool) {
+	switch opcode {
+	case 0x1F, 0x21: // if_goto, goto
+		return target + 1, true
+	case 0x20, 0x22: // if_move, move
+		return target - offset - 2, true
+	case 0x02, 0x32: // push_short, data_goto
+		return target, true
+	}
+	return
+}
+
+// BytesToWords converts a script to assembly that WordsToBytes turns back
+// into the same script. Jump targets are given labels, and the bytes after
+// the instructions are printed as hex data. Where the instructions end is
+// guessed, see findDataSection. Addresses assume that the script starts at
+// 0, as a wallet script does.
 func BytesToWords(script []byte) (s string, err error) {
-	// locate data section, if there is one
 	dataIndex := findDataSection(script)
 
-	for i := 0; i < len(script); i++ {
-		if i == dataIndex {
-			s += "<--data-->\n"
-			// print hex-formatted data in rows of 32
-			for i < len(script) {
-				b := make([]byte, 32)
-				n := copy(b, script[i:])
-				s += encodeHex(b[:n]) + "\n"
-				i += n
-			}
-			break
+	// find the start of every instruction
+	starts := make(map[int]bool)
+	for i := 0; i < dataIndex; i += 1 + opTable[script[i]].argBytes {
+		starts[i] = true
+	}
+
+	// give a label to every jump target that is the start of an
+	// instruction, a byte of data, or the end of the script
+	labels := make(map[int]string)
+	for i := range starts {
+		opcode := script[i]
+		if !shortArg[opcode] {
+			continue
 		}
+		target, ok := labelTarget(opcode, s2i(script[i+1], script[i+2]), i)
+		if ok && (starts[target] || (target >= dataIndex && target <= len(script))) {
+			labels[target] = fmt.Sprintf("L%d", target)
+		}
+	}
 
-		// unknown opcode
-		if int(script[i]) >= len(opTable) {
-			err = errors.New("error parsing script")
-			return
+	for i := 0; i < dataIndex; i++ {
+		if label, ok := labels[i]; ok {
+			s += label + ":\n"
 		}
 
-		op := opTable[script[i]]
+		opcode := script[i]
+		op := opTable[opcode]
 		s += op.name
 
 		// unrolled loop, since there are only two arguments max
@@ -90,10 +163,16 @@ func BytesToWords(script []byte) (s string, err error) {
 			s += fmt.Sprint(" ", script[i+1])
 		} else if op.argBytes == 2 {
 			// combine two bytes into one number where appropriate
-			if shortArg[script[i]] {
-				s += fmt.Sprint(" ", s2i(script[i+1], script[i+2]))
+			if shortArg[opcode] {
+				arg := s2i(script[i+1], script[i+2])
+				target, _ := labelTarget(opcode, arg, i)
+				if label, ok := labels[target]; ok {
+					s += " " + label
+				} else {
+					s += fmt.Sprint(" ", arg)
+				}
 			} else {
-				s += fmt.Sprint(" ", script[i+1], script[i+2])
+				s += fmt.Sprint(" ", script[i+1], " ", script[i+2])
 			}
 		}
 
@@ -101,68 +180,360 @@ func BytesToWords(script []byte) (s string, err error) {
 		i += op.argBytes
 	}
 
+	// print hex-formatted data in rows of 32, starting a new row at each
+	// label
+	for i := dataIndex; i < len(script); {
+		if label, ok := labels[i]; ok {
+			s += label + ":\n"
+		}
+		end := i + 32
+		if end > len(script) {
+			end = len(script)
+		}
+		for j := i + 1; j < end; j++ {
+			if _, ok := labels[j]; ok {
+				end = j
+				break
+			}
+		}
+		s += ".data hex " + encodeHex(script[i:end]) + "\n"
+		i = end
+	}
+	if label, ok := labels[len(script)]; ok {
+		s += label + ":\n"
+	}
 	return
 }
 
-// WordsToBytes converts human-readable opcodes to their bytecode equivalents.
-// If a 'data section' separator is encountered, the remaining input is parsed
-// as hex literals and converted to bytes directly.
-func WordsToBytes(script string) (b []byte, err error) {
-	// simple tokenization using a whitespace separator
-	tokens := strings.Fields(script)
-
-	for i := 0; i < len(tokens); i++ {
-		// parse rest of script as hex literals
-		if tokens[i] == "<--data-->" {
-			var data byte
-			for i++; i < len(tokens); i++ {
-				fmt.Sscanf(tokens[i], "%X", &data)
-				b = append(b, data)
+// An asmStatement is an instruction or data directive, placed at 'offset'.
+type asmStatement struct {
+	line   int
+	offset int
+	opcode byte
+	args   []string
+	data   []byte
+	isData bool
+}
+
+// An assembler holds the symbols and statements of a program while it is
+// being assembled.
+type assembler struct {
+	base       int
+	size       int
+	labels     map[string]int
+	constants  map[string]int64
+	statements []asmStatement
+}
+
+// tokenize splits a line into fields, keeping quoted strings together and
+// dropping comments.
+func tokenize(line string) (fields []string, err error) {
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				err = errors.New("unterminated string")
+				return
 			}
+			fields = append(fields, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[j])) {
+				j++
+			}
+			fields = append(fields, line[i:j])
+			i = j
+		}
+	}
+	return
+}
+
+// validName returns true if 'name' can be used as a label or constant.
+func validName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// define adds a symbol to the program, checking that the name is free.
+func (a *assembler) define(name string) (err error) {
+	if !validName(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if _, exists := opcodeMap[name]; exists {
+		return fmt.Errorf("%v is the name of an opcode", name)
+	}
+	_, isLabel := a.labels[name]
+	_, isConstant := a.constants[name]
+	if isLabel || isConstant {
+		return fmt.Errorf("%v is already defined", name)
+	}
+	return
+}
+
+// number parses a number, or the value of a constant, between 'min' and
+// 'max'.
+func (a *assembler) number(token string, min, max int64) (n int64, err error) {
+	n, ok := a.constants[token]
+	if !ok {
+		n, err = strconv.ParseInt(token, 0, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid number %q", token)
 			return
 		}
+	}
+	if n < min || n > max {
+		err = fmt.Errorf("%v is out of range [%v, %v]", token, min, max)
+	}
+	return
+}
 
-		// parse opcode
-		opcode, ok := opcodeMap[tokens[i]]
-		if !ok {
-			err = fmt.Errorf("expected opcode, got %v", tokens[i])
+// integer parses a number that fits into 'bits' bits, as a signed or an
+// unsigned integer.
+func (a *assembler) integer(token string, bits uint) (n int64, err error) {
+	if bits == 64 {
+		if u, uErr := strconv.ParseUint(token, 0, 64); uErr == nil {
+			return int64(u), nil
+		}
+		return a.number(token, -1<<63, 1<<63-1)
+	}
+	return a.number(token, -1<<(bits-1), 1<<bits-1)
+}
+
+// parseData turns the values of a .data directive into bytes.
+func (a *assembler) parseData(kind string, values []string) (data []byte, err error) {
+	if len(values) == 0 {
+		err = errors.New(".data needs at least one value")
+		return
+	}
+	for _, v := range values {
+		switch kind {
+		case "hex":
+			var b []byte
+			b, err = hex.DecodeString(v)
+			if err != nil {
+				err = fmt.Errorf("invalid hex %q", v)
+				return
+			}
+			data = append(data, b...)
+
+		case "string":
+			var str string
+			str, err = strconv.Unquote(v)
+			if err != nil {
+				err = fmt.Errorf("invalid string %v", v)
+				return
+			}
+			data = append(data, str...)
+
+		case "int8", "int16", "int32", "int64":
+			bits, _ := strconv.Atoi(kind[3:])
+			var n int64
+			n, err = a.integer(v, uint(bits))
+			if err != nil {
+				return
+			}
+			for i := 0; i < bits/8; i++ {
+				data = append(data, byte(n>>uint(8*i)))
+			}
+
+		case "pubkey":
+			var b []byte
+			b, err = hex.DecodeString(v)
+			if err != nil || len(b) != siacrypto.PublicKeySize {
+				err = fmt.Errorf("invalid public key %q", v)
+				return
+			}
+			data = append(data, b...)
+
+		case "balance":
+			i, ok := new(big.Int).SetString(v, 10)
+			if !ok || i.Sign() < 0 || i.BitLen() > 128 {
+				err = fmt.Errorf("invalid balance %q", v)
+				return
+			}
+			b := state.NewStringBalance(v)
+			data = append(data, b[:]...)
+
+		default:
+			err = fmt.Errorf("unknown kind of data %q", kind)
 			return
 		}
-		b = append(b, opcode)
+	}
+	return
+}
 
-		// parse argument(s)
-		numArgs := opTable[opcode].argBytes
-		if shortArg[opcode] {
-			numArgs = 1
+// directive handles a line starting with a '.'.
+func (a *assembler) directive(line int, fields []string) (err error) {
+	switch fields[0] {
+	case ".base":
+		if len(fields) != 2 {
+			return errors.New(".base takes an address")
+		}
+		if a.size != 0 || len(a.labels) != 0 {
+			return errors.New(".base must come before any code, data or labels")
+		}
+		var n int64
+		n, err = a.number(fields[1], 0, 0xFFFF)
+		a.base = int(n)
+
+	case ".const", ".reg":
+		if len(fields) != 3 {
+			return fmt.Errorf("%v takes a name and a value", fields[0])
 		}
-		if i+numArgs > len(tokens) {
-			err = errors.New(fmt.Sprint("not enough arguments to ", tokens[i]))
+		min, max := int64(-1<<63), int64(1<<63-1)
+		if fields[0] == ".reg" {
+			min, max = 0, 255
+		}
+		var n int64
+		n, err = a.number(fields[2], min, max)
+		if err != nil {
 			return
 		}
-		for j := 1; j <= numArgs; j++ {
-			arg, convErr := strconv.Atoi(tokens[i+j])
-			if convErr != nil {
-				err = fmt.Errorf("invalid argument \"%s\" to opcode %s", tokens[i+j], tokens[i])
-				return
+		err = a.define(fields[1])
+		if err != nil {
+			return
+		}
+		a.constants[fields[1]] = n
+
+	case ".data":
+		if len(fields) < 2 {
+			return errors.New(".data takes a kind and values")
+		}
+		var data []byte
+		data, err = a.parseData(fields[1], fields[2:])
+		if err != nil {
+			return
+		}
+		a.statements = append(a.statements, asmStatement{
+			line:   line,
+			offset: a.base + a.size,
+			data:   data,
+			isData: true,
+		})
+		a.size += len(data)
+
+	default:
+		err = fmt.Errorf("unknown directive %v", fields[0])
+	}
+	return
+}
+
+// encode turns an instruction into bytes, now that every label is known.
+func (a *assembler) encode(st asmStatement) (b []byte, err error) {
+	b = []byte{st.opcode}
+	op := opTable[st.opcode]
+	if shortArg[st.opcode] {
+		var arg int64
+		target, isLabel := a.labels[st.args[0]]
+		if !isLabel {
+			arg, err = a.number(st.args[0], -1<<15, 1<<16-1)
+		} else if l, ok := labelArg(st.opcode, target, st.offset); !ok {
+			err = fmt.Errorf("a label can't be used with %v", op.name)
+		} else if arg = int64(l); arg < -1<<15 || arg >= 1<<16 {
+			err = fmt.Errorf("label %v is out of reach of %v", st.args[0], op.name)
+		}
+		if err != nil {
+			return
+		}
+		return append(b, byte(arg), byte(arg>>8)), nil
+	}
+	for _, token := range st.args {
+		var arg int64
+		arg, err = a.number(token, 0, 255)
+		if err != nil {
+			return
+		}
+		b = append(b, byte(arg))
+	}
+	return
+}
+
+// WordsToBytes assembles a program into a script. See the top of this file
+// for the syntax.
+func WordsToBytes(script string) (b []byte, err error) {
+	a := assembler{
+		labels:    make(map[string]int),
+		constants: make(map[string]int64),
+	}
+
+	// Read the statements and find the address of every label.
+	for i, line := range strings.Split(script, "\n") {
+		lineErr := func(e error) error {
+			return fmt.Errorf("line %v: %v", i+1, e)
+		}
+		fields, tokErr := tokenize(line)
+		if tokErr != nil {
+			return nil, lineErr(tokErr)
+		}
+		for len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+			name := strings.TrimSuffix(fields[0], ":")
+			if defErr := a.define(name); defErr != nil {
+				return nil, lineErr(defErr)
 			}
-			// convert single number to two bytes
-			if shortArg[opcode] {
-				if arg > 0xFFFF {
-					err = errors.New("argument overflows short")
-					return
-				}
-				b = append(b, byte(arg), byte(arg>>8))
-			} else {
-				if arg > 0xFF {
-					err = errors.New("argument overflows byte")
-					return
-				}
-				b = append(b, byte(arg))
+			a.labels[name] = a.base + a.size
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], ".") {
+			if dirErr := a.directive(i+1, fields); dirErr != nil {
+				return nil, lineErr(dirErr)
 			}
+			continue
 		}
 
-		i += numArgs
+		opcode, ok := opcodeMap[fields[0]]
+		if !ok {
+			return nil, lineErr(fmt.Errorf("expected opcode, got %v", fields[0]))
+		}
+		numArgs := opTable[opcode].argBytes
+		if shortArg[opcode] {
+			numArgs = 1
+		}
+		if len(fields)-1 != numArgs {
+			return nil, lineErr(fmt.Errorf("%v takes %v arguments, got %v", fields[0], numArgs, len(fields)-1))
+		}
+		a.statements = append(a.statements, asmStatement{
+			line:   i + 1,
+			offset: a.base + a.size,
+			opcode: opcode,
+			args:   fields[1:],
+		})
+		a.size += 1 + opTable[opcode].argBytes
 	}
 
+	// Encode the statements.
+	for _, st := range a.statements {
+		if st.isData {
+			b = append(b, st.data...)
+			continue
+		}
+		encoded, encErr := a.encode(st)
+		if encErr != nil {
+			return nil, fmt.Errorf("line %v: %v", st.line, encErr)
+		}
+		b = append(b, encoded...)
+	}
 	return
 }
@@ -1,28 +1,142 @@
 package delta
 
-/* import (
+import (
 	"bytes"
-	"siacrypto"
+	"strings"
 	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/state"
 )
 
 // TestTranslator ensures that a script is unchanged after being translated back and forth
 func TestTranslator(t *testing.T) {
-	pk := new(siacrypto.PublicKey)
+	var pk siacrypto.PublicKey
 	copy(pk[:], siacrypto.RandomByteSlice(32))
-	s := DefaultScript(pk)
+	scripts := [][]byte{
+		DefaultScript(pk),
+		MultisigScript(2, []siacrypto.PublicKey{pk, pk, pk}),
+		FountainScript,
+		SendCoinInput(7, state.NewBalance(100)),
+		{0x21, 0x01, 0x00},                               // goto itself
+		{0x22, 0x01, 0x00, 0x32, 0x07, 0x00, 0xFF, 0xAB}, // move, data_goto into data
+		nil,
+	}
+	for i := 0; i < 20; i++ {
+		scripts = append(scripts, siacrypto.RandomByteSlice(64))
+	}
+
+	for _, s := range scripts {
+		w, err := BytesToWords(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := WordsToBytes(w)
+		if err != nil {
+			t.Fatal(err, "\n", w)
+		}
+		if bytes.Compare(s, b) != 0 {
+			t.Fatal("scripts do not match after translate/untranslate:\n", w)
+		}
+	}
+}
+
+// TestAssembler assembles an input that uses labels, named registers,
+// constants and data, and checks what it does when it is run.
+func TestAssembler(t *testing.T) {
+	program := `
+		.base 1          ; the wallet script is a single transfer
+		.reg count 1
+		.const times 3
 
-	w, err := BytesToWords(s)
+		push_byte times
+		store count
+	loop:	data_goto payment
+		data_push 8      ; push destination
+		data_push 16     ; push amount
+		send
+		push_byte 1
+		load count
+		sub_int
+		dup
+		store count
+		if_goto loop
+		exit
+
+	payment:
+		.data int64 2
+		.data balance 5
+		.data string "pay \"2\""
+	`
+	input, err := WordsToBytes(program)
 	if err != nil {
 		t.Fatal(err)
 	}
+	balance := state.NewBalance(5)
+	expected := appendAll(
+		[]byte{
+			0x01, 0x03, //       push_byte times
+			0x30, 0x01, //       store count
+			0x32, 0x19, 0x00, // data_goto payment
+			0x34, 0x08, //       data_push 8
+			0x34, 0x10, //       data_push 16
+			0x43,       //       send
+			0x01, 0x01, //       push_byte 1
+			0x31, 0x01, //       load count
+			0x08,       //       sub_int
+			0x04,       //       dup
+			0x30, 0x01, //       store count
+			0x1F, 0x06, 0x00, // if_goto loop
+			0xFF, //             exit
+		},
+		[]byte{2, 0, 0, 0, 0, 0, 0, 0},
+		balance[:],
+		[]byte(`pay "2"`),
+	)
+	if bytes.Compare(input, expected) != 0 {
+		t.Fatalf("unexpected assembly:\n%v\n%v", encodeHex(input), encodeHex(expected))
+	}
 
-	b, err := WordsToBytes(w)
+	e, si := initEnv()
+	e.state.InsertWallet(state.Wallet{ID: 2}, true)
+	si.Input = input
+	if err := e.Execute(si); err != nil {
+		t.Fatal(err)
+	}
+	w, err := e.state.LoadWallet(2)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if w.Balance != state.NewBalance(15) {
+		t.Error("expecting the loop to pay 15, got", w.Balance)
+	}
+}
 
-	if bytes.Compare(s, b) != 0 {
-		t.Fatal("scripts do not match after translate/untranslate")
+// TestAssemblerErrors checks that bad programs are reported with the line
+// that is wrong.
+func TestAssemblerErrors(t *testing.T) {
+	programs := []string{
+		"exit\nnot_an_opcode",
+		"exit\npush_byte 256",
+		"exit\npush_byte",
+		"exit\ngoto nowhere",
+		"exit\ndata_move here\nhere:",
+		"a: exit\na: exit",
+		"exit\n.const exit 1",
+		"exit\n.base 4",
+		"exit\n.data pubkey 00",
+		"exit\n.data balance -1",
+		"exit\n.data int8 300",
+		"exit\n.data string \"unterminated",
+		"exit\n.data float 1",
+		"exit\n.reg r 256",
+	}
+	for _, program := range programs {
+		_, err := WordsToBytes(program)
+		if err == nil {
+			t.Error("no error for program", program)
+		} else if !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Error("expecting an error on line 2, got", err)
+		}
 	}
-}*/
+}
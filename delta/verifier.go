@@ -0,0 +1,323 @@
+package delta
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The verifier looks for the ways that a script can fail no matter what input
+// it is given. It follows every path through the script from the first byte,
+// and checks that each instruction on a path is a valid opcode with all of
+// its arguments inside the script, that every jump lands on an instruction,
+// and that no instruction pops from an empty stack, as far as the depth of
+// the stack can be known without running the script.
+//
+// A path ends at exit, reject or transfer, or when it jumps or runs past the
+// end of the script, since the input is appended to the script and run from
+// there. The bytes that no path reaches are data, and are never checked.
+// Jumps to targets that depend on the state of the script, such as transfer
+// or data pointer moves, are not followed.
+//
+// If no path loops, the verifier also finds the most that running the script
+// can cost. This does not include the input, the extra cost of the keys
+// checked by check_multisig, or the scripts run by call.
+
+// stackEffect is the number of values that an opcode pops and pushes.
+type stackEffect struct {
+	pops, pushes int
+}
+
+// stackEffects holds the stack effect of every opcode in opTable. switch only
+// pushes its value back if it doesn't jump, which the verifier handles
+// itself.
+var stackEffects = map[byte]stackEffect{
+	0x00: {0, 0}, // no_op
+	0x01: {0, 1}, // push_byte
+	0x02: {0, 1}, // push_short
+	0x03: {1, 0}, // pop
+	0x04: {1, 2}, // dup
+	0x05: {2, 2}, // swap
+	0x06: {2, 1}, // add_int
+	0x07: {2, 1}, // add_float
+	0x08: {2, 1}, // sub_int
+	0x09: {2, 1}, // sub_float
+	0x0A: {2, 1}, // mul_int
+	0x0B: {2, 1}, // mul_float
+	0x0C: {2, 1}, // div_int
+	0x0D: {2, 1}, // div_float
+	0x0E: {2, 1}, // mod_int
+	0x0F: {1, 1}, // neg_int
+	0x10: {1, 1}, // neg_float
+	0x11: {2, 1}, // binary_or
+	0x12: {2, 1}, // binary_and
+	0x13: {2, 1}, // binary_xor
+	0x14: {1, 1}, // shift_left
+	0x15: {1, 1}, // shift_right
+	0x16: {2, 1}, // equal
+	0x17: {2, 1}, // not_equal
+	0x18: {2, 1}, // less_int
+	0x19: {2, 1}, // less_float
+	0x1A: {2, 1}, // greater_int
+	0x1B: {2, 1}, // greater_float
+	0x1C: {1, 1}, // logical_not
+	0x1D: {2, 1}, // logical_or
+	0x1E: {2, 1}, // logical_and
+	0x1F: {1, 0}, // if_goto
+	0x20: {1, 0}, // if_move
+	0x21: {0, 0}, // goto
+	0x22: {0, 0}, // move
+	0x23: {2, 1}, // concat
+	0x30: {1, 0}, // store
+	0x31: {0, 1}, // load
+	0x32: {0, 0}, // data_goto
+	0x33: {0, 0}, // data_move
+	0x34: {0, 1}, // data_push
+	0x35: {0, 0}, // data_store
+	0x36: {1, 0}, // data_copy
+	0x37: {1, 0}, // data_paste
+	0x38: {0, 0}, // transfer
+	0x40: {3, 1}, // verify
+	0x41: {1, 0}, // add_sibling
+	0x42: {3, 0}, // add_wallet
+	0x43: {2, 0}, // send
+	0x44: {6, 0}, // update_sector
+	0x45: {1, 0}, // leave_sibling
+	0x46: {0, 1}, // deadline
+	0x47: {1, 0}, // update_address
+	0x48: {1, 1}, // hash
+	0x49: {4, 1}, // check_multisig
+	0x4A: {3, 1}, // call
+	0x4B: {0, 1}, // caller
+	0x4C: {1, 0}, // schedule
+	0x4D: {0, 1}, // fee
+	0x4E: {3, 0}, // add_verified_wallet
+	0x50: {0, 1}, // height
+	0x51: {0, 1}, // balance
+	0x52: {0, 1}, // sector_atoms
+	0x53: {0, 1}, // sector_hashset
+	0x54: {0, 1}, // storage_price
+	0x55: {0, 1}, // script_price
+	0x56: {1, 1}, // sibling_status
+	0x57: {2, 1}, // less_balance
+	0x58: {2, 1}, // greater_balance
+	0xE0: {1, 1}, // switch
+	0xE1: {0, 0}, // store_prefix
+	0xE2: {0, 0}, // store_rest
+	0xE3: {0, 1}, // push_prefix
+	0xE4: {0, 1}, // push_rest
+	0xE5: {1, 0}, // cond_reject
+	0xE6: {0, 0}, // data_seek
+	0xFE: {0, 0}, // reject
+	0xFF: {0, 0}, // exit
+}
+
+// unknownDepth marks an instruction that can be reached with different
+// stack depths.
+const unknownDepth = -1
+
+// A ScriptProblem is a reason that a script will fail, found at the
+// instruction at Offset.
+type ScriptProblem struct {
+	Offset  int
+	Problem string
+}
+
+func (sp ScriptProblem) Error() string {
+	return fmt.Sprintf("offset %v: %v", sp.Offset, sp.Problem)
+}
+
+// A ScriptReport is the result of checking a script with LintScript. If the
+// script can't loop, Bounded is true and MaxCost is the most that running
+// the script can cost, see the top of this file.
+type ScriptReport struct {
+	Problems []ScriptProblem
+	Bounded  bool
+	MaxCost  int
+}
+
+// a scriptVerifier holds what is known about a script while it is checked.
+type scriptVerifier struct {
+	script   []byte
+	depths   map[int]int
+	problems map[int]string
+}
+
+// problem records a problem with the instruction at 'offset', keeping the
+// first problem found for each instruction.
+func (v *scriptVerifier) problem(offset int, format string, a ...interface{}) {
+	if _, exists := v.problems[offset]; !exists {
+		v.problems[offset] = fmt.Sprintf(format, a...)
+	}
+}
+
+// successors returns the offsets that can be run after the instruction at
+// 'offset', and the stack depth change along each of them. A successor past
+// the end of the script leaves the script for the input.
+func (v *scriptVerifier) successors(offset int) (next []int, changes []int) {
+	opcode := v.script[offset]
+	op := opTable[opcode]
+	effect := stackEffects[opcode]
+	change := effect.pushes - effect.pops
+	after := offset + 1 + op.argBytes
+
+	var arg int
+	if op.argBytes == 2 {
+		arg = s2i(v.script[offset+1], v.script[offset+2])
+	}
+	switch opcode {
+	case 0xFE, 0xFF, 0x38: // reject, exit, transfer
+		return
+	case 0x21: // goto
+		return []int{arg - 1}, []int{change}
+	case 0x22: // move
+		return []int{offset + 2 + arg}, []int{change}
+	case 0x1F: // if_goto
+		return []int{after, arg - 1}, []int{change, change}
+	case 0x20: // if_move
+		return []int{after, offset + 2 + arg}, []int{change, change}
+	case 0xE0: // switch
+		return []int{after, s2i(0, v.script[offset+2]) - 1}, []int{0, -1}
+	}
+	return []int{after}, []int{change}
+}
+
+// walk finds every instruction that can be reached, and the depth of the
+// stack at each of them.
+func (v *scriptVerifier) walk() {
+	if len(v.script) == 0 {
+		return
+	}
+	v.depths[0] = 0
+	queue := []int{0}
+	for len(queue) > 0 {
+		offset := queue[0]
+		queue = queue[1:]
+
+		op, ok := opTable[v.script[offset]]
+		if !ok {
+			v.problem(offset, "invalid opcode %v", v.script[offset])
+			continue
+		}
+		if offset+op.argBytes >= len(v.script) {
+			v.problem(offset, "%v is missing arguments", op.name)
+			continue
+		}
+
+		depth := v.depths[offset]
+		if depth != unknownDepth && stackEffects[v.script[offset]].pops > depth {
+			v.problem(offset, "%v pops from an empty stack", op.name)
+			depth = unknownDepth
+		}
+
+		next, changes := v.successors(offset)
+		for i, target := range next {
+			if target < 0 {
+				v.problem(offset, "%v jumps before the start of the script", op.name)
+				continue
+			}
+			if target >= len(v.script) {
+				continue
+			}
+			newDepth := unknownDepth
+			if depth != unknownDepth {
+				newDepth = depth + changes[i]
+			}
+			oldDepth, seen := v.depths[target]
+			if !seen {
+				v.depths[target] = newDepth
+				queue = append(queue, target)
+			} else if oldDepth != newDepth && oldDepth != unknownDepth {
+				v.depths[target] = unknownDepth
+				queue = append(queue, target)
+			}
+		}
+	}
+}
+
+// checkOverlaps reports instructions that start inside the arguments of
+// another instruction, which happens when a jump doesn't land on an
+// instruction boundary.
+func (v *scriptVerifier) checkOverlaps() {
+	for offset := range v.depths {
+		op, ok := opTable[v.script[offset]]
+		if !ok {
+			continue
+		}
+		for i := offset + 1; i <= offset+op.argBytes; i++ {
+			if _, reached := v.depths[i]; reached {
+				v.problem(i, "a jump lands inside the arguments of %v at %v", op.name, offset)
+			}
+		}
+	}
+}
+
+// maxCost returns the most that the instructions starting at 'offset' can
+// cost, or false if they can loop. 'costs' holds the results that are known,
+// and 'visiting' the offsets on the current path.
+func (v *scriptVerifier) maxCost(offset int, costs map[int]int, visiting map[int]bool) (cost int, bounded bool) {
+	if offset >= len(v.script) {
+		return 0, true
+	}
+	if cost, known := costs[offset]; known {
+		return cost, true
+	}
+	if visiting[offset] {
+		return 0, false
+	}
+	visiting[offset] = true
+	defer delete(visiting, offset)
+
+	op := opTable[v.script[offset]]
+	next, _ := v.successors(offset)
+	var most int
+	for _, target := range next {
+		c, ok := v.maxCost(target, costs, visiting)
+		if !ok {
+			return 0, false
+		}
+		if c > most {
+			most = c
+		}
+	}
+	cost = op.cost + most
+	costs[offset] = cost
+	return cost, true
+}
+
+// LintScript checks a script for the ways that it can fail no matter what
+// input it is given, see the top of this file. It can be used by clients to
+// check a script before putting it into a wallet.
+func LintScript(script []byte) (r ScriptReport) {
+	v := scriptVerifier{
+		script:   script,
+		depths:   make(map[int]int),
+		problems: make(map[int]string),
+	}
+	v.walk()
+	v.checkOverlaps()
+
+	var offsets []int
+	for offset := range v.problems {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	for _, offset := range offsets {
+		r.Problems = append(r.Problems, ScriptProblem{offset, v.problems[offset]})
+	}
+
+	// the cost can only be followed through a script with no problems
+	if len(r.Problems) == 0 {
+		r.MaxCost, r.Bounded = v.maxCost(0, make(map[int]int), make(map[int]bool))
+	}
+	return
+}
+
+// VerifyScript returns the first problem found by LintScript, or nil if the
+// script has no problems.
+func VerifyScript(script []byte) error {
+	r := LintScript(script)
+	if len(r.Problems) != 0 {
+		return r.Problems[0]
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package delta
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/state"
+)
+
+// TestLintScript checks the problems found by LintScript in a set of good and
+// bad scripts, and the worst-case cost of the good ones.
+func TestLintScript(t *testing.T) {
+	// every opcode needs a stack effect
+	for opcode, op := range opTable {
+		if _, exists := stackEffects[opcode]; !exists {
+			t.Error("no stack effect for", op.name)
+		}
+	}
+	for opcode := range stackEffects {
+		if _, exists := opTable[opcode]; !exists {
+			t.Error("stack effect for unknown opcode", opcode)
+		}
+	}
+
+	var pk siacrypto.PublicKey
+	good := [][]byte{
+		nil,
+		{0x38}, // transfer
+		DefaultScript(pk),
+		MultisigScript(2, []siacrypto.PublicKey{pk, pk, pk}),
+		FountainScript,
+		{0xFF, 0x3F, 0x01}, // invalid bytes after exit are data
+		{0x21, 0x09, 0x00}, // goto into the input
+		{
+			0x01, 0x01, //       push 1
+			0x1F, 0x08, 0x00, // if_goto 7
+			0x01, 0x02, //       push 2
+			0x03, //             pop, with 0 or 1 values on the stack
+			0x03, //             pop
+		},
+	}
+	for _, s := range good {
+		r := LintScript(s)
+		if len(r.Problems) != 0 {
+			t.Error("unexpected problems in", encodeHex(s), r.Problems)
+		}
+		if !r.Bounded {
+			t.Error("expecting a bounded cost for", encodeHex(s))
+		}
+	}
+
+	bad := []struct {
+		script []byte
+		offset int
+	}{
+		{[]byte{0x00, 0x3F}, 1},                         // invalid opcode
+		{[]byte{0x00, 0x01}, 1},                         // missing argument
+		{[]byte{0x01, 0x01, 0x04, 0x06, 0x06}, 4},       // add_int on a single value
+		{[]byte{0x21, 0x00, 0x00}, 0},                   // goto -1
+		{[]byte{0x21, 0x03, 0x00, 0x01, 0x05}, 2},       // goto the middle of itself
+		{[]byte{0x01, 0x02, 0xE0, 0x02, 0x00, 0x03}, 2}, // switch to -1
+	}
+	for _, b := range bad {
+		r := LintScript(b.script)
+		if len(r.Problems) == 0 {
+			t.Error("no problems found in", encodeHex(b.script))
+		} else if r.Problems[0].Offset != b.offset {
+			t.Errorf("expecting a problem at %v in %v, got %v", b.offset, encodeHex(b.script), r.Problems)
+		}
+		if VerifyScript(b.script) == nil {
+			t.Error("VerifyScript accepted", encodeHex(b.script))
+		}
+	}
+
+	// the cost of the longer branch is counted
+	r := LintScript([]byte{
+		0x01, 0x01, //       push 1
+		0x1F, 0x08, 0x00, // if_goto 7
+		0x00,       //             no_op
+		0x00,       //             no_op
+		0x01, 0x02, //       push 2
+		0x03, //             pop
+		0xFF, //             exit
+	})
+	expected := 2*opTable[0x01].cost + opTable[0x1F].cost + 2*opTable[0x00].cost + opTable[0x03].cost
+	if !r.Bounded || r.MaxCost != expected {
+		t.Errorf("expecting a cost of %v, got %v", expected, r.MaxCost)
+	}
+
+	// loops have no bound
+	r = LintScript([]byte{0x21, 0x01, 0x00})
+	if len(r.Problems) != 0 || r.Bounded {
+		t.Error("expecting a loop with no problems and no bound, got", r)
+	}
+}
+
+// TestAddVerifiedWallet checks that add_verified_wallet rejects scripts that
+// fail verification, while add_wallet accepts them.
+func TestAddVerifiedWallet(t *testing.T) {
+	e, si := initEnv()
+
+	// addWallet creates wallet 'id' with the 1 byte script 'script', which
+	// can't be 0xFF, since data_seek would find it instead of the id
+	addWallet := func(opcode byte, id uint64, script byte) error {
+		si.Input = appendAll(
+			[]byte{
+				0xE6, 0xFF, //  move data pointer to id
+				0x34, 0x08, //  push id
+				0x01, 0x05, //  push balance
+				0x01, script, // push script
+				opcode, //      call CreateWallet
+				0xFF,   //      exit
+			},
+			siaencoding.EncUint64(id),
+		)
+		return e.Execute(si)
+	}
+
+	if addWallet(0x4E, 2, 0x03) == nil {
+		t.Error("add_verified_wallet accepted a script that pops from an empty stack")
+	}
+	if _, err := e.state.LoadWallet(2); err == nil {
+		t.Error("wallet with a bad script exists")
+	}
+	if err := addWallet(0x4E, 3, 0x00); err != nil {
+		t.Fatal(err)
+	}
+	if err := addWallet(0x42, 4, 0x03); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []state.WalletID{3, 4} {
+		if _, err := e.state.LoadWallet(id); err != nil {
+			t.Error(err)
+		}
+	}
+}
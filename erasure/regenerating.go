@@ -0,0 +1,284 @@
+package erasure
+
+// Reed-Solomon coding is cheap to store but expensive to repair: rebuilding a
+// single lost piece requires downloading 'k' full pieces and decoding the
+// whole file. A regenerating code trades a more complicated layout for much
+// cheaper repairs, where a lost piece is rebuilt from 'd' helpers that each
+// send only part of their piece.
+//
+// regenerating.go implements a small minimum-storage regenerating code with 4
+// pieces, where any 2 pieces can recover the original data (k = 2) and a lost
+// piece can be rebuilt from the 3 remaining pieces (d = 3), each sending half
+// of a piece. Rebuilding a piece therefore costs 1.5 pieces of bandwidth
+// instead of the 2 pieces used by Reed-Solomon coding.
+//
+// The original data is split into stripes of 4 symbols: a1, a2, b1 and b2.
+// Each piece stores two symbols per stripe, and every stored symbol is an XOR
+// of data symbols:
+//
+//	piece 0: a1,         a2
+//	piece 1: b1,         b2
+//	piece 2: a1^b1,      a2^b2
+//	piece 3: a2^b1,      a1^a2^b2
+//
+// The code only uses XOR, so it works for symbols of any size.
+
+import (
+	"errors"
+)
+
+const (
+	// RegeneratingN is the number of pieces produced by the regenerating
+	// code.
+	RegeneratingN = 4
+
+	// RegeneratingK is the number of pieces needed to recover the data.
+	RegeneratingK = 2
+
+	// RegeneratingD is the number of helpers needed to rebuild a piece.
+	RegeneratingD = 3
+
+	// regeneratingSubsymbols is the number of symbols that each piece
+	// stores per stripe.
+	regeneratingSubsymbols = 2
+
+	// regeneratingStripeSymbols is the number of data symbols in a stripe.
+	regeneratingStripeSymbols = 4
+)
+
+var (
+	errBadSymbolSize  = errors.New("symbol size must be greater than zero")
+	errBadPieceIndex  = errors.New("piece index is out of range")
+	errBadPieceLength = errors.New("pieces have a bad or mismatched length")
+	errDataLength     = errors.New("data length must be a multiple of four symbols")
+	errUnsolvable     = errors.New("the given pieces are not enough to solve for the target")
+)
+
+// regeneratingLayout gives the data symbols that make up each stored symbol,
+// as a bitmask over a1, a2, b1 and b2.
+var regeneratingLayout = [RegeneratingN][regeneratingSubsymbols]byte{
+	{0x1, 0x2},
+	{0x4, 0x8},
+	{0x5, 0xa},
+	{0x6, 0xb},
+}
+
+// regeneratingRepairPlan[lost][helper] gives the stored symbols that 'helper'
+// XORs together and sends when piece 'lost' is being rebuilt, as a bitmask
+// over the helper's two symbols.
+var regeneratingRepairPlan = [RegeneratingN][RegeneratingN]byte{
+	{0, 0x2, 0x2, 0x2},
+	{0x2, 0, 0x2, 0x1},
+	{0x2, 0x2, 0, 0x3},
+	{0x2, 0x1, 0x3, 0},
+}
+
+// xorInto XORs 'src' into 'dst'.
+func xorInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// solve finds a set of the available symbols that XOR to the target symbol,
+// where each symbol is a bitmask over the data symbols. With at most four
+// available symbols, every subset can simply be tried.
+func solve(available []byte, target byte) (subset []int, err error) {
+	for choice := 1; choice < 1<<uint(len(available)); choice++ {
+		var mask byte
+		for i := range available {
+			if choice&(1<<uint(i)) != 0 {
+				mask ^= available[i]
+			}
+		}
+		if mask == target {
+			for i := range available {
+				if choice&(1<<uint(i)) != 0 {
+					subset = append(subset, i)
+				}
+			}
+			return
+		}
+	}
+	err = errUnsolvable
+	return
+}
+
+// combine solves each target mask from the symbols in 'sources', one stripe
+// at a time. sources[i] holds one symbol per stripe, each with the data mask
+// sourceMasks[i], and the output holds one symbol per target mask for every
+// stripe.
+func combine(sources [][]byte, sourceMasks []byte, symbolSize int, targets []byte) (output []byte, err error) {
+	plans := make([][]int, len(targets))
+	for i, target := range targets {
+		plans[i], err = solve(sourceMasks, target)
+		if err != nil {
+			return
+		}
+	}
+
+	stripes := len(sources[0]) / symbolSize
+	output = make([]byte, stripes*len(targets)*symbolSize)
+	for s := 0; s < stripes; s++ {
+		for i, plan := range plans {
+			out := output[(s*len(targets)+i)*symbolSize : (s*len(targets)+i+1)*symbolSize]
+			for _, j := range plan {
+				xorInto(out, sources[j][s*symbolSize:(s+1)*symbolSize])
+			}
+		}
+	}
+	return
+}
+
+// splitSymbols splits a piece into one slice per stored symbol, where slice i
+// holds symbol i of every stripe back to back.
+func splitSymbols(piece []byte, symbolSize int) (symbols [regeneratingSubsymbols][]byte) {
+	stripes := len(piece) / (regeneratingSubsymbols * symbolSize)
+	for i := range symbols {
+		symbols[i] = make([]byte, 0, stripes*symbolSize)
+	}
+	for s := 0; s < stripes; s++ {
+		for i := range symbols {
+			offset := (s*regeneratingSubsymbols + i) * symbolSize
+			symbols[i] = append(symbols[i], piece[offset:offset+symbolSize]...)
+		}
+	}
+	return
+}
+
+// checkPieces checks that the pieces and indices are usable together.
+func checkPieces(pieces [][]byte, indices []byte, symbolSize int) (err error) {
+	if symbolSize <= 0 {
+		return errBadSymbolSize
+	}
+	if len(pieces) == 0 || len(pieces) != len(indices) {
+		return errBadPieceLength
+	}
+	for i := range pieces {
+		if indices[i] >= RegeneratingN {
+			return errBadPieceIndex
+		}
+		if len(pieces[i]) == 0 || len(pieces[i]) != len(pieces[0]) || len(pieces[i])%symbolSize != 0 {
+			return errBadPieceLength
+		}
+	}
+	return
+}
+
+// RegeneratingEncode encodes 'data' into RegeneratingN pieces. The length of
+// 'data' must be a multiple of four symbols, and each piece is half as long
+// as 'data'.
+func RegeneratingEncode(data []byte, symbolSize int) (pieces [][]byte, err error) {
+	if symbolSize <= 0 {
+		err = errBadSymbolSize
+		return
+	}
+	if len(data) == 0 || len(data)%(regeneratingStripeSymbols*symbolSize) != 0 {
+		err = errDataLength
+		return
+	}
+
+	// Split the data into one source per data symbol.
+	stripes := len(data) / (regeneratingStripeSymbols * symbolSize)
+	sources := make([][]byte, regeneratingStripeSymbols)
+	sourceMasks := make([]byte, regeneratingStripeSymbols)
+	for i := range sources {
+		sourceMasks[i] = 1 << uint(i)
+		for s := 0; s < stripes; s++ {
+			offset := (s*regeneratingStripeSymbols + i) * symbolSize
+			sources[i] = append(sources[i], data[offset:offset+symbolSize]...)
+		}
+	}
+
+	pieces = make([][]byte, RegeneratingN)
+	for i := range pieces {
+		pieces[i], err = combine(sources, sourceMasks, symbolSize, regeneratingLayout[i][:])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RegeneratingRecover recovers the original data from RegeneratingK pieces.
+// indices[i] is the index of pieces[i].
+func RegeneratingRecover(pieces [][]byte, indices []byte, symbolSize int) (data []byte, err error) {
+	err = checkPieces(pieces, indices, symbolSize)
+	if err != nil {
+		return
+	}
+	if len(pieces) < RegeneratingK || len(pieces[0])%(regeneratingSubsymbols*symbolSize) != 0 {
+		err = errBadPieceLength
+		return
+	}
+
+	var sources [][]byte
+	var sourceMasks []byte
+	for i, piece := range pieces {
+		symbols := splitSymbols(piece, symbolSize)
+		for j := range symbols {
+			sources = append(sources, symbols[j])
+			sourceMasks = append(sourceMasks, regeneratingLayout[indices[i]][j])
+		}
+	}
+	data, err = combine(sources, sourceMasks, symbolSize, []byte{0x1, 0x2, 0x4, 0x8})
+	return
+}
+
+// RegeneratingHelperData returns the data that the holder of piece 'helper'
+// sends to rebuild piece 'lost'. The helper data is half as long as the
+// piece.
+func RegeneratingHelperData(piece []byte, helper byte, lost byte, symbolSize int) (helperData []byte, err error) {
+	err = checkPieces([][]byte{piece}, []byte{helper}, symbolSize)
+	if err != nil {
+		return
+	}
+	if lost >= RegeneratingN || lost == helper {
+		err = errBadPieceIndex
+		return
+	}
+	if len(piece)%(regeneratingSubsymbols*symbolSize) != 0 {
+		err = errBadPieceLength
+		return
+	}
+
+	symbols := splitSymbols(piece, symbolSize)
+	plan := regeneratingRepairPlan[lost][helper]
+	helperData = make([]byte, len(symbols[0]))
+	for i := range symbols {
+		if plan&(1<<uint(i)) != 0 {
+			xorInto(helperData, symbols[i])
+		}
+	}
+	return
+}
+
+// RegeneratingRepair rebuilds piece 'lost' from the helper data sent by
+// RegeneratingD helpers. helpers[i] is the index of the piece that produced
+// helperData[i].
+func RegeneratingRepair(helperData [][]byte, helpers []byte, lost byte, symbolSize int) (piece []byte, err error) {
+	err = checkPieces(helperData, helpers, symbolSize)
+	if err != nil {
+		return
+	}
+	if len(helperData) < RegeneratingD || lost >= RegeneratingN {
+		err = errBadPieceIndex
+		return
+	}
+
+	sourceMasks := make([]byte, len(helpers))
+	for i, helper := range helpers {
+		if helper == lost {
+			err = errBadPieceIndex
+			return
+		}
+		plan := regeneratingRepairPlan[lost][helper]
+		for j := 0; j < regeneratingSubsymbols; j++ {
+			if plan&(1<<uint(j)) != 0 {
+				sourceMasks[i] ^= regeneratingLayout[helper][j]
+			}
+		}
+	}
+	piece, err = combine(helperData, sourceMasks, symbolSize, regeneratingLayout[lost][:])
+	return
+}
@@ -0,0 +1,93 @@
+package erasure
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+)
+
+// TestRegeneratingRecover encodes random data and checks that every pair of
+// pieces recovers the original data.
+func TestRegeneratingRecover(t *testing.T) {
+	symbolSize := 16
+	data := siacrypto.RandomByteSlice(40 * regeneratingStripeSymbols * symbolSize)
+	pieces, err := RegeneratingEncode(data, symbolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range pieces {
+		if len(pieces[i]) != len(data)/RegeneratingK {
+			t.Fatal("piece", i, "has the wrong length:", len(pieces[i]))
+		}
+	}
+
+	for i := byte(0); i < RegeneratingN; i++ {
+		for j := i + 1; j < RegeneratingN; j++ {
+			recovered, err := RegeneratingRecover([][]byte{pieces[j], pieces[i]}, []byte{j, i}, symbolSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(recovered, data) {
+				t.Error("pieces", i, "and", j, "did not recover the original data")
+			}
+		}
+	}
+
+	// A single piece is not enough.
+	_, err = RegeneratingRecover([][]byte{pieces[0]}, []byte{0}, symbolSize)
+	if err == nil {
+		t.Error("recovered data from a single piece")
+	}
+}
+
+// TestRegeneratingRepair checks that every piece can be rebuilt from the
+// helper data of the other pieces, and that each helper sends half of a
+// piece.
+func TestRegeneratingRepair(t *testing.T) {
+	symbolSize := 16
+	data := siacrypto.RandomByteSlice(40 * regeneratingStripeSymbols * symbolSize)
+	pieces, err := RegeneratingEncode(data, symbolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for lost := byte(0); lost < RegeneratingN; lost++ {
+		var helperData [][]byte
+		var helpers []byte
+		for helper := byte(0); helper < RegeneratingN; helper++ {
+			if helper == lost {
+				continue
+			}
+			hd, err := RegeneratingHelperData(pieces[helper], helper, lost, symbolSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hd) != len(pieces[helper])/2 {
+				t.Fatal("helper data is not half of a piece:", len(hd))
+			}
+			helperData = append(helperData, hd)
+			helpers = append(helpers, helper)
+		}
+
+		repaired, err := RegeneratingRepair(helperData, helpers, lost, symbolSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repaired, pieces[lost]) {
+			t.Error("piece", lost, "was not repaired correctly")
+		}
+
+		// Two helpers are not enough.
+		_, err = RegeneratingRepair(helperData[:2], helpers[:2], lost, symbolSize)
+		if err == nil {
+			t.Error("repaired piece", lost, "from two helpers")
+		}
+	}
+
+	// A piece cannot help repair itself.
+	_, err = RegeneratingHelperData(pieces[0], 0, 0, symbolSize)
+	if err != errBadPieceIndex {
+		t.Error("expecting errBadPieceIndex, got", err)
+	}
+}
@@ -0,0 +1,279 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// The address book is a node's memory of the other nodes it has heard of,
+// both participants and servers, along with when each was last seen. It
+// starts out with the bootstrap nodes from the config, grows with the sibling
+// addresses found in the metadata of every quorum the node talks to, and is
+// merged with the address books of other servers. The book is saved to disk
+// every time it changes, so that a node that restarts can find the network
+// again without its bootstrap nodes.
+//
+// The times reported by other servers can't be trusted, so they are kept
+// apart from the times that the node saw an address itself, and are never
+// taken to be later than the moment they were merged. The book holds at most
+// maxAddressBookEntries entries. Once it is full, a merged address only makes
+// it into the book by pushing out the entry that was gossiped longest ago;
+// addresses that the node added or saw itself are never pushed out.
+
+// maxAddressBookEntries is the number of entries that the address book holds
+// before merged entries start pushing out older ones.
+var maxAddressBookEntries = 1 << 12
+
+// A PeerKind says what kind of node an address belongs to.
+type PeerKind byte
+
+const (
+	PeerServer PeerKind = iota
+	PeerParticipant
+)
+
+// An AddressBookEntry is a known address, the last time that the node at the
+// address was seen by this node, and the latest time that another server
+// reported seeing it. A zero LastSeen means the node has never been seen
+// locally, which is the case for bootstrap nodes that have not been reached
+// yet and for addresses that were only heard of from other servers.
+type AddressBookEntry struct {
+	Address  Address
+	Kind     PeerKind
+	LastSeen time.Time
+	Gossiped time.Time
+}
+
+// latest returns the latest time that the node at the entry's address is
+// known to have been seen, by anyone.
+func (e AddressBookEntry) latest() time.Time {
+	if e.Gossiped.After(e.LastSeen) {
+		return e.Gossiped
+	}
+	return e.LastSeen
+}
+
+// local returns true if the entry was added or seen by this node, rather
+// than only heard of from other servers.
+func (e AddressBookEntry) local() bool {
+	return e.Gossiped.IsZero() || !e.LastSeen.IsZero()
+}
+
+// An AddressBook is a durable set of known addresses. It is safe to use from
+// multiple goroutines.
+type AddressBook struct {
+	filename string
+	entries  map[Address]*AddressBookEntry
+	lock     sync.Mutex
+}
+
+// NewAddressBook returns an address book that is saved to 'filename', loading
+// the entries saved there by an earlier run. An empty filename keeps the book
+// in memory only.
+func NewAddressBook(filename string) (ab *AddressBook, err error) {
+	ab = &AddressBook{
+		filename: filename,
+		entries:  make(map[Address]*AddressBookEntry),
+	}
+	if filename == "" || !siafiles.Exists(filename) {
+		return
+	}
+
+	encodedEntries, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var entries []AddressBookEntry
+	err = siaencoding.Unmarshal(encodedEntries, &entries)
+	if err != nil {
+		return
+	}
+	for i := range entries {
+		ab.entries[entries[i].Address] = &entries[i]
+	}
+	return
+}
+
+// list returns the entries of the book, most recently seen first. list
+// requires the book lock.
+func (ab *AddressBook) list() (entries []AddressBookEntry) {
+	for _, entry := range ab.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Sort(entriesBySeen(entries))
+	return
+}
+
+// save writes the book to disk. save requires the book lock.
+func (ab *AddressBook) save() (err error) {
+	if ab.filename == "" {
+		return
+	}
+	encodedEntries, err := siaencoding.Marshal(ab.list())
+	if err != nil {
+		return
+	}
+	tempFilename := ab.filename + ".tmp"
+	err = ioutil.WriteFile(tempFilename, encodedEntries, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tempFilename, ab.filename)
+	return
+}
+
+// update records an entry, keeping the latest LastSeen and Gossiped of the
+// entry and any existing entry for the same address. Gossip about a local
+// entry is ignored, so that it stays local. A new entry that was
+// only gossiped is turned away if the book is full and it is older than
+// every entry that could make room for it. update requires the book lock.
+func (ab *AddressBook) update(entry AddressBookEntry) (changed bool) {
+	if entry.Address.Host == "" {
+		return
+	}
+	existing, exists := ab.entries[entry.Address]
+	if !exists {
+		if len(ab.entries) >= maxAddressBookEntries && !ab.evict(entry) {
+			return
+		}
+		ab.entries[entry.Address] = &entry
+		return true
+	}
+	if entry.LastSeen.After(existing.LastSeen) {
+		existing.LastSeen = entry.LastSeen
+		existing.Kind = entry.Kind
+		changed = true
+	}
+	if !existing.local() && entry.Gossiped.After(existing.Gossiped) {
+		existing.Gossiped = entry.Gossiped
+		changed = true
+	}
+	return
+}
+
+// evict makes room for 'entry' by removing the entry that was gossiped
+// longest ago, returning false if there is no such entry or it was gossiped
+// more recently than 'entry'. Entries that are local are never evicted, but
+// a local entry can always push out a gossiped one. evict requires the book
+// lock.
+func (ab *AddressBook) evict(entry AddressBookEntry) bool {
+	var oldest *AddressBookEntry
+	for _, e := range ab.entries {
+		if e.local() {
+			continue
+		}
+		if oldest == nil || e.Gossiped.Before(oldest.Gossiped) {
+			oldest = e
+		}
+	}
+	if oldest == nil {
+		return entry.local()
+	}
+	if !entry.local() && !entry.Gossiped.After(oldest.Gossiped) {
+		return false
+	}
+	delete(ab.entries, oldest.Address)
+	return true
+}
+
+// Add adds an address that has not been seen yet, such as a bootstrap node.
+// Adding an address that is already in the book does nothing.
+func (ab *AddressBook) Add(a Address, kind PeerKind) (err error) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	if ab.update(AddressBookEntry{Address: a, Kind: kind}) {
+		err = ab.save()
+	}
+	return
+}
+
+// Seen records that the node at 'a' was just seen.
+func (ab *AddressBook) Seen(a Address, kind PeerKind) (err error) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	if ab.update(AddressBookEntry{Address: a, Kind: kind, LastSeen: time.Now()}) {
+		err = ab.save()
+	}
+	return
+}
+
+// Merge adds the entries of another address book, such as one downloaded
+// from another server. The latest time that the other server knows of for
+// each address is recorded as the time the address was gossiped, but no later
+// than now.
+func (ab *AddressBook) Merge(entries []AddressBookEntry) (err error) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	now := time.Now()
+	var changed bool
+	for _, entry := range entries {
+		gossiped := entry.latest()
+		if gossiped.After(now) {
+			gossiped = now
+		}
+		if gossiped.IsZero() {
+			// Only a time marks the entry as gossiped, so an
+			// address nobody has seen is still kept apart from the
+			// addresses of this node.
+			gossiped = time.Unix(0, 0)
+		}
+		if ab.update(AddressBookEntry{Address: entry.Address, Kind: entry.Kind, Gossiped: gossiped}) {
+			changed = true
+		}
+	}
+	if changed {
+		err = ab.save()
+	}
+	return
+}
+
+// Remove forgets an address.
+func (ab *AddressBook) Remove(a Address) (err error) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	if _, exists := ab.entries[a]; exists {
+		delete(ab.entries, a)
+		err = ab.save()
+	}
+	return
+}
+
+// Entries returns the entries of the book, most recently seen first.
+func (ab *AddressBook) Entries() []AddressBookEntry {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	return ab.list()
+}
+
+// Addresses returns the addresses of a single kind of node, most recently
+// seen first.
+func (ab *AddressBook) Addresses(kind PeerKind) (addresses []Address) {
+	for _, entry := range ab.Entries() {
+		if entry.Kind == kind {
+			addresses = append(addresses, entry.Address)
+		}
+	}
+	return
+}
+
+// entriesBySeen sorts address book entries by the time they were last seen
+// by this node, most recent first, and then by the time they were gossiped.
+type entriesBySeen []AddressBookEntry
+
+func (e entriesBySeen) Len() int { return len(e) }
+func (e entriesBySeen) Less(i, j int) bool {
+	if !e[i].LastSeen.Equal(e[j].LastSeen) {
+		return e[i].LastSeen.After(e[j].LastSeen)
+	}
+	if !e[i].Gossiped.Equal(e[j].Gossiped) {
+		return e[i].Gossiped.After(e[j].Gossiped)
+	}
+	return e[i].Address.String() < e[j].Address.String()
+}
+func (e entriesBySeen) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
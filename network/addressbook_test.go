@@ -0,0 +1,155 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// TestAddressBook adds, merges and removes entries, and checks that the book
+// survives being reloaded from disk.
+func TestAddressBook(t *testing.T) {
+	filename := siafiles.TempFilename("TestAddressBook")
+	siafiles.Remove(filename)
+	book, err := NewAddressBook(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrap := Address{Host: "bootstrap", Port: 9988}
+	participant := Address{Host: "participant", Port: 9988, ID: 1}
+	err = book.Add(bootstrap, PeerServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = book.Seen(participant, PeerParticipant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := book.Entries()
+	if len(entries) != 2 || entries[0].Address != participant || entries[1].Address != bootstrap {
+		t.Fatal("entries are not sorted by when they were seen:", entries)
+	}
+	if !entries[1].LastSeen.IsZero() {
+		t.Error("bootstrap node has been seen without being contacted")
+	}
+	servers := book.Addresses(PeerServer)
+	if len(servers) != 1 || servers[0] != bootstrap {
+		t.Error("wrong servers:", servers)
+	}
+
+	// Merging records gossiped times apart from local sightings, and a
+	// time in the future is taken to be now.
+	gossiped := Address{Host: "gossiped", Port: 9988}
+	later := time.Now().Add(time.Hour)
+	err = book.Merge([]AddressBookEntry{
+		{Address: bootstrap, Kind: PeerServer, LastSeen: later},
+		{Address: participant, Kind: PeerParticipant},
+		{Address: gossiped, Kind: PeerServer, LastSeen: later},
+		{Address: Address{}, Kind: PeerServer, LastSeen: later},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = book.Entries()
+	if len(entries) != 3 || entries[0].Address != participant || entries[1].Address != gossiped || entries[2].Address != bootstrap {
+		t.Fatal("merge let a gossiped time outrank a local sighting:", entries)
+	}
+	if !entries[1].LastSeen.IsZero() || entries[1].Gossiped.IsZero() || entries[1].Gossiped.After(time.Now()) {
+		t.Error("gossiped time was not clamped and kept apart:", entries[1])
+	}
+	if entries[0].LastSeen.IsZero() || !entries[2].LastSeen.IsZero() || !entries[2].Gossiped.IsZero() {
+		t.Error("merge changed the times of local entries:", entries)
+	}
+
+	// Reload the book from disk.
+	reloaded, err := NewAddressBook(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadedEntries := reloaded.Entries()
+	if len(reloadedEntries) != 3 {
+		t.Fatal("reloaded book has the wrong number of entries:", reloadedEntries)
+	}
+	for i := range entries {
+		if reloadedEntries[i].Address != entries[i].Address || !reloadedEntries[i].LastSeen.Equal(entries[i].LastSeen) || !reloadedEntries[i].Gossiped.Equal(entries[i].Gossiped) {
+			t.Error("reloaded entry does not match:", reloadedEntries[i], entries[i])
+		}
+	}
+
+	err = reloaded.Remove(participant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err = NewAddressBook(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Entries()) != 2 {
+		t.Error("removed entry was not forgotten")
+	}
+}
+
+// TestAddressBookLimit checks that a full address book only takes merged
+// entries by evicting the entry that was gossiped longest ago, and never
+// evicts entries that were added locally.
+func TestAddressBookLimit(t *testing.T) {
+	defer func(max int) { maxAddressBookEntries = max }(maxAddressBookEntries)
+	maxAddressBookEntries = 3
+
+	filename := siafiles.TempFilename("TestAddressBookLimit")
+	siafiles.Remove(filename)
+	book, err := NewAddressBook(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := Address{Host: "local", Port: 9988}
+	err = book.Add(local, PeerServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := Address{Host: "old", Port: 9988}
+	recent := Address{Host: "recent", Port: 9988}
+	err = book.Merge([]AddressBookEntry{
+		{Address: old, Kind: PeerServer, LastSeen: now.Add(-2 * time.Hour)},
+		{Address: recent, Kind: PeerServer, LastSeen: now.Add(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry older than every gossiped entry is turned away, and a
+	// newer one replaces the oldest.
+	oldest := Address{Host: "oldest", Port: 9988}
+	newest := Address{Host: "newest", Port: 9988}
+	err = book.Merge([]AddressBookEntry{
+		{Address: oldest, Kind: PeerServer, LastSeen: now.Add(-3 * time.Hour)},
+		{Address: newest, Kind: PeerServer, LastSeen: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := book.Entries()
+	if len(entries) != 3 || entries[0].Address != newest || entries[1].Address != recent || entries[2].Address != local {
+		t.Fatal("wrong entries after merging into a full book:", entries)
+	}
+
+	// Local entries push out gossiped ones, but not each other.
+	for _, host := range []string{"a", "b", "c"} {
+		err = book.Seen(Address{Host: host, Port: 9988}, PeerServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries = book.Entries()
+	if len(entries) != 4 {
+		t.Fatal("expecting every local entry to be kept:", entries)
+	}
+	for _, entry := range entries {
+		if !entry.local() {
+			t.Error("gossiped entry was not evicted:", entry)
+		}
+	}
+}
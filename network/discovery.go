@@ -0,0 +1,183 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strconv"
+	"strings"
+)
+
+// A node learns its own hostname by asking other nodes which host its
+// connections come from. Every RPCServer answers RPCServer.ObservedAddress
+// under Identifier 0. The caller's host can't be known by the handler itself,
+// so the connection fills it into the arguments of the call before the
+// handler sees them, and the handler sends it back.
+//
+// The host that a single peer reports can't be trusted, so LearnHostname asks
+// several peers and goes with the host that most of them agree on.
+//
+// RPCServers also share their address books under Identifier 0, through
+// RPCServer.Peers, so that a node which knows a single server can find the
+// rest of the network.
+
+var (
+	errBadAddress  = errors.New("address must be written as host:port or host:port/id")
+	errNoObservers = errors.New("no peer reported an observed address")
+)
+
+var (
+	// observedAddressProc is the procedure that reports the caller's host.
+	observedAddressProc = rpcServerName + ".ObservedAddress"
+
+	// peersProc is the procedure that reports the entries of an
+	// RPCServer's address book.
+	peersProc = rpcServerName + ".Peers"
+)
+
+// An Observation is the host that a peer sees a connection coming from.
+type Observation struct {
+	Host string
+}
+
+// ObservedAddress replies with the host that the call came from, which was
+// filled into 'obs' by the connection.
+func (*rpcServerHandler) ObservedAddress(obs Observation, reply *Observation) error {
+	*reply = obs
+	return nil
+}
+
+// Peers replies with the entries of the RPCServer's address book, or with
+// no entries if the RPCServer doesn't have one.
+func (h *rpcServerHandler) Peers(_ struct{}, entries *[]AddressBookEntry) error {
+	h.rpcs.idLock.Lock()
+	book := h.rpcs.book
+	h.rpcs.idLock.Unlock()
+	if book != nil {
+		*entries = book.Entries()
+	}
+	return nil
+}
+
+// remoteHost returns the host on the other end of a connection.
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// observingCodec is a server codec that fills in the caller's host for calls
+// to RPCServer.ObservedAddress, overwriting whatever the caller sent.
+type observingCodec struct {
+	rpc.ServerCodec
+	remoteHost string
+	method     string
+}
+
+// ReadRequestHeader reads the header of the next request, remembering which
+// method is being called.
+func (oc *observingCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	err = oc.ServerCodec.ReadRequestHeader(r)
+	oc.method = r.ServiceMethod
+	return
+}
+
+// ReadRequestBody reads the arguments of the request, filling in the
+// caller's host if the request is for RPCServer.ObservedAddress.
+func (oc *observingCodec) ReadRequestBody(body interface{}) (err error) {
+	err = oc.ServerCodec.ReadRequestBody(body)
+	if err != nil {
+		return
+	}
+	if obs, ok := body.(*Observation); ok && oc.method == observedAddressProc {
+		obs.Host = oc.remoteHost
+	}
+	return
+}
+
+// ObservedHost asks the RPCServer at 'a' which host the calls of this
+// RPCServer come from.
+func (rpcs *RPCServer) ObservedHost(a Address) (host string, err error) {
+	var obs Observation
+	err = rpcs.pool.call(Address{a.Host, a.Port, 0}, 0, observedAddressProc, Observation{}, &obs)
+	host = obs.Host
+	return
+}
+
+// LearnHostname asks each of 'peers' for the host that this RPCServer's calls
+// come from, and uses the host reported by the most peers as the hostname
+// given out in Addresses. Ties go to the peer that comes first. Handlers that
+// were registered before the hostname was learned keep their old Address.
+func (rpcs *RPCServer) LearnHostname(peers []Address) (err error) {
+	votes := make(map[string]int)
+	var best string
+	for _, peer := range peers {
+		host, observeErr := rpcs.ObservedHost(peer)
+		if observeErr != nil || host == "" {
+			continue
+		}
+		votes[host]++
+		if votes[host] > votes[best] {
+			best = host
+		}
+	}
+	if best == "" {
+		err = errNoObservers
+		return
+	}
+
+	rpcs.idLock.Lock()
+	rpcs.addr.Host = best
+	rpcs.idLock.Unlock()
+	return
+}
+
+// SetAddressBook sets the address book that the RPCServer shares with peers
+// that call RPCServer.Peers.
+func (rpcs *RPCServer) SetAddressBook(book *AddressBook) {
+	rpcs.idLock.Lock()
+	rpcs.book = book
+	rpcs.idLock.Unlock()
+}
+
+// FetchPeers downloads the address book of the RPCServer at 'a'.
+func (rpcs *RPCServer) FetchPeers(a Address) (entries []AddressBookEntry, err error) {
+	err = rpcs.pool.call(Address{a.Host, a.Port, 0}, 0, peersProc, struct{}{}, &entries)
+	return
+}
+
+// String returns the address in the form read by ParseAddress.
+func (a Address) String() string {
+	return addrString(a) + "/" + strconv.Itoa(int(a.ID))
+}
+
+// ParseAddress parses an address written as "host:port", or as "host:port/id"
+// to name a specific handler. Without an id, the address refers to the
+// RPCServer itself.
+func ParseAddress(s string) (a Address, err error) {
+	if i := strings.LastIndex(s, "/"); i != -1 {
+		var id int
+		id, err = strconv.Atoi(s[i+1:])
+		if err != nil || id < 0 || id > 255 {
+			err = errBadAddress
+			return
+		}
+		a.ID = Identifier(id)
+		s = s[:i]
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || host == "" {
+		err = errBadAddress
+		return
+	}
+	a.Host = host
+	a.Port = uint16(p)
+	return
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"testing"
+)
+
+// TestLearnHostname has an RPCServer learn its hostname from the RPCServers
+// that it calls, and checks that a caller can't choose the host that is
+// reported back to it.
+func TestLearnHostname(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	var peers []Address
+	for _, host := range []string{"host2", "host3"} {
+		rpcs, err := NewRPCServerWithTransport(ln.Transport(host), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rpcs.Close()
+		peers = append(peers, rpcs.RegisterHandler(new(TestStoreHandler)))
+	}
+
+	// An RPCServer with no reachable peers can't learn anything.
+	err = rpcs1.LearnHostname([]Address{{"host4", 1, 0}})
+	if err != errNoObservers {
+		t.Fatal("expecting errNoObservers, got", err)
+	}
+
+	// Change the hostname so that it's clear that it was learned.
+	rpcs1.addr.Host = "unknown"
+	err = rpcs1.LearnHostname(peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := rpcs1.RegisterHandler(new(TestStoreHandler))
+	if addr.Host != "host1" {
+		t.Fatal("learned the wrong hostname:", addr.Host)
+	}
+
+	// The observed host is filled in by the peer, not the caller.
+	var obs Observation
+	err = rpcs1.pool.call(Address{"host2", 1, 0}, 0, observedAddressProc, Observation{"spoofed"}, &obs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.Host != "host1" {
+		t.Fatal("peer reported the host chosen by the caller:", obs.Host)
+	}
+}
+
+// TestFetchPeers downloads the address book of another RPCServer.
+func TestFetchPeers(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	rpcs2, err := NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs2.Close()
+
+	// An RPCServer without an address book has no peers to share.
+	entries, err := rpcs1.FetchPeers(Address{"host2", 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("expecting no peers, got", entries)
+	}
+
+	book, err := NewAddressBook("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Seen(Address{"host3", 1, 2}, PeerParticipant)
+	rpcs2.SetAddressBook(book)
+	entries, err = rpcs1.FetchPeers(Address{"host2", 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Address != (Address{"host3", 1, 2}) || entries[0].Kind != PeerParticipant {
+		t.Fatal("fetched the wrong peers:", entries)
+	}
+}
+
+// TestParseAddress parses addresses with and without an Identifier.
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		s       string
+		address Address
+		valid   bool
+	}{
+		{"localhost:9988", Address{"localhost", 9988, 0}, true},
+		{"example.com:1/3", Address{"example.com", 1, 3}, true},
+		{"[::1]:9988/255", Address{"::1", 9988, 255}, true},
+		{"localhost", Address{}, false},
+		{"localhost:99999", Address{}, false},
+		{"localhost:9988/256", Address{}, false},
+		{":9988", Address{}, false},
+	}
+	for _, test := range tests {
+		address, err := ParseAddress(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: unexpected error %v", test.s, err)
+			continue
+		}
+		if test.valid && address != test.address {
+			t.Errorf("%q: parsed as %v", test.s, address)
+		}
+		if test.valid && test.address.ID != 0 && address.String() != test.s {
+			t.Errorf("%q: printed as %v", test.s, address.String())
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/NebulousLabs/Sia/sialog"
+)
+
+// The RPCServer protects its handlers from peers that send too much. Every
+// request is checked against two kinds of limits before it reaches a handler:
+//
+// Rate limits are token buckets. Each remote host has a bucket for all of its
+// calls, and a bucket for each handler procedure that it calls, so that a
+// peer flooding one participant doesn't use up the allowance of the other
+// participants on the same server. A call that finds a bucket empty is
+// answered with errRateLimited without being decoded.
+//
+// Message limits cap the encoded size of the arguments of a procedure. No
+// request can be larger than maxMessageSize, which is enforced while the
+// request is read off the connection. A request that is too large to be read
+// breaks the connection. Procedures can have smaller limits of their own,
+// which are checked before the arguments are decoded, and a request that
+// breaks such a limit is answered with errMessageTooLarge.
+//
+// Limits are set per procedure, without the Identifier of the handler, so
+// "Participant.AddScriptInput" covers the AddScriptInput calls of every
+// participant on the server. Violations are counted in LimitStats, and a
+// peer is logged whenever it starts being rate limited.
+
+var (
+	// maxMessageSize is the largest request that is read from a
+	// connection. The size is measured in bytes read from the connection
+	// while decoding the request, which can include the start of the next
+	// request, so it is only a rough limit.
+	maxMessageSize = 1 << 24
+
+	// maxBuckets is the number of token buckets that are kept before full
+	// buckets are thrown out. A full bucket is the same as no bucket. If
+	// none of the buckets are full, the bucket that was used longest ago is
+	// thrown out instead, which lets its peer start over with a full
+	// bucket.
+	maxBuckets = 1 << 14
+
+	errMessageTooLarge = errors.New("request exceeds the maximum message size")
+	errRateLimited     = errors.New("rate limit exceeded")
+)
+
+// A RateLimit allows Burst calls at once, refilled at Rate calls per second.
+// The zero RateLimit allows everything.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// LimitStats counts the requests that broke a limit, by procedure. Requests
+// that were too large to be read at all are counted under the empty
+// procedure name, since their procedure is not known.
+type LimitStats struct {
+	RateLimited map[string]uint64
+	Oversized   map[string]uint64
+}
+
+// A tokenBucket holds the calls left in a RateLimit. limited is true once the
+// bucket has turned a call away, until it allows a call again.
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	last    time.Time
+	limited bool
+}
+
+// refill adds the tokens that have accumulated since the last refill.
+func (tb *tokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.limit.Rate
+	if tb.tokens > float64(tb.limit.Burst) {
+		tb.tokens = float64(tb.limit.Burst)
+	}
+	tb.last = now
+}
+
+// full returns true if the bucket has no calls taken out of it.
+func (tb *tokenBucket) full() bool {
+	return tb.tokens >= float64(tb.limit.Burst)
+}
+
+// A limiter holds the limits of an RPCServer and the buckets of its peers.
+type limiter struct {
+	peerLimit     RateLimit
+	rateLimits    map[string]RateLimit
+	messageLimits map[string]int
+	buckets       map[string]*tokenBucket
+	stats         LimitStats
+	log           *sialog.Logger
+	lock          sync.Mutex
+}
+
+// newLimiter returns a limiter with no limits.
+func newLimiter() *limiter {
+	return &limiter{
+		rateLimits:    make(map[string]RateLimit),
+		messageLimits: make(map[string]int),
+		buckets:       make(map[string]*tokenBucket),
+		stats: LimitStats{
+			RateLimited: make(map[string]uint64),
+			Oversized:   make(map[string]uint64),
+		},
+		log: sialog.Default,
+	}
+}
+
+// procedureName removes the handler Identifier from the name of a called
+// method, turning "Participant\x01.Ping" into "Participant.Ping".
+func procedureName(method string) string {
+	dot := strings.LastIndex(method, ".")
+	if dot < 1 {
+		return method
+	}
+	_, size := utf8.DecodeLastRuneInString(method[:dot])
+	return method[:dot-size] + method[dot:]
+}
+
+// handlerID returns the Identifier of the handler in the name of a called
+// method, turning "Participant\x01.Ping" into 1.
+func handlerID(method string) Identifier {
+	dot := strings.LastIndex(method, ".")
+	if dot < 1 {
+		return 0
+	}
+	id, _ := utf8.DecodeLastRuneInString(method[:dot])
+	return Identifier(id)
+}
+
+// bucket returns the bucket under 'key', creating a full one if there isn't
+// one. When there are maxBuckets buckets already, the full buckets are thrown
+// out, or the bucket that was used longest ago if there are none. bucket
+// requires the limiter lock.
+func (l *limiter) bucket(key string, limit RateLimit, now time.Time) (tb *tokenBucket) {
+	tb, exists := l.buckets[key]
+	if exists {
+		tb.limit = limit
+		tb.refill(now)
+		return
+	}
+
+	if len(l.buckets) >= maxBuckets {
+		var oldest string
+		var oldestLast time.Time
+		for k, b := range l.buckets {
+			last := b.last
+			b.refill(now)
+			if b.full() {
+				delete(l.buckets, k)
+			} else if oldest == "" || last.Before(oldestLast) {
+				oldest, oldestLast = k, last
+			}
+		}
+		if len(l.buckets) >= maxBuckets {
+			delete(l.buckets, oldest)
+		}
+	}
+	tb = &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+	l.buckets[key] = tb
+	return
+}
+
+// admit takes a token for a call to 'method' from 'host', returning
+// errRateLimited if the host has run out of calls.
+func (l *limiter) admit(host string, method string) (err error) {
+	proc := procedureName(method)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	var buckets []*tokenBucket
+	if l.peerLimit != (RateLimit{}) {
+		buckets = append(buckets, l.bucket(host, l.peerLimit, now))
+	}
+	if limit := l.rateLimits[proc]; limit != (RateLimit{}) {
+		buckets = append(buckets, l.bucket(host+" "+method, limit, now))
+	}
+	for _, tb := range buckets {
+		if tb.tokens < 1 {
+			if !tb.limited {
+				l.log.Warn("rate limiting calls to", proc, "from", host)
+			}
+			tb.limited = true
+			l.stats.RateLimited[proc]++
+			err = errRateLimited
+			return
+		}
+	}
+	for _, tb := range buckets {
+		tb.tokens--
+		tb.limited = false
+	}
+	return
+}
+
+// checkSize returns errMessageTooLarge if 'size' is more than the message
+// limit of 'method'.
+func (l *limiter) checkSize(host string, method string, size int) (err error) {
+	proc := procedureName(method)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	limit, exists := l.messageLimits[proc]
+	if exists && size > limit {
+		l.oversized(host, proc, size)
+		err = errMessageTooLarge
+	}
+	return
+}
+
+// oversized records a request that was too large. oversized requires the
+// limiter lock.
+func (l *limiter) oversized(host string, proc string, size int) {
+	l.stats.Oversized[proc]++
+	if proc == "" {
+		l.log.Warn("dropped a connection from", host, "after reading", size, "bytes of a single request")
+		return
+	}
+	l.log.Warn("rejected a request of", size, "bytes to", proc, "from", host)
+}
+
+// limitedConn is the connection that a server codec reads requests from. It
+// fails the read that would take a request past 'limit' bytes.
+type limitedConn struct {
+	io.ReadWriteCloser
+	read  int
+	limit int
+}
+
+// Read reads from the connection, up to the limit of the current request.
+func (lc *limitedConn) Read(b []byte) (n int, err error) {
+	if lc.read >= lc.limit {
+		err = errMessageTooLarge
+		return
+	}
+	if len(b) > lc.limit-lc.read {
+		b = b[:lc.limit-lc.read]
+	}
+	n, err = lc.ReadWriteCloser.Read(b)
+	lc.read += n
+	return
+}
+
+// limitingCodec is a JSON server codec that checks every request against the
+// limits of the RPCServer. A request that breaks a limit has its arguments
+// thrown away, and is answered with the error instead of being handed to the
+// handler.
+type limitingCodec struct {
+	rpc.ServerCodec
+	conn     *limitedConn
+	limits   *limiter
+	host     string
+	method   string
+	rejected error
+}
+
+// newLimitingCodec returns a limiting JSON codec that reads from 'conn',
+// which belongs to 'host'.
+func newLimitingCodec(conn io.ReadWriteCloser, limits *limiter, host string) *limitingCodec {
+	lc := &limitedConn{ReadWriteCloser: conn, limit: maxMessageSize}
+	return &limitingCodec{
+		ServerCodec: jsonrpc.NewServerCodec(lc),
+		conn:        lc,
+		limits:      limits,
+		host:        host,
+	}
+}
+
+// ReadRequestHeader reads the next request and checks it against the rate
+// limits. The JSON codec reads the whole request along with the header, so
+// this is also where maxMessageSize is enforced.
+func (lc *limitingCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	lc.conn.read = 0
+	err = lc.ServerCodec.ReadRequestHeader(r)
+	if err == errMessageTooLarge {
+		lc.limits.lock.Lock()
+		lc.limits.oversized(lc.host, "", lc.conn.read)
+		lc.limits.lock.Unlock()
+	}
+	if err != nil {
+		return
+	}
+	lc.method = r.ServiceMethod
+	lc.rejected = lc.limits.admit(lc.host, r.ServiceMethod)
+	return
+}
+
+// ReadRequestBody decodes the arguments of the request, unless the request
+// broke a limit.
+func (lc *limitingCodec) ReadRequestBody(body interface{}) (err error) {
+	if lc.rejected != nil || body == nil {
+		lc.ServerCodec.ReadRequestBody(nil)
+		return lc.rejected
+	}
+
+	var raw json.RawMessage
+	err = lc.ServerCodec.ReadRequestBody(&raw)
+	if err != nil {
+		return
+	}
+	err = lc.limits.checkSize(lc.host, lc.method, len(raw))
+	if err != nil {
+		return
+	}
+	return json.Unmarshal(raw, body)
+}
+
+// SetPeerRateLimit sets the limit on all calls from a single host.
+func (rpcs *RPCServer) SetPeerRateLimit(limit RateLimit) {
+	rpcs.limits.lock.Lock()
+	rpcs.limits.peerLimit = limit
+	rpcs.limits.lock.Unlock()
+}
+
+// SetRateLimit sets the limit on the calls that a single host makes to
+// 'proc' of a single handler. 'proc' is named like the Proc of a Message,
+// for example "Participant.AddScriptInput".
+func (rpcs *RPCServer) SetRateLimit(proc string, limit RateLimit) {
+	rpcs.limits.lock.Lock()
+	rpcs.limits.rateLimits[proc] = limit
+	rpcs.limits.lock.Unlock()
+}
+
+// SetMessageLimit sets the largest encoded size, in bytes, of the arguments
+// of a call to 'proc'. A limit larger than maxMessageSize has no effect.
+func (rpcs *RPCServer) SetMessageLimit(proc string, size int) {
+	rpcs.limits.lock.Lock()
+	rpcs.limits.messageLimits[proc] = size
+	rpcs.limits.lock.Unlock()
+}
+
+// LimitStats returns the number of requests that broke each limit.
+func (rpcs *RPCServer) LimitStats() (stats LimitStats) {
+	rpcs.limits.lock.Lock()
+	defer rpcs.limits.lock.Unlock()
+	stats = LimitStats{
+		RateLimited: make(map[string]uint64),
+		Oversized:   make(map[string]uint64),
+	}
+	for proc, n := range rpcs.limits.stats.RateLimited {
+		stats.RateLimited[proc] = n
+	}
+	for proc, n := range rpcs.limits.stats.Oversized {
+		stats.Oversized[proc] = n
+	}
+	return
+}
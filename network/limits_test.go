@@ -0,0 +1,134 @@
+package network
+
+import (
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimits checks that calls are turned away once a peer runs out of
+// calls to a procedure, and that the peer limit covers every handler.
+func TestRateLimits(t *testing.T) {
+	_, rpcs1, rpcs2, m := newPoolTestServers(t, DefaultPoolSettings)
+	defer rpcs1.Close()
+	defer rpcs2.Close()
+	other := m
+	other.Dest = rpcs2.RegisterHandler(new(TestStoreHandler))
+
+	// The limit is kept separately for each handler.
+	rpcs2.SetRateLimit("TestStoreHandler.StoreMessage", RateLimit{Burst: 2})
+	for i := 0; i < 2; i++ {
+		err := rpcs1.SendMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := rpcs1.SendMessage(m)
+	if _, ok := err.(rpc.ServerError); !ok || err.Error() != errRateLimited.Error() {
+		t.Fatal("expecting the third call to be rate limited, got", err)
+	}
+	err = rpcs1.SendMessage(other)
+	if err != nil {
+		t.Fatal("limit of one handler was applied to another:", err)
+	}
+	if rpcs2.LimitStats().RateLimited["TestStoreHandler.StoreMessage"] != 1 {
+		t.Error("rate limited call was not counted:", rpcs2.LimitStats())
+	}
+
+	// The peer limit covers calls to every handler.
+	rpcs2.SetRateLimit("TestStoreHandler.StoreMessage", RateLimit{})
+	rpcs2.SetPeerRateLimit(RateLimit{Burst: 1})
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rpcs1.SendMessage(other)
+	if err == nil || err.Error() != errRateLimited.Error() {
+		t.Fatal("expecting the peer limit to cover both handlers, got", err)
+	}
+}
+
+// TestMessageLimits sends requests that are larger than the limit of their
+// procedure, and larger than maxMessageSize.
+func TestMessageLimits(t *testing.T) {
+	_, rpcs1, rpcs2, m := newPoolTestServers(t, DefaultPoolSettings)
+	defer rpcs1.Close()
+	defer rpcs2.Close()
+
+	rpcs2.SetMessageLimit("TestStoreHandler.StoreMessage", 100)
+	tsh := new(TestStoreHandler)
+	m.Dest = rpcs2.RegisterHandler(tsh)
+	m.Args = strings.Repeat("a", 200)
+	err := rpcs1.SendMessage(m)
+	if err == nil || err.Error() != errMessageTooLarge.Error() {
+		t.Fatal("expecting errMessageTooLarge, got", err)
+	}
+	if tsh.stored() != "" {
+		t.Fatal("oversized message reached the handler")
+	}
+
+	// The connection can still be used for smaller messages.
+	m.Args = "hello, world!"
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsh.stored() != "hello, world!" {
+		t.Fatal("message was not delivered after an oversized message")
+	}
+	if rpcs2.LimitStats().Oversized["TestStoreHandler.StoreMessage"] != 1 {
+		t.Error("oversized message was not counted:", rpcs2.LimitStats())
+	}
+
+	// A request larger than maxMessageSize breaks the connection. The
+	// limit is read when a connection is accepted.
+	defer func(size int) { maxMessageSize = size }(maxMessageSize)
+	maxMessageSize = 1000
+	rpcs1.pool.dropAddress(m.Dest)
+	m.Args = strings.Repeat("a", 2000)
+	err = rpcs1.SendMessage(m)
+	if err == nil {
+		t.Fatal("expecting a request larger than maxMessageSize to fail")
+	}
+	if rpcs2.LimitStats().Oversized[""] != 1 {
+		t.Error("unreadable message was not counted:", rpcs2.LimitStats())
+	}
+}
+
+// TestBucketLimit checks that the number of buckets stays at maxBuckets when
+// none of them are full, by throwing out the bucket used longest ago.
+func TestBucketLimit(t *testing.T) {
+	defer func(max int) { maxBuckets = max }(maxBuckets)
+	maxBuckets = 3
+
+	l := newLimiter()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+	for i, key := range []string{"a", "b", "c", "d"} {
+		l.bucket(key, limit, now.Add(time.Duration(i)*time.Millisecond)).tokens = 0
+	}
+	if len(l.buckets) != 3 {
+		t.Fatal("expecting 3 buckets, got", len(l.buckets))
+	}
+	if _, exists := l.buckets["a"]; exists {
+		t.Error("the bucket used longest ago was kept")
+	}
+}
+
+// TestProcedureName strips Identifiers from method names.
+func TestProcedureName(t *testing.T) {
+	tests := []struct {
+		method, proc string
+	}{
+		{"Participant" + string(Identifier(1)) + ".Ping", "Participant.Ping"},
+		{"Participant" + string(Identifier(200)) + ".Ping", "Participant.Ping"},
+		{rpcServerName + ".Ping", "RPCServer.Ping"},
+		{"Ping", "Ping"},
+	}
+	for _, test := range tests {
+		if proc := procedureName(test.method); proc != test.proc {
+			t.Errorf("%q: expecting %q, got %q", test.method, test.proc, proc)
+		}
+	}
+}
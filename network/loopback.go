@@ -0,0 +1,283 @@
+package network
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The loopback network carries connections between RPCServers in the same
+// process, without opening any ports. Every RPCServer on the loopback network
+// gets its own host, and the network can be told to delay messages, to lose
+// messages, and to partition hosts from each other. This makes it possible to
+// simulate a whole quorum, including the failures of a real network, within a
+// single test.
+//
+// Messages travel over connections that stay open between calls, so losing
+// a message is modeled as breaking the connection that carries it, the way a
+// TCP connection eventually breaks when its packets stop arriving. Opening a
+// connection can be lost as well. Latency is added to every write, which for
+// the JSON codec means once per request and once per response.
+
+var (
+	errAddressInUse    = errors.New("loopback address is already in use")
+	errConnectionLost  = errors.New("loopback connection was lost")
+	errListenerClosed  = errors.New("loopback listener is closed")
+	errNoListener      = errors.New("no loopback listener at that address")
+	errPartitionedHost = errors.New("loopback host is partitioned from the destination")
+)
+
+// A LoopbackNetwork connects the hosts of an in-process network. It is safe to
+// use from multiple goroutines.
+type LoopbackNetwork struct {
+	listeners map[string]*loopbackListener
+	conns     map[*loopbackConn]struct{}
+	groups    map[string]int
+	latency   time.Duration
+	loss      float64
+
+	// partitions counts the calls to Partition, so that every partition
+	// gets its own group.
+	partitions int
+
+	lock sync.RWMutex
+}
+
+// NewLoopbackNetwork returns an empty loopback network with no latency, no
+// loss and no partitions.
+func NewLoopbackNetwork() *LoopbackNetwork {
+	return &LoopbackNetwork{
+		listeners: make(map[string]*loopbackListener),
+		conns:     make(map[*loopbackConn]struct{}),
+		groups:    make(map[string]int),
+	}
+}
+
+// Transport returns a Transport that connects to the loopback network as
+// 'host'.
+func (ln *LoopbackNetwork) Transport(host string) Transport {
+	return &loopbackTransport{
+		network: ln,
+		host:    host,
+	}
+}
+
+// SetLatency sets the delay that is added to every write on the network.
+func (ln *LoopbackNetwork) SetLatency(latency time.Duration) {
+	ln.lock.Lock()
+	ln.latency = latency
+	ln.lock.Unlock()
+}
+
+// SetLoss sets the probability, between 0 and 1, that a write or a dial is
+// lost.
+func (ln *LoopbackNetwork) SetLoss(loss float64) {
+	ln.lock.Lock()
+	ln.loss = loss
+	ln.lock.Unlock()
+}
+
+// Partition cuts the given hosts off from every host that is not in the list.
+// Connections that cross the partition are closed, and no new connections can
+// be made across it until Heal is called. Partitioning a set of hosts that
+// overlaps an earlier partition moves those hosts into the new partition.
+func (ln *LoopbackNetwork) Partition(hosts ...string) {
+	ln.lock.Lock()
+	defer ln.lock.Unlock()
+
+	ln.partitions++
+	for _, host := range hosts {
+		ln.groups[host] = ln.partitions
+	}
+	for conn := range ln.conns {
+		if !ln.reachable(conn.local, conn.remote) {
+			conn.Conn.Close()
+			delete(ln.conns, conn)
+		}
+	}
+}
+
+// Heal removes every partition from the network.
+func (ln *LoopbackNetwork) Heal() {
+	ln.lock.Lock()
+	ln.groups = make(map[string]int)
+	ln.lock.Unlock()
+}
+
+// reachable returns true if hosts 'a' and 'b' are on the same side of every
+// partition. reachable requires the network lock.
+func (ln *LoopbackNetwork) reachable(a, b string) bool {
+	return ln.groups[a] == ln.groups[b]
+}
+
+// lost returns true if the next write or dial should be lost. lost requires
+// the network lock.
+func (ln *LoopbackNetwork) lost() bool {
+	return ln.loss > 0 && rand.Float64() < ln.loss
+}
+
+// loopbackAddress returns the key of the listener at 'host' and 'port'.
+func loopbackAddress(host string, port uint16) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// loopbackTransport is the Transport of a single host on a loopback network.
+type loopbackTransport struct {
+	network *LoopbackNetwork
+	host    string
+}
+
+// Listen opens a listener on 'port' of the transport's host.
+func (lt *loopbackTransport) Listen(port uint16) (l net.Listener, err error) {
+	lt.network.lock.Lock()
+	defer lt.network.lock.Unlock()
+
+	address := loopbackAddress(lt.host, port)
+	if _, exists := lt.network.listeners[address]; exists {
+		err = errAddressInUse
+		return
+	}
+	listener := &loopbackListener{
+		network: lt.network,
+		address: address,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	lt.network.listeners[address] = listener
+	l = listener
+	return
+}
+
+// Dial opens a connection to the listener at 'a', unless the destination is
+// partitioned from the transport's host or the message is lost.
+func (lt *loopbackTransport) Dial(a Address) (conn net.Conn, err error) {
+	ln := lt.network
+	ln.lock.Lock()
+	listener, exists := ln.listeners[loopbackAddress(a.Host, a.Port)]
+	if !exists {
+		ln.lock.Unlock()
+		err = errNoListener
+		return
+	}
+	if !ln.reachable(lt.host, a.Host) {
+		ln.lock.Unlock()
+		err = errPartitionedHost
+		return
+	}
+	if ln.lost() {
+		ln.lock.Unlock()
+		err = errConnectionLost
+		return
+	}
+
+	local, remote := net.Pipe()
+	localConn := &loopbackConn{Conn: local, network: ln, local: lt.host, remote: a.Host}
+	remoteConn := &loopbackConn{Conn: remote, network: ln, local: a.Host, remote: lt.host}
+	ln.conns[localConn] = struct{}{}
+	ln.conns[remoteConn] = struct{}{}
+	ln.lock.Unlock()
+
+	select {
+	case listener.conns <- remoteConn:
+		conn = localConn
+	case <-listener.closed:
+		localConn.Close()
+		remoteConn.Close()
+		err = errNoListener
+	}
+	return
+}
+
+// Host returns the host of the transport.
+func (lt *loopbackTransport) Host() string {
+	return lt.host
+}
+
+// loopbackListener is a net.Listener on the loopback network.
+type loopbackListener struct {
+	network   *LoopbackNetwork
+	address   string
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for the next connection to the listener.
+func (l *loopbackListener) Accept() (conn net.Conn, err error) {
+	select {
+	case conn = <-l.conns:
+	case <-l.closed:
+		err = errListenerClosed
+	}
+	return
+}
+
+// Close stops the listener and frees its address.
+func (l *loopbackListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.network.lock.Lock()
+		delete(l.network.listeners, l.address)
+		l.network.lock.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *loopbackListener) Addr() net.Addr {
+	return loopbackAddr(l.address)
+}
+
+// loopbackAddr is the net.Addr of a listener on the loopback network.
+type loopbackAddr string
+
+func (a loopbackAddr) Network() string { return "loopback" }
+func (a loopbackAddr) String() string  { return string(a) }
+
+// loopbackConn is one end of a connection on the loopback network. It adds
+// the network's latency to every write.
+type loopbackConn struct {
+	net.Conn
+	network *LoopbackNetwork
+	local   string
+	remote  string
+}
+
+// Write waits for the network's latency before writing 'b'. If the write is
+// lost, the connection is closed instead.
+func (c *loopbackConn) Write(b []byte) (n int, err error) {
+	c.network.lock.RLock()
+	latency := c.network.latency
+	lost := c.network.lost()
+	c.network.lock.RUnlock()
+	if lost {
+		c.Close()
+		err = errConnectionLost
+		return
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return c.Conn.Write(b)
+}
+
+// LocalAddr returns the host of this end of the connection.
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return loopbackAddr(loopbackAddress(c.local, 0))
+}
+
+// RemoteAddr returns the host of the other end of the connection.
+func (c *loopbackConn) RemoteAddr() net.Addr {
+	return loopbackAddr(loopbackAddress(c.remote, 0))
+}
+
+// Close closes the connection and removes it from the network.
+func (c *loopbackConn) Close() error {
+	c.network.lock.Lock()
+	delete(c.network.conns, c)
+	c.network.lock.Unlock()
+	return c.Conn.Close()
+}
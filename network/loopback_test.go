@@ -0,0 +1,128 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoopbackNetwork sends messages between two RPCServers on a loopback
+// network, and checks that latency, loss and partitions are applied.
+func TestLoopbackNetwork(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	rpcs2, err := NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs2.Close()
+
+	// The same port can't be opened twice on one host.
+	_, err = NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != errAddressInUse {
+		t.Error("expecting errAddressInUse, got", err)
+	}
+
+	tsh := new(TestStoreHandler)
+	addr := rpcs2.RegisterHandler(tsh)
+	if addr.Host != "host2" {
+		t.Fatal("handler was given the wrong host:", addr.Host)
+	}
+	m := Message{
+		Dest: addr,
+		Proc: "TestStoreHandler.StoreMessage",
+		Args: "hello, world!",
+	}
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsh.stored() != "hello, world!" {
+		t.Fatal("Bad response: expected \"hello, world!\", got \"" + tsh.stored() + "\"")
+	}
+
+	// A message takes at least two writes, one for the request and one
+	// for the response.
+	latency := 20 * time.Millisecond
+	ln.SetLatency(latency)
+	start := time.Now()
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 2*latency {
+		t.Error("message was delivered faster than the latency allows:", time.Since(start))
+	}
+	ln.SetLatency(0)
+
+	// Every message is lost with a loss of 1. The first message breaks the
+	// open connection, and the next one can't open a new connection.
+	ln.SetLoss(1)
+	for i := 0; i < 2; i++ {
+		err = rpcs1.SendMessage(m)
+		if err != errConnectionLost {
+			t.Error("expecting errConnectionLost, got", err)
+		}
+	}
+	ln.SetLoss(0)
+
+	// Partitioned hosts can't reach each other, in either direction, until
+	// the partition is healed. The first message fails on the connection
+	// that the partition closed.
+	ln.Partition("host1")
+	err = rpcs1.SendMessage(m)
+	if err == nil {
+		t.Error("message crossed the partition")
+	}
+	err = rpcs1.SendMessage(m)
+	if err != errPartitionedHost {
+		t.Error("expecting errPartitionedHost, got", err)
+	}
+	addr1 := rpcs1.RegisterHandler(new(TestStoreHandler))
+	err = rpcs2.SendMessage(Message{Dest: addr1, Proc: "TestStoreHandler.StoreMessage", Args: ""})
+	if err != errPartitionedHost {
+		t.Error("expecting errPartitionedHost, got", err)
+	}
+	ln.Heal()
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestLoopbackPartitionClosesConnections checks that partitioning a host ends
+// the messages that are in flight across the partition.
+func TestLoopbackPartitionClosesConnections(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	rpcs2, err := NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs2.Close()
+
+	addr := rpcs2.RegisterHandler(new(TestStoreHandler))
+	errChan := rpcs1.SendAsyncMessage(Message{
+		Dest: addr,
+		Proc: "TestStoreHandler.BlockForever",
+		Args: "",
+	})
+	time.Sleep(10 * time.Millisecond)
+	ln.Partition("host2")
+
+	select {
+	case err = <-errChan:
+		if err == nil {
+			t.Error("message across the partition succeeded")
+		}
+	case <-time.After(timeout / 2):
+		t.Error("message across the partition was not ended")
+	}
+}
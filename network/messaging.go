@@ -11,10 +11,14 @@ type Address struct {
 }
 
 // A Message is for sending requests over the network.
-// It consists of an Address and an RPC.
+// It consists of an Address and an RPC. Source is the Identifier of the
+// handler that the message is sent on behalf of; if the handler has an
+// identity, the recipient sees the message come from the handler's public
+// key. A Source of 0 sends the message anonymously.
 type Message struct {
-	Dest Address
-	Proc string
-	Args interface{}
-	Resp interface{}
+	Dest   Address
+	Source Identifier
+	Proc   string
+	Args   interface{}
+	Resp   interface{}
 }
@@ -0,0 +1,313 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+)
+
+// The connection pool keeps one client connection open to every peer that the
+// RPCServer talks to, instead of dialing a new connection for every message.
+// net/rpc clients can carry many concurrent calls over one connection, so the
+// calls to a peer are multiplexed over its pooled connection.
+//
+// A connection that breaks is dropped from the pool, and a call that found
+// the connection already broken is retried once on a new connection. Calls
+// that fail after the request may have been sent are not retried, since the
+// peer may already have processed them. A call that times out drops its
+// connection as well, since a peer that stops answering would otherwise keep
+// every later call waiting for the timeout. In the background, the pool closes
+// connections that have been idle for longer than IdleTimeout, and pings the
+// other idle connections to find the ones that have silently died.
+
+var (
+	errPoolClosed = errors.New("connection pool is closed")
+	errTimedOut   = errors.New("request timed out")
+)
+
+// PoolSettings are the timings used by an RPCServer's connection pool.
+// HealthCheckInterval is the time between two rounds of health checks,
+// IdleTimeout is how long a connection can go unused before it is closed, and
+// CallTimeout is how long a call or a ping waits for its response.
+type PoolSettings struct {
+	HealthCheckInterval time.Duration
+	IdleTimeout         time.Duration
+	CallTimeout         time.Duration
+}
+
+// DefaultPoolSettings are the pool settings used unless the RPCServer is
+// created with the WithPoolSettings option.
+var DefaultPoolSettings = PoolSettings{
+	HealthCheckInterval: 30 * time.Second,
+	IdleTimeout:         2 * time.Minute,
+	CallTimeout:         timeout,
+}
+
+// healthCheckProc is the procedure used to ping pooled connections.
+var healthCheckProc = rpcServerName + ".Ping"
+
+// ConnectionStats counts the activity of an RPCServer's connection pool. Dials
+// is the number of connections opened, Reconnects is the number of calls
+// retried on a new connection after finding the old one broken, Evictions is
+// the number of connections closed for being idle, and FailedHealthChecks is
+// the number of connections closed because they did not answer a ping. Open
+// is the number of connections currently in the pool.
+type ConnectionStats struct {
+	Dials              uint64
+	Reconnects         uint64
+	Evictions          uint64
+	FailedHealthChecks uint64
+	Open               int
+}
+
+// A poolKey identifies a pooled connection by the address of the handler
+// that it was opened to, and the Identifier of the handler that it sends
+// messages on behalf of.
+type poolKey struct {
+	dest   Address
+	source Identifier
+}
+
+// A pooledClient is a client connection to a single peer. inFlight is the
+// number of calls currently using the connection, and lastUsed is the time
+// the most recent call finished.
+type pooledClient struct {
+	key      poolKey
+	client   *rpc.Client
+	inFlight int
+	lastUsed time.Time
+}
+
+// A connPool holds the client connections of an RPCServer, keyed by the
+// address of the handler and by the handler sending the messages.
+// Connections are per handler rather than per host, since each side of a
+// connection is authenticated as a single handler.
+type connPool struct {
+	dial     func(Address, Identifier) (net.Conn, error)
+	settings PoolSettings
+	clients  map[poolKey]*pooledClient
+	stats    ConnectionStats
+	closed   chan struct{}
+	lock     sync.Mutex
+}
+
+// newConnPool creates an empty pool that opens connections with 'dial', and
+// starts its health checks.
+func newConnPool(dial func(Address, Identifier) (net.Conn, error), settings PoolSettings) (pool *connPool) {
+	pool = &connPool{
+		dial:     dial,
+		settings: settings,
+		clients:  make(map[poolKey]*pooledClient),
+		closed:   make(chan struct{}),
+	}
+	go pool.checkHealth()
+	return
+}
+
+// get returns the pooled connection to 'a' from 'source', dialing a new one if
+// there isn't one. The connection is marked as in use until release is
+// called.
+func (pool *connPool) get(a Address, source Identifier) (pc *pooledClient, err error) {
+	key := poolKey{a, source}
+	pool.lock.Lock()
+	select {
+	case <-pool.closed:
+		pool.lock.Unlock()
+		err = errPoolClosed
+		return
+	default:
+	}
+	pc, exists := pool.clients[key]
+	if exists {
+		pc.inFlight++
+		pool.lock.Unlock()
+		return
+	}
+	pool.lock.Unlock()
+
+	// Dial without holding the lock, so that a slow peer doesn't hold up
+	// calls to the other peers.
+	conn, err := pool.dial(a, source)
+	if err != nil {
+		return
+	}
+	client := jsonrpc.NewClient(conn)
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.stats.Dials++
+	pc, exists = pool.clients[key]
+	if exists {
+		// Another call dialed the peer at the same time.
+		client.Close()
+	} else {
+		pc = &pooledClient{key: key, client: client}
+		pool.clients[key] = pc
+	}
+	pc.inFlight++
+	return
+}
+
+// release marks the end of a call on a pooled connection.
+func (pool *connPool) release(pc *pooledClient) {
+	pool.lock.Lock()
+	pc.inFlight--
+	pc.lastUsed = time.Now()
+	pool.lock.Unlock()
+}
+
+// drop removes a connection from the pool and closes it. drop requires the
+// pool lock.
+func (pool *connPool) drop(pc *pooledClient) {
+	if pool.clients[pc.key] == pc {
+		delete(pool.clients, pc.key)
+	}
+	pc.client.Close()
+}
+
+// dropAddress closes the pooled connections to 'a'.
+func (pool *connPool) dropAddress(a Address) {
+	pool.lock.Lock()
+	for key, pc := range pool.clients {
+		if key.dest == a {
+			pool.drop(pc)
+		}
+	}
+	pool.lock.Unlock()
+}
+
+// dropSource closes the pooled connections that were opened on behalf of
+// 'source'.
+func (pool *connPool) dropSource(source Identifier) {
+	pool.lock.Lock()
+	for key, pc := range pool.clients {
+		if key.source == source {
+			pool.drop(pc)
+		}
+	}
+	pool.lock.Unlock()
+}
+
+// broken returns true if the error returned by a call means that the
+// connection can no longer be used. Errors returned by the remote procedure
+// itself leave the connection intact.
+func broken(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, serverErr := err.(rpc.ServerError)
+	return !serverErr
+}
+
+// call makes a call to 'name' on the peer at 'a' on behalf of 'source',
+// waiting up to the pool's CallTimeout for the response.
+func (pool *connPool) call(a Address, source Identifier, name string, args interface{}, resp interface{}) (err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		var pc *pooledClient
+		pc, err = pool.get(a, source)
+		if err != nil {
+			return
+		}
+
+		c := pc.client.Go(name, args, resp, make(chan *rpc.Call, 1))
+		select {
+		case <-c.Done:
+			err = c.Error
+		case <-time.After(pool.settings.CallTimeout):
+			pool.lock.Lock()
+			pc.inFlight--
+			pool.drop(pc)
+			pool.lock.Unlock()
+			err = errTimedOut
+			return
+		}
+		pool.release(pc)
+		if !broken(err) {
+			return
+		}
+
+		pool.lock.Lock()
+		pool.drop(pc)
+		if err == rpc.ErrShutdown && attempt == 0 {
+			// The connection was already broken when the call was
+			// made, so the request never left and it is safe to
+			// try again.
+			pool.stats.Reconnects++
+			pool.lock.Unlock()
+			continue
+		}
+		pool.lock.Unlock()
+		return
+	}
+	return
+}
+
+// checkHealth runs in the background until the pool is closed, evicting idle
+// connections and pinging the ones that are still in use.
+func (pool *connPool) checkHealth() {
+	for {
+		select {
+		case <-pool.closed:
+			return
+		case <-time.After(pool.settings.HealthCheckInterval):
+		}
+
+		var idle []*pooledClient
+		pool.lock.Lock()
+		for _, pc := range pool.clients {
+			if pc.inFlight != 0 {
+				continue
+			}
+			if time.Since(pc.lastUsed) > pool.settings.IdleTimeout {
+				pool.drop(pc)
+				pool.stats.Evictions++
+				continue
+			}
+			idle = append(idle, pc)
+		}
+		pool.lock.Unlock()
+
+		for _, pc := range idle {
+			var healthy bool
+			c := pc.client.Go(healthCheckProc, struct{}{}, &struct{}{}, make(chan *rpc.Call, 1))
+			select {
+			case <-c.Done:
+				healthy = !broken(c.Error)
+			case <-time.After(pool.settings.CallTimeout):
+			}
+			if !healthy {
+				pool.lock.Lock()
+				pool.drop(pc)
+				pool.stats.FailedHealthChecks++
+				pool.lock.Unlock()
+			}
+		}
+	}
+}
+
+// close closes every connection in the pool and stops the health checks.
+func (pool *connPool) close() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	select {
+	case <-pool.closed:
+		return
+	default:
+	}
+	close(pool.closed)
+	for _, pc := range pool.clients {
+		pool.drop(pc)
+	}
+}
+
+// connectionStats returns the pool's counters.
+func (pool *connPool) connectionStats() (stats ConnectionStats) {
+	pool.lock.Lock()
+	stats = pool.stats
+	stats.Open = len(pool.clients)
+	pool.lock.Unlock()
+	return
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPoolTestServers creates two RPCServers on a loopback network, with a
+// TestStoreHandler registered on the second one. The first server's pool uses
+// 'settings'.
+func newPoolTestServers(t *testing.T, settings PoolSettings) (ln *LoopbackNetwork, rpcs1 *RPCServer, rpcs2 *RPCServer, m Message) {
+	ln = NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1, WithPoolSettings(settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcs2, err = NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = Message{
+		Dest: rpcs2.RegisterHandler(new(TestStoreHandler)),
+		Proc: "TestStoreHandler.StoreMessage",
+		Args: "hello, world!",
+	}
+	return
+}
+
+// TestConnectionPool checks that concurrent messages to a peer share one
+// connection, and that a broken connection is replaced.
+func TestConnectionPool(t *testing.T) {
+	ln, rpcs1, rpcs2, m := newPoolTestServers(t, DefaultPoolSettings)
+	defer rpcs1.Close()
+	defer rpcs2.Close()
+
+	err := rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rpcs1.SendMessage(m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := rpcs1.ConnectionStats()
+	if stats.Dials != 1 || stats.Open != 1 {
+		t.Fatal("expecting a single pooled connection, got", stats)
+	}
+
+	// Break the connection. The next message should find the connection
+	// broken and retry on a new one.
+	ln.Partition("host2")
+	ln.Heal()
+	time.Sleep(10 * time.Millisecond)
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats = rpcs1.ConnectionStats()
+	if stats.Dials != 2 || stats.Reconnects != 1 || stats.Open != 1 {
+		t.Error("expecting the broken connection to be replaced, got", stats)
+	}
+}
+
+// TestConnectionPoolHealth checks that idle connections are evicted, and that
+// connections which stop answering are closed by the health checks.
+func TestConnectionPoolHealth(t *testing.T) {
+	settings := PoolSettings{
+		HealthCheckInterval: 20 * time.Millisecond,
+		IdleTimeout:         100 * time.Millisecond,
+		CallTimeout:         100 * time.Millisecond,
+	}
+	_, rpcs1, rpcs2, m := newPoolTestServers(t, settings)
+	defer rpcs1.Close()
+	defer rpcs2.Close()
+
+	// A healthy connection is kept until it has been idle for too long.
+	err := rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(settings.IdleTimeout / 2)
+	if rpcs1.ConnectionStats().Open != 1 {
+		t.Fatal("healthy connection was closed early")
+	}
+	time.Sleep(settings.IdleTimeout + 2*settings.HealthCheckInterval)
+	stats := rpcs1.ConnectionStats()
+	if stats.Evictions != 1 || stats.Open != 0 || stats.FailedHealthChecks != 0 {
+		t.Fatal("expecting the idle connection to be evicted, got", stats)
+	}
+
+	// A connection that doesn't answer pings is closed.
+	settings.IdleTimeout = time.Hour
+	ln, rpcs3, rpcs4, m := newPoolTestServers(t, settings)
+	defer rpcs3.Close()
+	defer rpcs4.Close()
+	err = rpcs3.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetLatency(2 * settings.CallTimeout)
+	time.Sleep(2*settings.HealthCheckInterval + 3*settings.CallTimeout)
+	stats = rpcs3.ConnectionStats()
+	if stats.FailedHealthChecks == 0 || stats.Open != 0 {
+		t.Error("expecting the unresponsive connection to fail its health check, got", stats)
+	}
+}
+
+// TestConnectionPoolTimeout checks that a call that times out drops its
+// connection instead of returning it to the pool.
+func TestConnectionPoolTimeout(t *testing.T) {
+	settings := DefaultPoolSettings
+	settings.CallTimeout = 50 * time.Millisecond
+	ln, rpcs1, rpcs2, m := newPoolTestServers(t, settings)
+	defer rpcs1.Close()
+	defer rpcs2.Close()
+
+	err := rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetLatency(2 * settings.CallTimeout)
+	err = rpcs1.SendMessage(m)
+	if err != errTimedOut {
+		t.Fatal("expecting errTimedOut, got", err)
+	}
+	if rpcs1.ConnectionStats().Open != 0 {
+		t.Error("timed out connection was returned to the pool")
+	}
+}
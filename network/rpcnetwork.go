@@ -1,17 +1,15 @@
 package network
 
 import (
-	"bytes"
-	"errors"
 	"net"
-	"net/http"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
 )
 
 var timeout = time.Second * 5
@@ -24,14 +22,43 @@ func addrString(a Address) string {
 
 // An RPCServer handles all RPCs for a given hostname and port. It routes each
 // RPC according to its Identifier. Objects must register themselves with the
-// RPCServer in order to receive an Address. This implementation currently uses
-// the JSON codec over TCP.
+// RPCServer in order to receive an Address. This implementation uses the JSON
+// codec over encrypted connections of a Transport, which is TCP unless the
+// server is created with NewRPCServerWithTransport.
 type RPCServer struct {
-	addr     Address
-	rpcServ  *rpc.Server
-	listener net.Listener
-	curID    Identifier
-	idLock   sync.Mutex
+	addr       Address
+	rpcServ    *rpc.Server
+	transport  Transport
+	pool       *connPool
+	limits     *limiter
+	listener   net.Listener
+	curID      Identifier
+	identities map[Identifier]identity
+	book       *AddressBook
+	idLock     sync.Mutex
+
+	// authorizers holds the Authorizer of each handler that has one.
+	authorizers map[Identifier]Authorizer
+
+	// peerKeys holds the public keys that handlers must prove before they
+	// are sent any messages.
+	peerKeys map[Address]siacrypto.PublicKey
+	peerLock sync.RWMutex
+}
+
+// rpcServerName is the name of the RPCs that every RPCServer answers itself,
+// under Identifier 0.
+var rpcServerName = "RPCServer" + string(Identifier(0))
+
+// rpcServerHandler serves the RPCs that every RPCServer answers itself.
+type rpcServerHandler struct {
+	rpcs *RPCServer
+}
+
+// Ping does nothing, the reply is proof enough that the connection works. It
+// is used to check the health of pooled connections.
+func (*rpcServerHandler) Ping(_ struct{}, _ *struct{}) error {
+	return nil
 }
 
 // RegisterHandler registers a message handler to the RPC server. The handler
@@ -47,48 +74,82 @@ func (rpcs *RPCServer) RegisterHandler(handler interface{}) Address {
 	return Address{rpcs.addr.Host, rpcs.addr.Port, id}
 }
 
+// Address returns the address of the RPCServer itself. The host changes if
+// the server learns a new hostname, see LearnHostname.
+func (rpcs *RPCServer) Address() Address {
+	rpcs.idLock.Lock()
+	defer rpcs.idLock.Unlock()
+	return rpcs.addr
+}
+
 // NewRPCServer creates and initializes a server that listens for TCP
 // connections on a specified port. It then spawns a serverHandler with a
 // specified message. It is the caller's responsibility to close the TCP
 // listener, via RPCServer.Close().
 func NewRPCServer(port uint16) (rpcs *RPCServer, err error) {
-	tcpServ, err := net.Listen("tcp", ":"+strconv.Itoa(int(port)))
+	return NewRPCServerWithTransport(TCPTransport{}, port)
+}
+
+// serverOptions holds the settings that can be changed when an RPCServer is
+// created.
+type serverOptions struct {
+	pool PoolSettings
+}
+
+// A ServerOption changes a setting of an RPCServer as it is created.
+type ServerOption func(*serverOptions)
+
+// WithPoolSettings creates the server's connection pool with 'settings'
+// instead of DefaultPoolSettings.
+func WithPoolSettings(settings PoolSettings) ServerOption {
+	return func(so *serverOptions) {
+		so.pool = settings
+	}
+}
+
+// NewRPCServerWithTransport creates and initializes a server that listens for
+// connections on a port of the given transport, and uses the transport for
+// all of the messages that it sends. The options change the settings of the
+// server, see WithPoolSettings. It is the caller's responsibility to close the
+// listener, via RPCServer.Close().
+func NewRPCServerWithTransport(transport Transport, port uint16, options ...ServerOption) (rpcs *RPCServer, err error) {
+	so := serverOptions{pool: DefaultPoolSettings}
+	for _, option := range options {
+		option(&so)
+	}
+	listener, err := transport.Listen(port)
 	if err != nil {
 		return
 	}
 
-	// The server's hostname is initially set to localhost. This can be updated
-	// by calling LearnHostname().
+	// The server's hostname is initially set by the transport, which is
+	// localhost for TCP. This can be updated by calling LearnHostname(), which
+	// asks other RPCServers where our connections come from.
 	rpcs = &RPCServer{
-		addr:     Address{"localhost", port, 0},
-		rpcServ:  rpc.NewServer(),
-		listener: tcpServ,
-		curID:    1, // ID 0 is reserved for the RPCServer itself
+		addr:       Address{transport.Host(), port, 0},
+		rpcServ:    rpc.NewServer(),
+		transport:  transport,
+		limits:     newLimiter(),
+		listener:   listener,
+		curID:      1, // ID 0 is reserved for the RPCServer itself
+		identities: make(map[Identifier]identity),
+		peerKeys:   make(map[Address]siacrypto.PublicKey),
+
+		authorizers: make(map[Identifier]Authorizer),
 	}
+	rpcs.pool = newConnPool(rpcs.dialSecure, so.pool)
+	rpcs.rpcServ.RegisterName(rpcServerName, &rpcServerHandler{rpcs})
 
 	go rpcs.serverHandler()
 	return
 }
 
-// Close closes the connection associated with the TCP server. This causes
-// tcpServ.Accept() to return an err, ending the serverHandler process.
+// Close closes the listener associated with the server. This causes
+// listener.Accept() to return an err, ending the serverHandler process. The
+// pooled client connections of the server are closed as well.
 func (rpcs *RPCServer) Close() {
 	rpcs.listener.Close()
-}
-
-// LearnHostname determines the external IP of the RPCserver by asking an
-// external source.  This will be updated in the future to ask a Participant
-// instead of a third-party service.
-func (rpcs *RPCServer) LearnHostname() (err error) {
-	resp, err := http.Get("http://myexternalip.com/raw")
-	if err != nil {
-		return
-	}
-	var buf bytes.Buffer
-	buf.ReadFrom(resp.Body)
-	resp.Body.Close()
-	rpcs.addr.Host = strings.Trim(buf.String(), "\n")
-	return
+	rpcs.pool.close()
 }
 
 // serverHandler runs in the background, accepting incoming RPCs and serving
@@ -101,47 +162,54 @@ func (rpcs *RPCServer) serverHandler() {
 		if err != nil {
 			return
 		}
-		go rpcs.rpcServ.ServeCodec(jsonrpc.NewServerCodec(conn))
+		go rpcs.serveConn(conn)
 	}
 }
 
-// Ping calls the Participant.Ping method on the specified address. Note that
-// neither Ping nor any of the SendMessage functions really need to be methods
-// of RPCServer. This may change in the future.
-func (rpcs *RPCServer) Ping(a Address) error {
-	conn, err := jsonrpc.Dial("tcp", addrString(a))
+// ConnectionStats returns the counters of the server's connection pool.
+func (rpcs *RPCServer) ConnectionStats() ConnectionStats {
+	return rpcs.pool.connectionStats()
+}
+
+// serveConn runs the listener's side of the handshake on a new connection,
+// and then serves RPCs over it with the JSON codec until it is closed. Every
+// request is checked against the server's limits and the Authorizer of its
+// handler before it is handled.
+func (rpcs *RPCServer) serveConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	sc, err := rpcs.acceptHandshake(conn)
 	if err != nil {
-		return err
+		conn.Close()
+		return
 	}
-	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+	host := remoteHost(conn)
+	rpcs.rpcServ.ServeCodec(&observingCodec{
+		ServerCodec: &authorizingCodec{
+			ServerCodec: newLimitingCodec(sc, rpcs.limits, host),
+			rpcs:        rpcs,
+			peerKey:     sc.peerKey,
+		},
+		remoteHost: host,
+	})
+}
 
-	select {
-	case call := <-conn.Go("Participant"+string(a.ID)+".Ping", struct{}{}, nil, nil).Done:
-		return call.Error
-	case <-time.After(timeout):
+// Ping calls the Participant.Ping method on the specified address, using the
+// server's pooled connection to the address.
+func (rpcs *RPCServer) Ping(a Address) error {
+	err := rpcs.pool.call(a, 0, "Participant"+string(a.ID)+".Ping", struct{}{}, nil)
+	if err == errTimedOut {
 		return nil
 	}
+	return err
 }
 
 // SendMessage synchronously delivers a Message to its recipient and returns
 // any errors. It times out after waiting for 'timeout' seconds.
 func (rpcs *RPCServer) SendMessage(m Message) error {
-	conn, err := jsonrpc.Dial("tcp", addrString(m.Dest))
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
 	// add identifier to service name
 	name := strings.Replace(m.Proc, ".", string(m.Dest.ID)+".", 1)
-
-	// send message
-	select {
-	case call := <-conn.Go(name, m.Args, m.Resp, nil).Done:
-		return call.Error
-	case <-time.After(timeout):
-		return errors.New("request timed out")
-	}
+	return rpcs.pool.call(m.Dest, m.Source, name, m.Args, m.Resp)
 }
 
 // SendAsyncMessage (asynchronously) delivers a Message to its recipient. It
@@ -149,27 +217,8 @@ func (rpcs *RPCServer) SendMessage(m Message) error {
 // completes. Like SendMessage, it times out after 'timeout' seconds.
 func (rpcs *RPCServer) SendAsyncMessage(m Message) chan error {
 	errChan := make(chan error, 2)
-	conn, err := jsonrpc.Dial("tcp", addrString(m.Dest))
-	if err != nil {
-		errChan <- err
-		return errChan
-	}
-
-	// add identifier to service name
-	name := strings.Replace(m.Proc, ".", string(m.Dest.ID)+".", 1)
-
-	// send message
 	go func() {
-		defer conn.Close()
-		select {
-		case call := <-conn.Go(name, m.Args, m.Resp, nil).Done:
-			errChan <- call.Error
-			return
-		case <-time.After(timeout):
-			errChan <- errors.New("request timed out")
-			return
-		}
+		errChan <- rpcs.SendMessage(m)
 	}()
-
 	return errChan
 }
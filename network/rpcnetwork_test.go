@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 	"time"
 )
@@ -10,13 +11,23 @@ import (
 // Its methods are intended to test various RPC functions.
 type TestStoreHandler struct {
 	message string
+	lock    sync.Mutex
 }
 
 func (tsh *TestStoreHandler) StoreMessage(message string, _ *struct{}) error {
+	tsh.lock.Lock()
 	tsh.message = message
+	tsh.lock.Unlock()
 	return nil
 }
 
+// stored returns the last message that was stored by the handler.
+func (tsh *TestStoreHandler) stored() string {
+	tsh.lock.Lock()
+	defer tsh.lock.Unlock()
+	return tsh.message
+}
+
 func (tsh *TestStoreHandler) BlockForever(message string, _ *struct{}) error {
 	select {}
 }
@@ -48,20 +59,20 @@ func TestRPCSendMessage(t *testing.T) {
 		t.Fatal("Failed to send message:", err)
 	}
 
-	if tsh.message != "hello, world!" {
-		t.Fatal("Bad response: expected \"hello, world!\", got \"" + tsh.message + "\"")
+	if tsh.stored() != "hello, world!" {
+		t.Fatal("Bad response: expected \"hello, world!\", got \"" + tsh.stored() + "\"")
 	}
 
 	// send a message asynchronously
-	tsh.message = ""
+	tsh.StoreMessage("", nil)
 	errChan := rpcs.SendAsyncMessage(m)
 	err = <-errChan
 	if err != nil {
 		t.Fatal("Failed to send message:", err)
 	}
 
-	if tsh.message != "hello, world!" {
-		t.Fatal("Bad response: expected \"hello, world!\", got \"" + tsh.message + "\"")
+	if tsh.stored() != "hello, world!" {
+		t.Fatal("Bad response: expected \"hello, world!\", got \"" + tsh.stored() + "\"")
 	}
 }
 
@@ -96,7 +107,7 @@ func TestRPCTimeout(t *testing.T) {
 	}
 
 	// send a message asynchronously
-	tsh.message = ""
+	tsh.StoreMessage("", nil)
 	errChan := rpcs.SendAsyncMessage(m)
 	if <-errChan == nil {
 		t.Fatal("SendAsyncMessage did not timeout")
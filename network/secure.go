@@ -0,0 +1,466 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+)
+
+// Every connection between RPCServers is encrypted, and both sides prove the
+// public key of the handler that they stand for: the listener proves the key
+// of the handler that the connection was opened for, and the dialer proves
+// the key of the handler that the connection sends messages on behalf of,
+// see Message.Source. The handshake goes as follows:
+//
+//	dialer   -> listener: the Identifier being dialed, and an ephemeral
+//	                      X25519 public key
+//	listener -> dialer:   an ephemeral X25519 public key
+//	listener -> dialer:   (encrypted) the handler's public key, and a
+//	                      signature of the handshake transcript
+//	dialer   -> listener: (encrypted) the source's public key, and a
+//	                      signature of the handshake transcript
+//
+// Both sides derive one AES-GCM key for each direction from the shared secret
+// of the ephemeral keys and the transcript, so that everything after the
+// ephemeral keys is confidential. The signatures cover the ephemeral keys of
+// both sides, which binds both keys to this connection alone, and each side
+// signs under its own tag so that one side's proof can't be replayed as the
+// other's.
+//
+// A handler that has no identity answers anonymously, with an empty public
+// key. The dialer checks the answer against the key it expects for the
+// address, if it expects one, and refuses the connection on a mismatch.
+// Likewise, a message from a source without an identity is sent anonymously.
+// Handlers decide which peers may call them with an Authorizer, which sees
+// the key that the dialer proved.
+
+const (
+	// maxFramePlaintext is the largest amount of data that is encrypted into
+	// a single frame.
+	maxFramePlaintext = 1 << 16
+
+	// frameHeaderSize is the size of the length prefix of every frame.
+	frameHeaderSize = 4
+
+	// handshakeAuthSize is the size of the message in which the listener
+	// proves its identity.
+	handshakeAuthSize = siacrypto.PublicKeySize + siacrypto.SignatureSize
+)
+
+var (
+	listenerTag = []byte("sia rpc listener")
+	dialerTag   = []byte("sia rpc dialer")
+
+	errBadFrame     = errors.New("secure channel received a malformed frame")
+	errBadSignature = errors.New("peer's handshake signature is invalid")
+	errPeerMismatch = errors.New("peer did not prove the public key expected for its address")
+)
+
+// An Authorizer decides whether a peer may make a call to a handler. 'proc'
+// is the procedure being called, without the handler's Identifier, 'peer' is
+// the public key that the caller proved when it connected, which is empty for
+// anonymous callers, and 'args' holds the decoded arguments of the call. A
+// call that is refused is answered with the returned error.
+type Authorizer func(proc string, peer siacrypto.PublicKey, args interface{}) error
+
+// An identity is the key pair that a handler proves when it is dialed.
+type identity struct {
+	publicKey siacrypto.PublicKey
+	secretKey siacrypto.SecretKey
+}
+
+// secureConn is a connection that encrypts everything written to it, in
+// frames of at most maxFramePlaintext bytes. Each direction has its own key,
+// and frames are numbered so that they can't be replayed or reordered.
+type secureConn struct {
+	net.Conn
+	peerKey siacrypto.PublicKey
+
+	send      cipher.AEAD
+	sendNonce uint64
+	sendLock  sync.Mutex
+
+	recv      cipher.AEAD
+	recvNonce uint64
+	recvBuf   []byte
+}
+
+// newAEAD returns AES-GCM with the given key.
+func newAEAD(key siacrypto.Hash) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce turns a frame number into a GCM nonce.
+func nonce(aead cipher.AEAD, counter uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], counter)
+	return n
+}
+
+// writeFrame encrypts and writes a single frame. writeFrame requires the send
+// lock.
+func (sc *secureConn) writeFrame(plaintext []byte) (err error) {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(plaintext)+sc.send.Overhead())
+	frame = sc.send.Seal(frame, nonce(sc.send, sc.sendNonce), plaintext, nil)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-frameHeaderSize))
+	sc.sendNonce++
+	_, err = sc.Conn.Write(frame)
+	return
+}
+
+// readFrame reads and decrypts a single frame.
+func (sc *secureConn) readFrame() (plaintext []byte, err error) {
+	header := make([]byte, frameHeaderSize)
+	_, err = io.ReadFull(sc.Conn, header)
+	if err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < uint32(sc.recv.Overhead()) || length > uint32(maxFramePlaintext+sc.recv.Overhead()) {
+		err = errBadFrame
+		return
+	}
+	ciphertext := make([]byte, length)
+	_, err = io.ReadFull(sc.Conn, ciphertext)
+	if err != nil {
+		return
+	}
+	plaintext, err = sc.recv.Open(ciphertext[:0], nonce(sc.recv, sc.recvNonce), ciphertext, nil)
+	if err != nil {
+		err = errBadFrame
+		return
+	}
+	sc.recvNonce++
+	return
+}
+
+// Read reads decrypted data from the connection.
+func (sc *secureConn) Read(b []byte) (n int, err error) {
+	for len(sc.recvBuf) == 0 {
+		sc.recvBuf, err = sc.readFrame()
+		if err != nil {
+			return
+		}
+	}
+	n = copy(b, sc.recvBuf)
+	sc.recvBuf = sc.recvBuf[n:]
+	return
+}
+
+// Write encrypts 'b' and writes it to the connection.
+func (sc *secureConn) Write(b []byte) (n int, err error) {
+	sc.sendLock.Lock()
+	defer sc.sendLock.Unlock()
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxFramePlaintext {
+			chunk = chunk[:maxFramePlaintext]
+		}
+		err = sc.writeFrame(chunk)
+		if err != nil {
+			return
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
+
+// newSecureConn derives the keys of a connection from the handshake and
+// wraps the connection. 'dialer' is true on the side that opened the
+// connection.
+func newSecureConn(conn net.Conn, secret siacrypto.Hash, transcript siacrypto.Hash, dialer bool) (sc *secureConn, err error) {
+	deriveKey := func(direction byte) siacrypto.Hash {
+		material := append(append(secret[:], transcript[:]...), direction)
+		return siacrypto.HashBytes(material)
+	}
+	sendKey, recvKey := deriveKey('d'), deriveKey('l')
+	if !dialer {
+		sendKey, recvKey = recvKey, sendKey
+	}
+
+	sc = &secureConn{Conn: conn}
+	sc.send, err = newAEAD(sendKey)
+	if err != nil {
+		return
+	}
+	sc.recv, err = newAEAD(recvKey)
+	return
+}
+
+// handshakeTranscript returns the hash of everything that was exchanged in
+// the clear.
+func handshakeTranscript(id Identifier, dialerKey, listenerKey siacrypto.ExchangePublicKey) siacrypto.Hash {
+	transcript := append([]byte{byte(id)}, dialerKey[:]...)
+	transcript = append(transcript, listenerKey[:]...)
+	return siacrypto.HashBytes(transcript)
+}
+
+// handshakeProof returns the message that one side of the handshake signs to
+// prove its identity.
+func handshakeProof(tag []byte, transcript siacrypto.Hash) []byte {
+	return append(append([]byte{}, tag...), transcript[:]...)
+}
+
+// proveIdentity returns the message in which the handler with Identifier 'id'
+// proves its identity, or an anonymous message if the handler has none.
+func (rpcs *RPCServer) proveIdentity(id Identifier, tag []byte, transcript siacrypto.Hash) (auth []byte, err error) {
+	rpcs.idLock.Lock()
+	ident, exists := rpcs.identities[id]
+	rpcs.idLock.Unlock()
+	auth = make([]byte, handshakeAuthSize)
+	if !exists {
+		return
+	}
+	sig, err := ident.secretKey.Sign(handshakeProof(tag, transcript))
+	if err != nil {
+		return
+	}
+	copy(auth, ident.publicKey[:])
+	copy(auth[siacrypto.PublicKeySize:], sig[:])
+	return
+}
+
+// checkIdentity reads the message in which the other side of the handshake
+// proves its identity, returning the public key that was proven. An empty key
+// means that the other side is anonymous.
+func (sc *secureConn) checkIdentity(tag []byte, transcript siacrypto.Hash) (err error) {
+	auth, err := sc.readFrame()
+	if err != nil {
+		return
+	}
+	if len(auth) != handshakeAuthSize {
+		err = errBadFrame
+		return
+	}
+	copy(sc.peerKey[:], auth)
+	var sig siacrypto.Signature
+	copy(sig[:], auth[siacrypto.PublicKeySize:])
+	if sc.peerKey != (siacrypto.PublicKey{}) && !sc.peerKey.Verify(sig, handshakeProof(tag, transcript)) {
+		err = errBadSignature
+	}
+	return
+}
+
+// SetIdentity sets the key pair that the handler with Identifier 'id' proves
+// to peers that dial it, and to peers that it sends messages to. Pooled
+// connections that were opened on behalf of the handler are closed, so that
+// the next message proves the new identity.
+func (rpcs *RPCServer) SetIdentity(id Identifier, pk siacrypto.PublicKey, sk siacrypto.SecretKey) {
+	rpcs.idLock.Lock()
+	rpcs.identities[id] = identity{pk, sk}
+	rpcs.idLock.Unlock()
+	rpcs.pool.dropSource(id)
+}
+
+// RegisterIdentity reserves an Identifier that messages can be sent from with
+// the key pair 'pk' and 'sk', for senders that don't receive any messages and
+// so have no handler to register.
+func (rpcs *RPCServer) RegisterIdentity(pk siacrypto.PublicKey, sk siacrypto.SecretKey) (id Identifier) {
+	rpcs.idLock.Lock()
+	id = rpcs.curID
+	rpcs.curID++
+	rpcs.identities[id] = identity{pk, sk}
+	rpcs.idLock.Unlock()
+	return
+}
+
+// SetAuthorizer sets the Authorizer that every call to the handler with
+// Identifier 'id' must pass before it reaches the handler.
+func (rpcs *RPCServer) SetAuthorizer(id Identifier, authorize Authorizer) {
+	rpcs.idLock.Lock()
+	rpcs.authorizers[id] = authorize
+	rpcs.idLock.Unlock()
+}
+
+// authorize checks a call to 'method' from 'peer' against the Authorizer of
+// the handler being called, if it has one.
+func (rpcs *RPCServer) authorize(method string, peer siacrypto.PublicKey, args interface{}) (err error) {
+	rpcs.idLock.Lock()
+	authorize, exists := rpcs.authorizers[handlerID(method)]
+	rpcs.idLock.Unlock()
+	if exists {
+		err = authorize(procedureName(method), peer, args)
+	}
+	return
+}
+
+// ExpectPeer sets the public key that the handler at 'a' must prove before
+// any message is sent to it. If the expected key is new or has changed, the
+// pooled connections to the address are closed so that the next message runs
+// the handshake again.
+func (rpcs *RPCServer) ExpectPeer(a Address, pk siacrypto.PublicKey) {
+	rpcs.peerLock.Lock()
+	previous, exists := rpcs.peerKeys[a]
+	rpcs.peerKeys[a] = pk
+	rpcs.peerLock.Unlock()
+	if !exists || previous != pk {
+		rpcs.pool.dropAddress(a)
+	}
+}
+
+// ForgetPeer stops expecting the handler at 'a' to prove 'pk', if that is the
+// key that is expected for the address. A different key that was set for the
+// address since is kept.
+func (rpcs *RPCServer) ForgetPeer(a Address, pk siacrypto.PublicKey) {
+	rpcs.peerLock.Lock()
+	defer rpcs.peerLock.Unlock()
+	if expected, exists := rpcs.peerKeys[a]; exists && expected == pk {
+		delete(rpcs.peerKeys, a)
+	}
+}
+
+// dialSecure opens a connection to 'a' over the server's transport and runs
+// the dialer's side of the handshake, proving the identity of 'source'.
+func (rpcs *RPCServer) dialSecure(a Address, source Identifier) (conn net.Conn, err error) {
+	raw, err := rpcs.transport.Dial(a)
+	if err != nil {
+		return
+	}
+	raw.SetDeadline(time.Now().Add(timeout))
+	sc, err := rpcs.dialHandshake(raw, a, source)
+	if err != nil {
+		raw.Close()
+		return
+	}
+	raw.SetDeadline(time.Time{})
+	conn = sc
+	return
+}
+
+// dialHandshake runs the dialer's side of the handshake.
+func (rpcs *RPCServer) dialHandshake(raw net.Conn, a Address, source Identifier) (sc *secureConn, err error) {
+	ephemeralPK, ephemeralSK, err := siacrypto.CreateExchangeKeyPair()
+	if err != nil {
+		return
+	}
+	_, err = raw.Write(append([]byte{byte(a.ID)}, ephemeralPK[:]...))
+	if err != nil {
+		return
+	}
+	var listenerPK siacrypto.ExchangePublicKey
+	_, err = io.ReadFull(raw, listenerPK[:])
+	if err != nil {
+		return
+	}
+
+	secret, err := ephemeralSK.SharedSecret(listenerPK)
+	if err != nil {
+		return
+	}
+	transcript := handshakeTranscript(a.ID, ephemeralPK, listenerPK)
+	sc, err = newSecureConn(raw, secret, transcript, true)
+	if err != nil {
+		return
+	}
+
+	// Check the listener's proof of identity before proving our own.
+	err = sc.checkIdentity(listenerTag, transcript)
+	if err != nil {
+		return
+	}
+	rpcs.peerLock.RLock()
+	expected, exists := rpcs.peerKeys[a]
+	rpcs.peerLock.RUnlock()
+	if exists && sc.peerKey != expected {
+		err = errPeerMismatch
+		return
+	}
+
+	auth, err := rpcs.proveIdentity(source, dialerTag, transcript)
+	if err != nil {
+		return
+	}
+	sc.sendLock.Lock()
+	err = sc.writeFrame(auth)
+	sc.sendLock.Unlock()
+	return
+}
+
+// acceptHandshake runs the listener's side of the handshake, proving the
+// identity of the handler that was dialed and checking the identity of the
+// dialer.
+func (rpcs *RPCServer) acceptHandshake(raw net.Conn) (sc *secureConn, err error) {
+	hello := make([]byte, 1+siacrypto.ExchangeKeySize)
+	_, err = io.ReadFull(raw, hello)
+	if err != nil {
+		return
+	}
+	id := Identifier(hello[0])
+	var dialerPK siacrypto.ExchangePublicKey
+	copy(dialerPK[:], hello[1:])
+
+	ephemeralPK, ephemeralSK, err := siacrypto.CreateExchangeKeyPair()
+	if err != nil {
+		return
+	}
+	_, err = raw.Write(ephemeralPK[:])
+	if err != nil {
+		return
+	}
+
+	secret, err := ephemeralSK.SharedSecret(dialerPK)
+	if err != nil {
+		return
+	}
+	transcript := handshakeTranscript(id, dialerPK, ephemeralPK)
+	sc, err = newSecureConn(raw, secret, transcript, false)
+	if err != nil {
+		return
+	}
+
+	// Prove the identity of the handler, or answer anonymously if the
+	// handler has none.
+	auth, err := rpcs.proveIdentity(id, listenerTag, transcript)
+	if err != nil {
+		return
+	}
+	sc.sendLock.Lock()
+	err = sc.writeFrame(auth)
+	sc.sendLock.Unlock()
+	if err != nil {
+		return
+	}
+	err = sc.checkIdentity(dialerTag, transcript)
+	return
+}
+
+// authorizingCodec is a server codec that checks every call against the
+// Authorizer of the handler being called, using the public key that the
+// dialer proved during the handshake.
+type authorizingCodec struct {
+	rpc.ServerCodec
+	rpcs    *RPCServer
+	peerKey siacrypto.PublicKey
+	method  string
+}
+
+// ReadRequestHeader reads the header of the next request, remembering which
+// method is being called.
+func (ac *authorizingCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	err = ac.ServerCodec.ReadRequestHeader(r)
+	ac.method = r.ServiceMethod
+	return
+}
+
+// ReadRequestBody reads the arguments of the request and refuses the call if
+// the caller is not authorized to make it.
+func (ac *authorizingCodec) ReadRequestBody(body interface{}) (err error) {
+	err = ac.ServerCodec.ReadRequestBody(body)
+	if err != nil || body == nil {
+		return
+	}
+	return ac.rpcs.authorize(ac.method, ac.peerKey, body)
+}
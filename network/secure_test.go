@@ -0,0 +1,190 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siacrypto"
+)
+
+// recordingTransport records everything written to the connections that it
+// dials, to check what an eavesdropper would see.
+type recordingTransport struct {
+	Transport
+	written bytes.Buffer
+	lock    sync.Mutex
+}
+
+type recordingConn struct {
+	net.Conn
+	rt *recordingTransport
+}
+
+func (rt *recordingTransport) Dial(a Address) (net.Conn, error) {
+	conn, err := rt.Transport.Dial(a)
+	if err != nil {
+		return nil, err
+	}
+	return recordingConn{conn, rt}, nil
+}
+
+func (rc recordingConn) Write(b []byte) (int, error) {
+	rc.rt.lock.Lock()
+	rc.rt.written.Write(b)
+	rc.rt.lock.Unlock()
+	return rc.Conn.Write(b)
+}
+
+// TestSecureChannel checks that messages are encrypted in transit, and that a
+// message is only sent to a handler that proves the expected public key.
+func TestSecureChannel(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rt := &recordingTransport{Transport: ln.Transport("host1")}
+	rpcs1, err := NewRPCServerWithTransport(rt, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	rpcs2, err := NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs2.Close()
+
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPK, _, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsh := new(TestStoreHandler)
+	addr := rpcs2.RegisterHandler(tsh)
+	rpcs2.SetIdentity(addr.ID, pk, sk)
+
+	// Send a message while expecting the right key.
+	secret := "the quick brown fox jumps over the lazy dog"
+	rpcs1.ExpectPeer(addr, pk)
+	err = rpcs1.SendMessage(Message{Dest: addr, Proc: "TestStoreHandler.StoreMessage", Args: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsh.stored() != secret {
+		t.Fatal("message was not delivered")
+	}
+	rt.lock.Lock()
+	if rt.written.Len() == 0 || bytes.Contains(rt.written.Bytes(), []byte(secret)) {
+		t.Error("message was not encrypted in transit")
+	}
+	rt.lock.Unlock()
+
+	// Expecting a different key should drop the connection and refuse to
+	// send the message.
+	tsh.StoreMessage("", nil)
+	rpcs1.ExpectPeer(addr, otherPK)
+	err = rpcs1.SendMessage(Message{Dest: addr, Proc: "TestStoreHandler.StoreMessage", Args: secret})
+	if err != errPeerMismatch {
+		t.Error("expecting errPeerMismatch, got", err)
+	}
+	if tsh.stored() != "" {
+		t.Error("message was delivered to a peer with the wrong key")
+	}
+
+	// A handler without an identity can't satisfy an expected key, but can
+	// still be reached when no key is expected.
+	anonymous := rpcs2.RegisterHandler(new(TestStoreHandler))
+	err = rpcs1.SendMessage(Message{Dest: anonymous, Proc: "TestStoreHandler.StoreMessage", Args: ""})
+	if err != nil {
+		t.Error(err)
+	}
+	rpcs1.ExpectPeer(anonymous, pk)
+	err = rpcs1.SendMessage(Message{Dest: anonymous, Proc: "TestStoreHandler.StoreMessage", Args: ""})
+	if err != errPeerMismatch {
+		t.Error("expecting errPeerMismatch, got", err)
+	}
+}
+
+// TestDialerIdentity checks that a handler's Authorizer sees the key that the
+// dialer proved, so that calls can be limited to known peers.
+func TestDialerIdentity(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs1, err := NewRPCServerWithTransport(ln.Transport("host1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs1.Close()
+	rpcs2, err := NewRPCServerWithTransport(ln.Transport("host2"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs2.Close()
+
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errUnknownPeer := errors.New("unknown peer")
+	tsh := new(TestStoreHandler)
+	addr := rpcs2.RegisterHandler(tsh)
+	rpcs2.SetAuthorizer(addr.ID, func(proc string, peer siacrypto.PublicKey, args interface{}) error {
+		if proc == "TestStoreHandler.StoreMessage" && peer != pk {
+			return errUnknownPeer
+		}
+		return nil
+	})
+
+	// An anonymous message is refused.
+	m := Message{Dest: addr, Proc: "TestStoreHandler.StoreMessage", Args: "anonymous"}
+	err = rpcs1.SendMessage(m)
+	if err == nil || err.Error() != errUnknownPeer.Error() {
+		t.Error("expecting the anonymous message to be refused, got", err)
+	}
+
+	// A message from a source that proves the key is accepted.
+	m.Source = rpcs1.RegisterIdentity(pk, sk)
+	m.Args = "authenticated"
+	err = rpcs1.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsh.stored() != "authenticated" {
+		t.Error("authenticated message was not delivered")
+	}
+
+	// A message from a source with a different key is refused.
+	otherPK, otherSK, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Source = rpcs1.RegisterIdentity(otherPK, otherSK)
+	m.Args = "impostor"
+	err = rpcs1.SendMessage(m)
+	if err == nil || tsh.stored() != "authenticated" {
+		t.Error("message from the wrong key was delivered:", err)
+	}
+}
+
+// TestSecureConnLargeWrite sends a message larger than a single frame.
+func TestSecureConnLargeWrite(t *testing.T) {
+	ln := NewLoopbackNetwork()
+	rpcs, err := NewRPCServerWithTransport(ln.Transport("host"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcs.Close()
+
+	tsh := new(TestStoreHandler)
+	addr := rpcs.RegisterHandler(tsh)
+	large := string(bytes.Repeat([]byte{'a'}, 3*maxFramePlaintext+17))
+	err = rpcs.SendMessage(Message{Dest: addr, Proc: "TestStoreHandler.StoreMessage", Args: large})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsh.stored() != large {
+		t.Error("large message was corrupted")
+	}
+}
@@ -0,0 +1,36 @@
+package network
+
+import (
+	"net"
+	"strconv"
+)
+
+// A Transport carries the connections of an RPCServer. The RPCServer listens
+// for incoming connections on a port of the transport and dials a new
+// connection for every message that it sends. Host is the hostname that the
+// RPCServer gives out in its Addresses.
+type Transport interface {
+	Listen(port uint16) (net.Listener, error)
+	Dial(a Address) (net.Conn, error)
+	Host() string
+}
+
+// TCPTransport is the Transport used by default, carrying connections over
+// TCP.
+type TCPTransport struct{}
+
+// Listen opens a TCP listener on 'port'.
+func (TCPTransport) Listen(port uint16) (net.Listener, error) {
+	return net.Listen("tcp", ":"+strconv.Itoa(int(port)))
+}
+
+// Dial opens a TCP connection to the host and port of 'a'.
+func (TCPTransport) Dial(a Address) (net.Conn, error) {
+	return net.Dial("tcp", addrString(a))
+}
+
+// Host returns "localhost". The external hostname can be found by calling
+// RPCServer.LearnHostname().
+func (TCPTransport) Host() string {
+	return "localhost"
+}
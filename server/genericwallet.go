@@ -24,8 +24,9 @@ type GenericWalletID state.WalletID
 // A GenericWallet is a transportable struct which points to a wallet in the
 // quorum of id 'id'. The wallet is assumed to have a generic script body which
 // uses 'PublicKey' as the public key. The data stored in the wallet's sector
-// is assumed to have a 'K' value of 'state.StandardK', and is assumed to
-// decode to a file exactly 'OriginalFileSize' bytes on disk.
+// is assumed to have a 'K' value of 'state.StandardK' and a 'D' value of
+// 'state.StandardD', and is assumed to decode to a file exactly
+// 'OriginalFileSize' bytes on disk.
 type GenericWallet struct {
 	WalletID state.WalletID
 
@@ -33,6 +34,10 @@ type GenericWallet struct {
 	SecretKey siacrypto.SecretKey
 
 	OriginalFileSize int64
+
+	// source is the Identifier that the server sends messages from when it
+	// needs to prove the wallet's public key, such as when uploading.
+	source network.Identifier
 }
 
 // Helper function on GenericWallet that calculates and returns the id to the
@@ -119,7 +124,7 @@ func (s *Server) GenericDownload(gdp GenericDownloadParams, _ *struct{}) (err er
 	}
 
 	// Recover the StandardK segments into the file.
-	_, err = state.RSRecover(segments, indices, file, state.StandardK)
+	_, err = state.RecoverSector(segments, indices, file, state.StandardK, state.StandardD)
 	if err != nil {
 		return
 	}
@@ -201,13 +206,14 @@ func (s *Server) GenericUpload(gup GenericUploadParams, _ *struct{}) (err error)
 	// Create basic sector update.
 	su := state.SectorUpdate{
 		K: state.StandardK,
+		D: state.StandardD,
 		ConfirmationsRequired: state.StandardConfirmations,
 	}
 	su.Event.Deadline = s.metadata.Height + 5
 
 	// Create segments for the encoder output.
 	segments := make([][]byte, state.QuorumSize)
-	atoms, err := state.RSEncode(file, segments, state.StandardK)
+	atoms, err := state.EncodeSector(file, segments, su.K, su.D)
 	if err != nil {
 		return
 	}
@@ -237,7 +243,11 @@ func (s *Server) GenericUpload(gup GenericUploadParams, _ *struct{}) (err error)
 	// Wait 3 blocks while the update gets accepted.
 	time.Sleep(consensus.StepDuration * time.Duration(state.QuorumSize) * 3)
 
-	// Upload each segment to its respective sibling.
+	// Upload each segment to its respective sibling, proving to the
+	// siblings that the upload comes from the owner of the wallet.
+	if gw.source == 0 {
+		gw.source = s.router.RegisterIdentity(gw.PublicKey, gw.SecretKey)
+	}
 	var successes byte
 	for i := range segments {
 		// Create a segment upload for the sibling of index 'i'.
@@ -249,10 +259,11 @@ func (s *Server) GenericUpload(gup GenericUploadParams, _ *struct{}) (err error)
 
 		var accepted bool
 		sendErr := s.router.SendMessage(network.Message{
-			Dest: s.metadata.Siblings[i].Address,
-			Proc: "Participant.UploadSegment",
-			Args: segmentUpload,
-			Resp: &accepted,
+			Dest:   s.metadata.Siblings[i].Address,
+			Source: gw.source,
+			Proc:   "Participant.UploadSegment",
+			Args:   segmentUpload,
+			Resp:   &accepted,
 		})
 		if sendErr == nil {
 			successes++
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 
+	"github.com/NebulousLabs/Sia/network"
 	"github.com/NebulousLabs/Sia/siafiles"
 
 	"code.google.com/p/gcfg"
@@ -13,11 +14,17 @@ type Config struct {
 	Network struct {
 		Port             uint16
 		PublicConnection bool
+
+		// Bootstrap lists the servers that are contacted to learn the
+		// hostname and to find the rest of the network, one per line
+		// as "host:port".
+		Bootstrap []string
 	}
 
 	Filesystem struct {
 		ParticipantDir string
 		WalletDir      string
+		AddressBook    string
 	}
 }
 
@@ -58,8 +65,19 @@ func start(cmd *cobra.Command, args []string) {
 	fmt.Println("Starting Sia Server...")
 	s := newServer()
 
+	// Parse the bootstrap servers.
+	var bootstrap []network.Address
+	for _, b := range config.Network.Bootstrap {
+		address, err := network.ParseAddress(b)
+		if err != nil {
+			fmt.Printf("Bad bootstrap address %q: %v\n", b, err)
+			return
+		}
+		bootstrap = append(bootstrap, address)
+	}
+
 	// Connect the server, which will prepare it to listen for rpc's.
-	err := s.connect(config.Network.Port, config.Network.PublicConnection)
+	err := s.connect(config.Network.Port, config.Network.PublicConnection, bootstrap, config.Filesystem.AddressBook)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -70,6 +88,8 @@ func start(cmd *cobra.Command, args []string) {
 	fmt.Println("Public Connection:", config.Network.PublicConnection)
 	fmt.Println("Participant Directory:", config.Filesystem.ParticipantDir)
 	fmt.Println("Wallet Directory:", config.Filesystem.WalletDir)
+	fmt.Println("Address Book:", config.Filesystem.AddressBook)
+	fmt.Println("Hostname:", s.address.Host)
 
 	// Let the server run indefinitely.
 	select {}
@@ -96,6 +116,11 @@ func parseConfigFile() (err error) {
 		fmt.Println(err)
 		return
 	}
+	config.Filesystem.AddressBook, err = siafiles.HomeFilename("addressbook")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	// Parse the config file if it exists.
 	if siafiles.Exists(configLocation) {
@@ -142,6 +167,10 @@ func main() {
 	// If none is specified, use the homedir.
 	root.Flags().StringVarP(&config.Filesystem.WalletDir, "wallet-directory", "w", config.Filesystem.WalletDir, "Which directory wallets will be loaded from and saved to.")
 
+	// Use the config file struct to determine the default address book
+	// file. If none is specified, use the homedir.
+	root.Flags().StringVarP(&config.Filesystem.AddressBook, "address-book", "a", config.Filesystem.AddressBook, "Which file the addresses of other nodes are saved to.")
+
 	version := &cobra.Command{
 		Use:   "version",
 		Short: "Print version information",
@@ -149,5 +178,6 @@ func main() {
 	}
 
 	root.AddCommand(version)
+	root.AddCommand(traceCommand())
 	root.Execute()
 }
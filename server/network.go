@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/NebulousLabs/Sia/network"
 	"github.com/NebulousLabs/Sia/state"
@@ -37,6 +39,7 @@ func (s *Server) connectToQuorum(connectAddress network.Address) (err error) {
 		return
 	}
 	s.metadata.Siblings = metadata.Siblings
+	s.rememberSiblings()
 	return
 }
 
@@ -68,6 +71,55 @@ func (s *Server) refreshMetadata() (err error) {
 	}
 
 	s.metadata = metadata
+	s.rememberSiblings()
 
 	return
 }
+
+// discoverPeers downloads the address book of each of 'servers' and merges it
+// into the server's own. Servers that can't be reached are skipped.
+func (s *Server) discoverPeers(servers []network.Address) {
+	for _, server := range servers {
+		entries, err := s.router.FetchPeers(server)
+		if err != nil {
+			continue
+		}
+		s.addressBook.Seen(server, network.PeerServer)
+		s.addressBook.Merge(entries)
+	}
+}
+
+// hostnameInterval is how often a public server asks its peers for its
+// hostname again, so that a change of IP is noticed.
+var hostnameInterval = 10 * time.Minute
+
+// relearnHostname asks the servers in the address book for the server's
+// hostname every hostnameInterval. Participants compare the router's address
+// against the address that the quorum has on record each time they send a
+// heartbeat, so a new hostname is announced to the quorum as soon as it is
+// learned. relearnHostname never returns.
+func (s *Server) relearnHostname() {
+	for {
+		time.Sleep(hostnameInterval)
+		old := s.router.Address().Host
+		err := s.router.LearnHostname(s.addressBook.Addresses(network.PeerServer))
+		if err != nil {
+			continue
+		}
+		if host := s.router.Address().Host; host != old {
+			fmt.Printf("Hostname changed from %v to %v\n", old, host)
+		}
+	}
+}
+
+// rememberSiblings adds the siblings in the server's metadata to the address
+// book, which is how the addresses of participants spread through the
+// network.
+func (s *Server) rememberSiblings() {
+	for _, sibling := range s.metadata.Siblings {
+		if sibling.Address.Host == "" {
+			continue
+		}
+		s.addressBook.Seen(sibling.Address, network.PeerParticipant)
+	}
+}
@@ -19,8 +19,9 @@ type ParticipantManager struct {
 }
 
 type NewParticipantInfo struct {
-	Name      string
-	SiblingID state.WalletID
+	Name       string
+	SiblingID  state.WalletID
+	Passphrase string
 
 	UseUniqueDirectory bool
 	UniqueDirectory    string
@@ -80,7 +81,7 @@ func (s *Server) NewBootstrapParticipant(npi NewParticipantInfo, _ *struct{}) (e
 	}
 
 	// Create the participant and add it to the server map.
-	newParticipant, err := consensus.CreateBootstrapParticipant(s.router, dirname, npi.SiblingID, pk)
+	newParticipant, err := consensus.CreateBootstrapParticipant(s.router, dirname, npi.Passphrase, npi.SiblingID, pk)
 	if err != nil {
 		return
 	}
@@ -129,7 +130,7 @@ func (s *Server) NewJoiningParticipant(npi NewParticipantInfo, _ *struct{}) (err
 		siblingAddresses = append(siblingAddresses, sibling.Address)
 	}
 
-	joiningParticipant, err := consensus.CreateJoiningParticipant(s.router, dirname, npi.SiblingID, s.genericWallets[GenericWalletID(npi.SiblingID)].SecretKey, siblingAddresses)
+	joiningParticipant, err := consensus.CreateJoiningParticipant(s.router, dirname, npi.Passphrase, npi.SiblingID, s.genericWallets[GenericWalletID(npi.SiblingID)].SecretKey, siblingAddresses)
 	if err != nil {
 		return
 	}
@@ -16,9 +16,10 @@ import (
 // the network.
 type Server struct {
 	// Networking Variables
-	router   *network.RPCServer
-	address  network.Address
-	metadata state.Metadata
+	router      *network.RPCServer
+	address     network.Address
+	metadata    state.Metadata
+	addressBook *network.AddressBook
 
 	// Generic Wallets
 	// A pointer to the generic wallet type is stored because we wish to
@@ -29,24 +30,47 @@ type Server struct {
 	participantManager *ParticipantManager
 }
 
-// connect creates a router for the server, learning a public hostname if the
-// flag is set.
-func (s *Server) connect(port uint16, learnHostname bool) (err error) {
+// connect creates a router for the server and loads the address book,
+// adding the bootstrap servers to it. Every server in the book is asked for
+// its own address book, and if 'learnHostname' is set, the servers are also
+// asked which hostname our connections come from, now and periodically
+// after, see relearnHostname.
+func (s *Server) connect(port uint16, learnHostname bool, bootstrap []network.Address, addressBookFilename string) (err error) {
 	// Create a router.
 	s.router, err = network.NewRPCServer(port)
 	if err != nil {
 		return
 	}
 
-	// Register with the router, calling LearnHostname() if we wish to be
-	// available to the public.
-	if learnHostname {
-		err = s.router.LearnHostname()
+	// Load the address book and share it with the rest of the network.
+	s.addressBook, err = network.NewAddressBook(addressBookFilename)
+	if err != nil {
+		return
+	}
+	for _, address := range bootstrap {
+		err = s.addressBook.Add(address, network.PeerServer)
 		if err != nil {
 			return
 		}
 	}
+	s.router.SetAddressBook(s.addressBook)
+
+	// Learn a hostname before registering with the router if we wish to be
+	// available to the public, since the hostname is part of the address
+	// given out by RegisterHandler.
+	servers := s.addressBook.Addresses(network.PeerServer)
+	if learnHostname {
+		// Without anyone to ask, the transport's own hostname is the
+		// best guess.
+		err = s.router.LearnHostname(servers)
+		if err != nil {
+			fmt.Printf("Could not learn hostname, using %v: %v\n", s.router.Address().Host, err)
+			err = nil
+		}
+		go s.relearnHostname()
+	}
 	s.address = s.router.RegisterHandler(s)
+	s.discoverPeers(servers)
 
 	// Create a participant manager.
 	s.participantManager, err = newParticipantManager()
@@ -27,7 +27,7 @@ func TestUploadAndRepair(t *testing.T) {
 
 	// Initialize a client.
 	s := newServer()
-	err := s.connect(14000, false)
+	err := s.connect(14000, false, nil, "")
 	if err != nil {
 		// a 'local only' error gets returned, which is intentional.
 		// t.Fatal(err)
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/NebulousLabs/Sia/delta"
+	"github.com/NebulousLabs/Sia/state"
+
+	"github.com/spf13/cobra"
+)
+
+// The trace command runs a script and an input, both written in assembly, in
+// a wallet of their own, and prints every instruction that runs. Nothing is
+// sent to the quorum; the run is a dry run, see delta.DryRunWallet. With
+// --step, the trace is printed one instruction at a time.
+
+var (
+	traceScriptFile string
+	traceInputFile  string
+	traceBalance    uint64
+	traceStep       bool
+)
+
+// assembleFile reads a file of assembly and turns it into bytecode.
+func assembleFile(filename string) (b []byte, err error) {
+	if filename == "" {
+		return
+	}
+	words, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	b, err = delta.WordsToBytes(string(words))
+	if err != nil {
+		err = fmt.Errorf("%s: %v", filename, err)
+	}
+	return
+}
+
+// trace runs the script and input given by the flags, and prints the trace.
+func trace(cmd *cobra.Command, args []string) {
+	script, err := assembleFile(traceScriptFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	input, err := assembleFile(traceInputFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	w := state.Wallet{
+		ID:      1,
+		Balance: state.NewBalance(traceBalance),
+		Script:  script,
+	}
+	t, err := delta.DryRunWallet(w, state.Metadata{}, state.ScriptInput{Input: input})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// When stepping, wait for enter after each instruction, and stop
+	// early if the user enters 'q'.
+	stdin := bufio.NewReader(os.Stdin)
+	for i, step := range t.Steps {
+		fmt.Print(step)
+		if traceStep && i < len(t.Steps)-1 {
+			line, _ := stdin.ReadString('\n')
+			if line == "q\n" {
+				return
+			}
+		} else {
+			fmt.Println()
+		}
+	}
+
+	if t.Err != "" {
+		fmt.Println("Script failed:", t.Err)
+	} else {
+		fmt.Println("Script succeeded.")
+	}
+	fmt.Println("Cost used:", t.CostUsed)
+}
+
+// traceCommand returns the trace command and its flags.
+func traceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trace",
+		Short: "Print every instruction that a script runs",
+		Long:  "Run a wallet script and an input, both written in assembly, without sending anything to the quorum, and print every instruction that runs along with the stack, registers and resources left after it.",
+		Run:   trace,
+	}
+	cmd.Flags().StringVarP(&traceScriptFile, "script", "s", "", "File holding the wallet script.")
+	cmd.Flags().StringVarP(&traceInputFile, "input", "i", "", "File holding the script input.")
+	cmd.Flags().Uint64VarP(&traceBalance, "balance", "b", 0, "Balance of the wallet.")
+	cmd.Flags().BoolVarP(&traceStep, "step", "S", false, "Wait for enter after printing each instruction.")
+	return cmd
+}
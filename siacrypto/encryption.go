@@ -0,0 +1,80 @@
+package siacrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+const (
+	// EncryptionKeySize is the size of an EncryptionKey in bytes.
+	EncryptionKeySize = 32
+
+	// keyDerivationRounds is the number of times that a passphrase is
+	// hashed when deriving an encryption key, which slows down attempts to
+	// guess the passphrase.
+	keyDerivationRounds = 1 << 14
+)
+
+var (
+	ErrDecryptionFailed = errors.New("ciphertext could not be decrypted")
+)
+
+// An EncryptionKey is a symmetric key used to encrypt data that is saved to
+// disk. Data is encrypted with AES-256 in GCM mode, which means that data
+// encrypted with the wrong key or tampered with on disk fails to decrypt.
+type EncryptionKey [EncryptionKeySize]byte
+
+// DeriveEncryptionKey turns a passphrase into an encryption key. The salt
+// should be random and saved alongside the encrypted data.
+func DeriveEncryptionKey(passphrase []byte, salt []byte) (key EncryptionKey) {
+	saltedPassphrase := make([]byte, 0, len(salt)+len(passphrase))
+	saltedPassphrase = append(saltedPassphrase, salt...)
+	saltedPassphrase = append(saltedPassphrase, passphrase...)
+	h := HashBytes(saltedPassphrase)
+	for i := 0; i < keyDerivationRounds; i++ {
+		h = HashBytes(append(h[:], salt...))
+	}
+	key = EncryptionKey(h)
+	return
+}
+
+// EncryptBytes encrypts the plaintext, returning a ciphertext that is prefixed
+// with a random nonce.
+func (key EncryptionKey) EncryptBytes(plaintext []byte) (ciphertext []byte, err error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	nonce := RandomByteSlice(aead.NonceSize())
+	ciphertext = aead.Seal(nonce, nonce, plaintext, nil)
+	return
+}
+
+// DecryptBytes decrypts a ciphertext produced by EncryptBytes, returning
+// ErrDecryptionFailed if the key is wrong or the ciphertext has been
+// modified.
+func (key EncryptionKey) DecryptBytes(ciphertext []byte) (plaintext []byte, err error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		err = ErrDecryptionFailed
+		return
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err = aead.Open(nil, nonce, ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		err = ErrDecryptionFailed
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package siacrypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestEncryption encrypts and decrypts some data, and checks that decryption
+// fails with the wrong key or a modified ciphertext.
+func TestEncryption(t *testing.T) {
+	salt := RandomByteSlice(16)
+	key := DeriveEncryptionKey([]byte("passphrase"), salt)
+	if key != DeriveEncryptionKey([]byte("passphrase"), salt) {
+		t.Fatal("key derivation is not deterministic")
+	}
+
+	plaintext := RandomByteSlice(100)
+	ciphertext, err := key.EncryptBytes(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := key.DecryptBytes(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("decrypted data does not match the plaintext")
+	}
+
+	// Decrypt with the wrong key.
+	wrongKey := DeriveEncryptionKey([]byte("wrong"), salt)
+	_, err = wrongKey.DecryptBytes(ciphertext)
+	if err != ErrDecryptionFailed {
+		t.Error("expecting ErrDecryptionFailed for the wrong key, got", err)
+	}
+
+	// Decrypt a modified ciphertext.
+	ciphertext[len(ciphertext)-1]++
+	_, err = key.DecryptBytes(ciphertext)
+	if err != ErrDecryptionFailed {
+		t.Error("expecting ErrDecryptionFailed for a modified ciphertext, got", err)
+	}
+	_, err = key.DecryptBytes(nil)
+	if err != ErrDecryptionFailed {
+		t.Error("expecting ErrDecryptionFailed for an empty ciphertext, got", err)
+	}
+}
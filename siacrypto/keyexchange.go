@@ -0,0 +1,48 @@
+package siacrypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+)
+
+// ExchangeKeySize is the size of the keys used for key exchange, in bytes.
+const ExchangeKeySize = 32
+
+// An ExchangePublicKey is the public half of an X25519 key exchange key pair.
+type ExchangePublicKey [ExchangeKeySize]byte
+
+// An ExchangeSecretKey is the secret half of an X25519 key exchange key pair.
+// Exchange keys are meant to be ephemeral, and are separate from the signing
+// keys that identify participants.
+type ExchangeSecretKey [ExchangeKeySize]byte
+
+// CreateExchangeKeyPair creates a new X25519 key pair.
+func CreateExchangeKeyPair() (pk ExchangePublicKey, sk ExchangeSecretKey, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	copy(sk[:], key.Bytes())
+	copy(pk[:], key.PublicKey().Bytes())
+	return
+}
+
+// SharedSecret combines the secret key with the other party's public key,
+// producing the same secret that the other party gets by combining their
+// secret key with this key's public key.
+func (sk ExchangeSecretKey) SharedSecret(pk ExchangePublicKey) (secret Hash, err error) {
+	key, err := ecdh.X25519().NewPrivateKey(sk[:])
+	if err != nil {
+		return
+	}
+	peerKey, err := ecdh.X25519().NewPublicKey(pk[:])
+	if err != nil {
+		return
+	}
+	shared, err := key.ECDH(peerKey)
+	if err != nil {
+		return
+	}
+	secret = HashBytes(shared)
+	return
+}
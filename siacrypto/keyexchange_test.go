@@ -0,0 +1,41 @@
+package siacrypto
+
+import (
+	"testing"
+)
+
+// TestKeyExchange checks that both parties of a key exchange arrive at the
+// same secret, and that a third party doesn't.
+func TestKeyExchange(t *testing.T) {
+	pk1, sk1, err := CreateExchangeKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk2, sk2, err := CreateExchangeKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sk3, err := CreateExchangeKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret1, err := sk1.SharedSecret(pk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret2, err := sk2.SharedSecret(pk1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret1 != secret2 {
+		t.Fatal("key exchange produced different secrets")
+	}
+	secret3, err := sk3.SharedSecret(pk1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret3 == secret1 {
+		t.Error("third party arrived at the shared secret")
+	}
+}
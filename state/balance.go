@@ -55,6 +55,14 @@ func (a *Balance) Multiply(b Balance) {
 	copy(a[:], siaencoding.EncUint128(x.Mul(x, y)))
 }
 
+// Divide performs integer division on two Balances, rounding down. Dividing
+// by a zero Balance panics.
+func (a *Balance) Divide(b Balance) {
+	x := siaencoding.DecUint128(a[:])
+	y := siaencoding.DecUint128(b[:])
+	copy(a[:], siaencoding.EncUint128(x.Div(x, y)))
+}
+
 // Compare returns an integer comparing two Balances.
 // It returns 1 if a > b, -1 if a < b, and 0 if a == b
 func (a *Balance) Compare(b Balance) int {
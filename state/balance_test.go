@@ -30,4 +30,9 @@ func TestOperations(t *testing.T) {
 	if a.Compare(a2) != 0 {
 		t.Fatal("multiplication failed")
 	}
+
+	a.Divide(b)
+	if a.Compare(NewBalance(^uint64(0))) != 0 {
+		t.Fatal("division failed")
+	}
 }
@@ -39,7 +39,8 @@ func (s *State) chargeWallets(wn *walletNode, multiplier int) (quorumWeight uint
 
 // ExecuteCompensation is called between each block. Money is deducted from
 // wallets according to how much storage they are using, and money is added to
-// siblings according to how much storage is in use.
+// siblings according to how much storage is in use, along with the fees paid
+// by the script inputs of the block.
 func (s *State) ExecuteCompensation() {
 	if s.walletRoot == nil {
 		return
@@ -59,9 +60,18 @@ func (s *State) ExecuteCompensation() {
 	// have been deleted by chargeWallets.
 	quorumWeight := s.chargeWallets(s.walletRoot, siblings)
 
-	// Compensate each sibling.
+	// Compensate each sibling, splitting the script fees of the block
+	// between the siblings. Whatever can't be split evenly is kept for the
+	// next block.
 	compensation := s.Metadata.StoragePrice
 	compensation.Multiply(NewBalance(quorumWeight))
+	if siblings > 0 {
+		feeShare := s.Metadata.ScriptFees
+		feeShare.Divide(NewBalance(uint64(siblings)))
+		compensation.Add(feeShare)
+		feeShare.Multiply(NewBalance(uint64(siblings)))
+		s.Metadata.ScriptFees.Subtract(feeShare)
+	}
 	for i := range s.Metadata.Siblings {
 		if !s.Metadata.Siblings[i].Active() {
 			continue
@@ -181,3 +181,33 @@ func TestExecuteCompensation(t *testing.T) {
 		t.Error("sibling did not have expected balance after compensation when a wallet was deleted")
 	}
 }
+
+// TestScriptFees checks that the script fees of a block are split evenly
+// between the active siblings, and that the remainder is kept.
+func TestScriptFees(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.SetWalletPrefix(siafiles.TempFilename("TestScriptFees"))
+
+	// Add two siblings, whose compensation for storing each other's
+	// wallets cancels out their storage charges.
+	for i := 0; i < 2; i++ {
+		s.InsertWallet(Wallet{ID: WalletID(i), Balance: NewBalance(100)}, true)
+		s.Metadata.Siblings[i] = Sibling{Status: 0, Index: byte(i), WalletID: WalletID(i)}
+	}
+	s.Metadata.ScriptFees = NewBalance(7)
+	s.ExecuteCompensation()
+
+	for i := 0; i < 2; i++ {
+		w, err := s.LoadWallet(WalletID(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Balance != NewBalance(103) {
+			t.Error("sibling", i, "did not get its share of the script fees:", w.Balance)
+		}
+	}
+	if s.Metadata.ScriptFees != NewBalance(1) {
+		t.Error("expecting the remainder of the script fees to be kept, got", s.Metadata.ScriptFees)
+	}
+}
@@ -108,3 +108,141 @@ loop:
 
 	return
 }
+
+// RegeneratingSymbolSize is the size of the symbols used by the regenerating
+// code. Each atom of a segment holds the two symbols that the segment stores
+// for one stripe of the sector.
+const RegeneratingSymbolSize = AtomSize / 2
+
+var errBadRegenerating = errors.New("sector settings do not match the regenerating code")
+
+// regenerating returns true if a sector with the given 'k' and 'd' is encoded
+// with the regenerating code instead of Reed-Solomon coding. A sector selects
+// the regenerating code by setting 'd' to the number of helpers that the code
+// repairs from, and stays on Reed-Solomon coding while 'd' is 0. New sectors
+// can't use any other 'd', see checkCode, but sectors that were stored with
+// another 'd' before it selected the code keep using Reed-Solomon coding.
+func regenerating(k byte, d byte) bool {
+	return QuorumSize == erasure.RegeneratingN &&
+		k == erasure.RegeneratingK &&
+		d == erasure.RegeneratingD
+}
+
+// Regenerating returns true if the sector is encoded with the regenerating
+// code, see regenerating.
+func (s Sector) Regenerating() bool {
+	return regenerating(s.K, s.D)
+}
+
+// checkCode returns an error if a new sector can't use the code selected by
+// 'k' and 'd'. A 'd' of 0 selects Reed-Solomon coding, and any other 'd' has
+// to select the regenerating code, so that a mistyped 'd' can't quietly leave
+// the sector on Reed-Solomon coding.
+func checkCode(k byte, d byte) (err error) {
+	if d != 0 && !regenerating(k, d) {
+		err = errBadRegenerating
+	}
+	return
+}
+
+// EncodeSector encodes 'input' into 'segments' using the code selected by 'k'
+// and 'd', returning the number of atoms per segment.
+func EncodeSector(input io.Reader, segments [][]byte, k byte, d byte) (atoms uint16, err error) {
+	if !regenerating(k, d) {
+		return RSEncode(input, segments, int(k))
+	}
+	if input == nil {
+		err = errors.New("received nil input")
+		return
+	}
+
+	// Each stripe of the regenerating code produces a single atom on every
+	// segment.
+	stripe := make([]byte, AtomSize*int(k))
+	for n, readErr := input.Read(stripe); readErr == nil || n > 0; atoms++ {
+		if atoms == AtomsPerSector {
+			err = errors.New("exceeded max atoms per sector")
+			return
+		}
+
+		var encodedSegments [][]byte
+		encodedSegments, err = erasure.RegeneratingEncode(stripe, RegeneratingSymbolSize)
+		if err != nil {
+			return
+		}
+		for i := range segments {
+			segments[i] = append(segments[i], encodedSegments[i]...)
+		}
+
+		stripe = make([]byte, AtomSize*int(k))
+		n, readErr = input.Read(stripe)
+	}
+
+	if atoms == 0 {
+		err = errors.New("no data read from reader")
+	}
+	return
+}
+
+// RecoverSector decodes 'k' segments into 'output' using the code selected by
+// 'k' and 'd', returning the number of atoms per segment.
+func RecoverSector(segments []io.Reader, indices []byte, output io.Writer, k byte, d byte) (atoms uint16, err error) {
+	if !regenerating(k, d) {
+		return RSRecover(segments, indices, output, int(k))
+	}
+	if len(segments) < int(k) || len(indices) < int(k) {
+		err = errors.New("insufficient input segments to recover sector")
+		return
+	}
+	if output == nil {
+		err = errors.New("cannot write to nil output")
+		return
+	}
+
+	atomsSlice := make([][]byte, k)
+	for i := range atomsSlice {
+		if segments[i] == nil {
+			err = fmt.Errorf("Reader %v is nil", i)
+			return
+		}
+		atomsSlice[i] = make([]byte, AtomSize)
+	}
+
+loop:
+	for {
+		for i := range atomsSlice {
+			n, _ := segments[i].Read(atomsSlice[i])
+			if n != AtomSize {
+				break loop
+			}
+		}
+
+		var stripe []byte
+		stripe, err = erasure.RegeneratingRecover(atomsSlice, indices[:k], RegeneratingSymbolSize)
+		if err != nil {
+			return
+		}
+		output.Write(stripe)
+		atoms++
+	}
+
+	if atoms == 0 {
+		err = errors.New("failed to read an atom from one or more Readers")
+	}
+	return
+}
+
+// RepairData returns the data that the sibling at index 'helper' sends to
+// help rebuild the segment of the sibling at index 'lost', for a sector that
+// uses the regenerating code. The repair data is half the size of the
+// segment.
+func RepairData(segment []byte, helper byte, lost byte) (data []byte, err error) {
+	return erasure.RegeneratingHelperData(segment, helper, lost, RegeneratingSymbolSize)
+}
+
+// RepairSegment rebuilds the segment of the sibling at index 'lost' from the
+// repair data of erasure.RegeneratingD helpers. helpers[i] is the index of
+// the sibling that sent data[i].
+func RepairSegment(data [][]byte, helpers []byte, lost byte) (segment []byte, err error) {
+	return erasure.RegeneratingRepair(data, helpers, lost, RegeneratingSymbolSize)
+}
@@ -49,3 +49,69 @@ func TestRSEncodeAndRSDecode(t *testing.T) {
 		i++
 	}
 }
+
+// TestRegeneratingSector encodes a sector with the regenerating code, recovers
+// it from two segments, and repairs each segment from the repair data of the
+// other siblings.
+func TestRegeneratingSector(t *testing.T) {
+	k, d := byte(StandardK), byte(StandardD)
+	if !(Sector{K: k, D: d}).Regenerating() || checkCode(k, d) != nil {
+		t.Fatal("expecting k = 2 and d = 3 to select the regenerating code")
+	}
+	if (Sector{K: k}).Regenerating() || checkCode(k, 0) != nil {
+		t.Fatal("expecting d = 0 to select Reed-Solomon coding")
+	}
+	// Sectors stored before d selected the code stay on Reed-Solomon coding,
+	// but new sectors can't be given a d that the regenerating code doesn't
+	// use.
+	if (Sector{K: k, D: 1}).Regenerating() {
+		t.Fatal("expecting d = 1 to keep a stored sector on Reed-Solomon coding")
+	}
+	if checkCode(k, 1) != errBadRegenerating {
+		t.Fatal("expecting d = 1 to be rejected for a new sector")
+	}
+
+	input := siacrypto.RandomByteSlice(5 * AtomSize * int(k))
+	segments := make([][]byte, QuorumSize)
+	atoms, err := EncodeSector(bytes.NewReader(input), segments, k, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atoms != 5 || len(segments[0]) != 5*AtomSize {
+		t.Fatal("unexpected segment size:", atoms, len(segments[0]))
+	}
+
+	// Recover the sector from the last two segments.
+	var output bytes.Buffer
+	_, err = RecoverSector([]io.Reader{bytes.NewReader(segments[3]), bytes.NewReader(segments[2])}, []byte{3, 2}, &output, k, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Bytes(), input) {
+		t.Error("recovered sector does not match the input")
+	}
+
+	// Repair each segment from the other three.
+	for lost := byte(0); lost < QuorumSize; lost++ {
+		var data [][]byte
+		var helpers []byte
+		for helper := byte(0); helper < QuorumSize; helper++ {
+			if helper == lost {
+				continue
+			}
+			repairData, err := RepairData(segments[helper], helper, lost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, repairData)
+			helpers = append(helpers, helper)
+		}
+		segment, err := RepairSegment(data, helpers, lost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(segment, segments[lost]) {
+			t.Error("segment", lost, "was not repaired correctly")
+		}
+	}
+}
@@ -10,11 +10,26 @@ import (
 // alternate encodings include wallets and events. This struct is meant to be
 // small and to be sent over the wire as a complete entity, without needing
 // to be broken up or buffered.
+//
+// Successors[i] is the hopeful that has been recruited to replace sibling i
+// once sibling i finishes departing. Rebuilds[i] tracks whether the segments
+// held by slot i have been lost and are being rebuilt.
+//
+// ScriptPrice is the amount that a wallet is charged for each unit of cost
+// used by its script, see the Resources section of the bytecode spec. It is
+// always the ScriptPrice constant for now.
+// ScriptFees holds the fees paid by the script inputs of the current block,
+// which are split between the active siblings during compensation.
 type Metadata struct {
-	Siblings [QuorumSize]Sibling
+	Siblings   [QuorumSize]Sibling
+	Hopefuls   [MaxHopefuls]Hopeful
+	Successors [QuorumSize]Hopeful
+	Rebuilds   [QuorumSize]Rebuild
 
 	EventCounter uint32
 	StoragePrice Balance
+	ScriptPrice  Balance
+	ScriptFees   Balance
 
 	ParentBlock    siacrypto.Hash
 	Height         uint32
@@ -0,0 +1,245 @@
+package state
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/siaencoding"
+)
+
+// An Overlay is a copy-on-write view of a State, used to make a set of
+// changes atomic. Reads fall through to the State until a wallet is written,
+// and every write is kept in the overlay until Commit is called. An overlay
+// that is never committed is simply thrown away, leaving the State untouched.
+//
+// The overlay holds a copy of the metadata, so siblings and hopefuls can be
+// changed through the Metadata field. Wallets and events are written to the
+// State in the order that they were written to the overlay. Events get their
+// counters from the overlay's metadata when they are written, so that the
+// copies of an event kept in a wallet and in the event list agree.
+//
+// An overlay can itself be overlaid, see Overlay.NewOverlay. Committing the
+// inner overlay writes its changes to the outer overlay instead of the state.
+//
+// A State must not be changed while it has an overlay that will be committed.
+// An overlay that is only read, such as the overlay of a dry run, can be made
+// with State.NewLockedOverlay instead, which lets the State change underneath
+// it.
+type Overlay struct {
+	Metadata Metadata
+
+	state    *State
+	lock     sync.Locker
+	parent   *Overlay
+	wallets  map[WalletID]Wallet
+	inserted map[WalletID]bool
+	order    []WalletID
+	events   []Event
+}
+
+// NewOverlay returns an overlay of the state with no changes.
+func (s *State) NewOverlay() *Overlay {
+	return &Overlay{
+		Metadata: s.Metadata,
+		state:    s,
+		wallets:  make(map[WalletID]Wallet),
+		inserted: make(map[WalletID]bool),
+	}
+}
+
+// NewLockedOverlay returns an overlay of the state with no changes, which
+// holds 'lock' whenever it reads from the state. The caller should hold 'lock'
+// while the overlay is created, and the overlay must never be committed.
+func (s *State) NewLockedOverlay(lock sync.Locker) (o *Overlay) {
+	o = s.NewOverlay()
+	o.lock = lock
+	return
+}
+
+// NewOverlay returns an overlay of the overlay with no changes. The new
+// overlay sees every change made to 'o', and its own changes are written to
+// 'o' when it is committed.
+func (o *Overlay) NewOverlay() *Overlay {
+	return &Overlay{
+		Metadata: o.Metadata,
+		state:    o.state,
+		parent:   o,
+		wallets:  make(map[WalletID]Wallet),
+		inserted: make(map[WalletID]bool),
+	}
+}
+
+// copyWallet returns a wallet that shares no memory with 'w', the same as a
+// wallet that has been saved to disk and loaded again.
+func copyWallet(w Wallet) (c Wallet, err error) {
+	encodedWallet, err := siaencoding.Marshal(w)
+	if err != nil {
+		return
+	}
+	err = siaencoding.Unmarshal(encodedWallet, &c)
+	return
+}
+
+// exists returns true if the wallet is in the overlay, or in whatever the
+// overlay is on top of.
+func (o *Overlay) exists(id WalletID) bool {
+	if _, exists := o.wallets[id]; exists {
+		return true
+	}
+	if o.parent != nil {
+		return o.parent.exists(id)
+	}
+	if o.lock != nil {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+	}
+	return o.state.walletNode(id) != nil
+}
+
+// write puts a copy of a wallet into the overlay.
+func (o *Overlay) write(w Wallet) (err error) {
+	c, err := copyWallet(w)
+	if err != nil {
+		return
+	}
+	if _, exists := o.wallets[w.ID]; !exists {
+		o.order = append(o.order, w.ID)
+	}
+	o.wallets[w.ID] = c
+	return
+}
+
+// LoadWallet returns the wallet as written to the overlay, or as found in
+// whatever the overlay is on top of if the overlay hasn't written it.
+func (o *Overlay) LoadWallet(id WalletID) (w Wallet, err error) {
+	c, exists := o.wallets[id]
+	if exists {
+		return copyWallet(c)
+	}
+	if o.parent != nil {
+		return o.parent.LoadWallet(id)
+	}
+	if o.lock != nil {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+	}
+	return o.state.LoadWallet(id)
+}
+
+// SaveWallet writes a wallet that already exists to the overlay.
+func (o *Overlay) SaveWallet(w Wallet) (err error) {
+	if !o.exists(w.ID) {
+		return fmt.Errorf("no wallet of that id exists: %v", w.ID)
+	}
+	return o.write(w)
+}
+
+// InsertWallet writes a new wallet to the overlay. It returns an error if the
+// wallet already exists in the overlay or the state. Only the events that are
+// added to the wallet through the overlay are put into the event list when
+// the overlay is committed.
+func (o *Overlay) InsertWallet(w Wallet) (err error) {
+	if o.exists(w.ID) {
+		err = errWalletExists
+		return
+	}
+	if w.KnownScripts == nil {
+		w.KnownScripts = make(map[string]ScriptInputEvent)
+	}
+	if w.Sector.ActiveUpdates == nil {
+		w.Sector.ActiveUpdates = make([]SectorUpdate, 0)
+	}
+	err = o.write(w)
+	if err != nil {
+		return
+	}
+	o.inserted[w.ID] = true
+	return
+}
+
+// insertEvent gives an event the next event counter, and keeps it to be put
+// into the event list when the overlay is committed.
+func (o *Overlay) insertEvent(e Event) {
+	e.SetCounter(o.Metadata.EventCounter)
+	o.Metadata.EventCounter++
+	o.events = append(o.events, e)
+}
+
+// InsertSectorUpdate adds an update to a wallet, see State.InsertSectorUpdate.
+// The event of the update is put into the event list when the overlay is
+// committed.
+func (o *Overlay) InsertSectorUpdate(w *Wallet, su SectorUpdate) (err error) {
+	su, err = o.Metadata.addSectorUpdate(w, su)
+	if err != nil {
+		return
+	}
+	o.insertEvent(&su.Event)
+	w.Sector.ActiveUpdates[len(w.Sector.ActiveUpdates)-1].Event = su.Event
+	return
+}
+
+// InsertScheduledInput schedules an input to the script of a wallet. The
+// event of the input is put into the event list when the overlay is
+// committed.
+func (o *Overlay) InsertScheduledInput(w *Wallet, si ScheduledInput) (err error) {
+	sie, err := o.Metadata.addScheduledInput(w, si)
+	if err != nil {
+		return
+	}
+	o.insertEvent(&sie)
+	w.ScheduledInputs[len(w.ScheduledInputs)-1] = sie
+	return
+}
+
+// UpdateAddress applies a signed address update to the metadata of the
+// overlay, see State.UpdateAddress.
+func (o *Overlay) UpdateAddress(sau SignedAddressUpdate) (err error) {
+	return o.Metadata.updateAddress(sau)
+}
+
+// Commit writes the changes in the overlay to the state, or to the outer
+// overlay if the overlay was made by Overlay.NewOverlay. The overlay should
+// not be used after it has been committed.
+func (o *Overlay) Commit() (err error) {
+	if o.parent != nil {
+		return o.commitToParent()
+	}
+
+	// The events of a wallet that was inserted into the overlay are
+	// already in the overlay's list of events, with the counters that the
+	// wallet holds, so the wallet is added without its events.
+	o.state.Metadata = o.Metadata
+	for _, id := range o.order {
+		if o.inserted[id] {
+			err = o.state.addWalletNode(o.wallets[id])
+			if err != nil {
+				return
+			}
+		}
+		err = o.state.SaveWallet(o.wallets[id])
+		if err != nil {
+			return
+		}
+	}
+	for _, e := range o.events {
+		o.state.InsertEvent(e, false)
+	}
+	return
+}
+
+// commitToParent writes the changes in the overlay to the outer overlay.
+func (o *Overlay) commitToParent() (err error) {
+	o.parent.Metadata = o.Metadata
+	for _, id := range o.order {
+		if o.inserted[id] {
+			err = o.parent.InsertWallet(o.wallets[id])
+		} else {
+			err = o.parent.SaveWallet(o.wallets[id])
+		}
+		if err != nil {
+			return
+		}
+	}
+	o.parent.events = append(o.parent.events, o.events...)
+	return
+}
@@ -0,0 +1,153 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// TestOverlay makes changes through an overlay, checking that the state is
+// untouched until the overlay is committed.
+func TestOverlay(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.SetWalletPrefix(siafiles.TempFilename("TestOverlay"))
+	err := s.InsertWallet(Wallet{ID: 1, Balance: NewBalance(100)}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := s.NewOverlay()
+	w, err := o.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Balance = NewBalance(60)
+	err = o.InsertSectorUpdate(&w, SectorUpdate{K: MinK, ConfirmationsRequired: MinConfirmations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.InsertWallet(Wallet{ID: 2, Balance: NewBalance(40)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.InsertWallet(Wallet{ID: 1})
+	if err != errWalletExists {
+		t.Error("expecting errWalletExists, got", err)
+	}
+	err = o.SaveWallet(Wallet{ID: 3})
+	if err == nil {
+		t.Error("able to save a wallet that doesn't exist")
+	}
+	o.Metadata.Height = 7
+
+	// The overlay sees its own changes.
+	w, err = o.LoadWallet(2)
+	if err != nil || w.Balance != NewBalance(40) {
+		t.Error("overlay does not see the wallet it inserted:", err)
+	}
+
+	// The state is untouched.
+	w, err = s.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Balance != NewBalance(100) || len(w.Sector.ActiveUpdates) != 0 {
+		t.Error("overlay changed a wallet before being committed")
+	}
+	if _, err = s.LoadWallet(2); err == nil {
+		t.Error("overlay inserted a wallet before being committed")
+	}
+	if s.Metadata.Height != 0 || s.Metadata.EventCounter != 0 {
+		t.Error("overlay changed the metadata before being committed")
+	}
+
+	// Commit the overlay.
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err = s.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Balance != NewBalance(60) || len(w.Sector.ActiveUpdates) != 1 {
+		t.Error("committed wallet was not saved:", w)
+	}
+	w, err = s.LoadWallet(2)
+	if err != nil || w.Balance != NewBalance(40) {
+		t.Error("committed wallet was not inserted:", err)
+	}
+	if s.Metadata.Height != 7 {
+		t.Error("committed metadata was not kept")
+	}
+	if s.Metadata.EventCounter != 1 || s.eventRoot == nil {
+		t.Error("sector update event was not put into the event list")
+	}
+}
+
+// TestNestedOverlay checks that an overlay of an overlay writes its changes
+// to the outer overlay, and not to the state.
+func TestNestedOverlay(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.SetWalletPrefix(siafiles.TempFilename("TestNestedOverlay"))
+	err := s.InsertWallet(Wallet{ID: 1, Balance: NewBalance(100)}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes of an inner overlay that is thrown away are lost.
+	outer := s.NewOverlay()
+	inner := outer.NewOverlay()
+	err = inner.InsertWallet(Wallet{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = outer.LoadWallet(2); err == nil {
+		t.Error("inner overlay inserted a wallet before being committed")
+	}
+
+	// Changes of a committed inner overlay reach the state along with the
+	// outer overlay.
+	inner = outer.NewOverlay()
+	w, err := inner.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Balance = NewBalance(60)
+	err = inner.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inner.InsertWallet(Wallet{ID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.Metadata.Height = 4
+	err = inner.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.LoadWallet(3); err == nil {
+		t.Error("inner overlay inserted a wallet into the state")
+	}
+	if outer.Metadata.Height != 4 {
+		t.Error("inner overlay did not commit its metadata")
+	}
+	err = outer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err = s.LoadWallet(1)
+	if err != nil || w.Balance != NewBalance(60) {
+		t.Error("committed wallet was not saved:", err)
+	}
+	if _, err = s.LoadWallet(3); err != nil {
+		t.Error("committed wallet was not inserted:", err)
+	}
+}
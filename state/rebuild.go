@@ -0,0 +1,94 @@
+package state
+
+// When a sibling is tossed, nobody holds its segments anymore, and every file
+// on the quorum loses a piece of its redundancy. The slot is marked as
+// needing a rebuild, and the next sibling placed into the slot is scheduled
+// to reconstruct the segment of every wallet from K of the other siblings.
+// While the rebuild is in progress, the sibling reports its progress in its
+// heartbeats and is not asked for storage proofs, since it cannot yet prove
+// storage of segments it does not have. Once it has rebuilt every segment, it
+// reports that it is done along with a storage proof for its slot, so that a
+// sibling can't end its rebuild without holding its segments. A sibling that
+// hasn't finished within RebuildWindow blocks of being placed is tossed, so
+// that a sibling can't avoid storage proofs by never finishing its rebuild.
+
+const (
+	// RebuildWindow is the number of blocks that a sibling has to rebuild
+	// the segments of its slot.
+	RebuildWindow = 200
+)
+
+// RebuildProgress is the progress that a rebuilding sibling reports in its
+// heartbeat. Rebuilt is the number of wallets whose segments have been
+// reconstructed so far, out of Total. Complete is set once the sibling holds
+// the segment of every wallet.
+type RebuildProgress struct {
+	Rebuilt  uint32
+	Total    uint32
+	Complete bool
+}
+
+// A Rebuild tracks the segments of a single sibling slot. Pending is set when
+// the segments held by the slot are lost, and Vacated is the height at which
+// that happened. Pending stays set until the sibling in the slot reports that
+// it has rebuilt all of its segments, and proves it. Started is the height at which the
+// sibling in the slot was placed and started rebuilding.
+type Rebuild struct {
+	Pending  bool
+	Vacated  uint32
+	Started  uint32
+	Progress RebuildProgress
+}
+
+// Rebuilding returns true if the sibling in slot i is currently rebuilding its
+// segments. Storage proofs are not required from a rebuilding sibling.
+func (m Metadata) Rebuilding(i byte) bool {
+	return m.Rebuilds[i].Pending && !m.Siblings[i].Inactive()
+}
+
+// RebuildExpired returns true if the sibling in slot i has been rebuilding
+// for more than RebuildWindow blocks.
+func (m Metadata) RebuildExpired(i byte) bool {
+	return m.Rebuilding(i) && m.Height > m.Rebuilds[i].Started+RebuildWindow
+}
+
+// VacateSlot marks the segments of slot i as lost. If the segments of the slot
+// were already being rebuilt, the original height is kept and the rebuild
+// starts over.
+func (s *State) VacateSlot(i byte) {
+	if !s.Metadata.Rebuilds[i].Pending {
+		s.Metadata.Rebuilds[i].Pending = true
+		s.Metadata.Rebuilds[i].Vacated = s.Metadata.Height
+	}
+	s.Metadata.Rebuilds[i].Progress = RebuildProgress{}
+}
+
+// ScheduleRebuild is called when a sibling is placed into slot i. If the
+// segments of the slot were lost, the new sibling starts rebuilding them from
+// scratch.
+func (s *State) ScheduleRebuild(i byte) {
+	if s.Metadata.Rebuilds[i].Pending {
+		s.Metadata.Rebuilds[i].Started = s.Metadata.Height
+		s.Metadata.Rebuilds[i].Progress = RebuildProgress{}
+	}
+}
+
+// ReportRebuild records the rebuild progress reported by the sibling in slot
+// i. The rebuild only ends if the sibling reports that it has finished, and
+// 'proof' is a valid storage proof for the slot. A sibling that claims to have
+// finished without a valid proof is still rebuilding. If the quorum isn't
+// storing any data, there is nothing to prove.
+func (s *State) ReportRebuild(i byte, progress RebuildProgress, proof StorageProof) {
+	if !s.Metadata.Rebuilding(i) {
+		return
+	}
+	if progress.Complete {
+		verified, err := s.VerifyStorageProof(i, proof)
+		if err == ErrEmptyQuorum || (err == nil && verified) {
+			s.Metadata.Rebuilds[i] = Rebuild{}
+			return
+		}
+		progress.Complete = false
+	}
+	s.Metadata.Rebuilds[i].Progress = progress
+}
@@ -0,0 +1,249 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/siaencoding"
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// The repair queue holds the wallets whose segments are missing from this
+// participant and need to be recovered from the other siblings. Repairs are
+// queued while a block is being compiled, so adding a repair never waits on
+// the participant that performs the repairs. The queue is saved to disk every
+// time it changes, so that repairs are not forgotten when the participant
+// restarts.
+//
+// A repair that fails is retried after a delay that doubles with every
+// attempt, up to RepairMaxDelay. After RepairFailureThreshold attempts the
+// repair is reported as failed, but it stays in the queue and keeps being
+// retried at the maximum delay.
+
+const (
+	// RepairBaseDelay is the delay before the first retry of a failed
+	// repair.
+	RepairBaseDelay = 2 * time.Second
+
+	// RepairMaxDelay is the longest delay between two attempts at the same
+	// repair.
+	RepairMaxDelay = 10 * time.Minute
+
+	// RepairFailureThreshold is the number of attempts after which a
+	// repair is reported as failed.
+	RepairFailureThreshold = 8
+)
+
+// A Repair is an entry in the repair queue. LastError is the error from the
+// most recent failed attempt.
+type Repair struct {
+	WalletID    WalletID
+	Attempts    uint32
+	NextAttempt time.Time
+	LastError   string
+
+	inProgress bool
+	requeued   bool
+}
+
+// Failed returns true if the repair has failed often enough to be reported
+// as failed.
+func (r Repair) Failed() bool {
+	return r.Attempts >= RepairFailureThreshold
+}
+
+// A RepairQueue is a durable, deduplicated set of pending repairs. It is safe
+// to use from multiple goroutines.
+type RepairQueue struct {
+	filename string
+	repairs  map[WalletID]*Repair
+	wake     chan struct{}
+	lock     sync.Mutex
+}
+
+// NewRepairQueue returns an empty repair queue that is only kept in memory
+// until Open is called.
+func NewRepairQueue() *RepairQueue {
+	return &RepairQueue{
+		repairs: make(map[WalletID]*Repair),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Open sets the file that the queue is saved to, loading any repairs that
+// were saved in the file by an earlier run.
+func (rq *RepairQueue) Open(filename string) (err error) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	rq.filename = filename
+	if !siafiles.Exists(filename) {
+		return
+	}
+
+	encodedRepairs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var repairs []Repair
+	err = siaencoding.Unmarshal(encodedRepairs, &repairs)
+	if err != nil {
+		return
+	}
+	for i := range repairs {
+		rq.repairs[repairs[i].WalletID] = &repairs[i]
+	}
+	rq.signal()
+	return
+}
+
+// signal wakes up whoever is waiting on the queue, without blocking if they
+// have already been woken up.
+func (rq *RepairQueue) signal() {
+	select {
+	case rq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// list returns the repairs in the queue, sorted by wallet id. list requires
+// the queue lock.
+func (rq *RepairQueue) list() (repairs []Repair) {
+	for _, r := range rq.repairs {
+		repairs = append(repairs, *r)
+	}
+	sort.Sort(repairsByID(repairs))
+	return
+}
+
+// save writes the queue to disk. save requires the queue lock.
+func (rq *RepairQueue) save() (err error) {
+	if rq.filename == "" {
+		return
+	}
+	encodedRepairs, err := siaencoding.Marshal(rq.list())
+	if err != nil {
+		return
+	}
+	tempFilename := rq.filename + ".tmp"
+	err = ioutil.WriteFile(tempFilename, encodedRepairs, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tempFilename, rq.filename)
+	return
+}
+
+// Add queues a repair for a wallet. If the wallet is already in the queue,
+// the existing repair is attempted again right away. If the repair is in
+// progress, it is attempted again once the current attempt has finished,
+// since the attempt may have started before the segment needed repairing
+// again.
+func (rq *RepairQueue) Add(id WalletID) (err error) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	r, exists := rq.repairs[id]
+	if !exists {
+		r = &Repair{WalletID: id}
+		rq.repairs[id] = r
+	}
+	r.requeued = r.inProgress
+	r.NextAttempt = time.Time{}
+	rq.signal()
+	err = rq.save()
+	return
+}
+
+// Wake returns a channel that receives a value whenever a repair is added.
+func (rq *RepairQueue) Wake() <-chan struct{} {
+	return rq.wake
+}
+
+// Due returns the repairs that should be attempted at time 'now', and marks
+// them as in progress. Each repair returned by Due must be followed by a
+// call to either Complete or Fail.
+func (rq *RepairQueue) Due(now time.Time) (ids []WalletID) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	for _, r := range rq.list() {
+		repair := rq.repairs[r.WalletID]
+		if repair.inProgress || now.Before(repair.NextAttempt) {
+			continue
+		}
+		repair.inProgress = true
+		ids = append(ids, r.WalletID)
+	}
+	return
+}
+
+// Complete removes a finished repair from the queue, unless the repair was
+// added again while it was in progress, in which case it is due again right
+// away.
+func (rq *RepairQueue) Complete(id WalletID) (err error) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	r, exists := rq.repairs[id]
+	if !exists {
+		return
+	}
+	if r.requeued {
+		*r = Repair{WalletID: id}
+		rq.signal()
+	} else {
+		delete(rq.repairs, id)
+	}
+	err = rq.save()
+	return
+}
+
+// Fail records a failed attempt at a repair and schedules the next attempt.
+// A repair that was added again while it was in progress is due again right
+// away.
+func (rq *RepairQueue) Fail(id WalletID, repairErr error) (err error) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	r, exists := rq.repairs[id]
+	if !exists {
+		return
+	}
+	r.inProgress = false
+	r.Attempts++
+	r.LastError = repairErr.Error()
+	if r.requeued {
+		r.requeued = false
+		rq.signal()
+		err = rq.save()
+		return
+	}
+	delay := RepairMaxDelay
+	if r.Attempts < 32 && RepairBaseDelay<<(r.Attempts-1) < RepairMaxDelay {
+		delay = RepairBaseDelay << (r.Attempts - 1)
+	}
+	r.NextAttempt = time.Now().Add(delay)
+	err = rq.save()
+	return
+}
+
+// Status returns the repairs in the queue, split into the ones that are still
+// pending and the ones that have failed.
+func (rq *RepairQueue) Status() (pending []Repair, failed []Repair) {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	for _, r := range rq.list() {
+		if r.Failed() {
+			failed = append(failed, r)
+		} else {
+			pending = append(pending, r)
+		}
+	}
+	return
+}
+
+// repairsByID sorts a list of repairs by wallet id.
+type repairsByID []Repair
+
+func (r repairsByID) Len() int           { return len(r) }
+func (r repairsByID) Less(i, j int) bool { return r[i].WalletID < r[j].WalletID }
+func (r repairsByID) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
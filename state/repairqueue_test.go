@@ -0,0 +1,104 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// TestRepairQueue checks deduplication, backoff, failure reporting, and that
+// the queue survives being reopened.
+func TestRepairQueue(t *testing.T) {
+	// Start from an empty file, since the queue loads whatever an earlier
+	// run of the test left behind.
+	filename := siafiles.TempFilename("TestRepairQueue")
+	siafiles.Remove(filename)
+	rq := NewRepairQueue()
+	err := rq.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the same wallet twice should only queue one repair.
+	for _, id := range []WalletID{2, 1, 2} {
+		err = rq.Add(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-rq.Wake():
+	default:
+		t.Error("adding a repair did not wake the queue")
+	}
+	due := rq.Due(time.Now())
+	if len(due) != 2 || due[0] != 1 || due[1] != 2 {
+		t.Fatal("expecting repairs for wallets 1 and 2, got", due)
+	}
+	if len(rq.Due(time.Now())) != 0 {
+		t.Error("repairs in progress were returned again")
+	}
+
+	// A repair that is added again while it is in progress must survive
+	// the attempt that was already running.
+	err = rq.Add(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rq.Complete(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	due = rq.Due(time.Now())
+	if len(due) != 1 || due[0] != 1 {
+		t.Fatal("repair added during an attempt was lost when the attempt completed:", due)
+	}
+
+	// Complete one repair and fail the other.
+	err = rq.Complete(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rq.Fail(2, errors.New("no siblings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rq.Due(time.Now())) != 0 {
+		t.Error("failed repair was retried before its backoff")
+	}
+	due = rq.Due(time.Now().Add(RepairBaseDelay))
+	if len(due) != 1 || due[0] != 2 {
+		t.Fatal("failed repair was not retried after its backoff")
+	}
+
+	// Keep failing until the repair is reported as failed. The delay
+	// should never exceed the maximum.
+	for i := 1; i < RepairFailureThreshold; i++ {
+		err = rq.Fail(2, errors.New("no siblings"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rq.Due(time.Now().Add(RepairMaxDelay))
+	}
+	pending, failed := rq.Status()
+	if len(pending) != 0 || len(failed) != 1 || failed[0].LastError != "no siblings" {
+		t.Fatal("expecting one failed repair, got", pending, failed)
+	}
+
+	// Reopen the queue; the failed repair should still be there.
+	reopened := NewRepairQueue()
+	err = reopened.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, failed = reopened.Status()
+	if len(failed) != 1 || failed[0].WalletID != 2 {
+		t.Error("failed repair was forgotten after reopening the queue")
+	}
+	due = reopened.Due(time.Now().Add(RepairMaxDelay))
+	if len(due) != 1 {
+		t.Error("failed repair is not retried after reopening the queue")
+	}
+}
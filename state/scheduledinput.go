@@ -0,0 +1,156 @@
+package state
+
+import (
+	"errors"
+)
+
+// A wallet can schedule an input to its own script to be run at a future
+// height, once or at a fixed period. Scheduled inputs are events, and are
+// kept in the wallet as well as in the event list so that the event list can
+// be rebuilt from a snapshot. When the event fires, the input is put into a
+// queue that the delta package runs after the expiring events have been
+// processed, see TakeScheduledInputs.
+
+const (
+	// MaxScheduledInputs is the number of inputs that a wallet can have
+	// scheduled at once.
+	MaxScheduledInputs = 8
+
+	// MaxScheduleRepeats is the number of times that a scheduled input can
+	// be run again after it is first run.
+	MaxScheduleRepeats = 1000
+)
+
+var (
+	errScheduleHeight   = errors.New("scheduled input must run at a height between the next block and MaxDeadline blocks from now")
+	errSchedulePeriod   = errors.New("recurring scheduled input must have a period between 1 and MaxDeadline")
+	errScheduleRepeats  = errors.New("scheduled input repeats more than MaxScheduleRepeats times")
+	errTooManySchedules = errors.New("wallet already has the max number of scheduled inputs")
+)
+
+// A ScheduledInput is an input to a wallet's own script that is run when the
+// block at Height is compiled. If Repeats is not zero, the input is run again
+// every Period blocks, Repeats more times.
+type ScheduledInput struct {
+	Height    uint32
+	Period    uint32
+	Repeats   uint32
+	CostLimit uint32
+	Input     []byte
+}
+
+// Runs returns the number of times that the input will be run.
+func (si ScheduledInput) Runs() uint32 {
+	return si.Repeats + 1
+}
+
+// A ScheduledInputEvent is the event that runs a scheduled input. Index tells
+// the scheduled inputs of a wallet apart.
+type ScheduledInputEvent struct {
+	WalletID     WalletID
+	Index        uint32
+	EventCounter uint32
+	Schedule     ScheduledInput
+}
+
+// Counter returns the event counter of the event.
+func (sie *ScheduledInputEvent) Counter() uint32 {
+	return sie.EventCounter
+}
+
+// Expiration returns the height that the event fires after. Events fire once
+// the height of the quorum is past their expiration, so a scheduled input
+// expires one block before the block it runs in.
+func (sie *ScheduledInputEvent) Expiration() uint32 {
+	return sie.Schedule.Height - 1
+}
+
+// HandleEvent queues the input to be run, and schedules the next run if the
+// input repeats.
+func (sie *ScheduledInputEvent) HandleEvent(s *State) (err error) {
+	w, err := s.LoadWallet(sie.WalletID)
+	if err != nil {
+		return
+	}
+	i := w.scheduledInput(sie.Index)
+	if i < 0 {
+		err = errors.New("could not find scheduled input of given index")
+		return
+	}
+
+	s.scheduled = append(s.scheduled, ScriptInput{
+		Deadline:  s.Metadata.Height,
+		Input:     sie.Schedule.Input,
+		WalletID:  sie.WalletID,
+		CostLimit: sie.Schedule.CostLimit,
+	})
+
+	if sie.Schedule.Repeats == 0 {
+		w.ScheduledInputs = append(w.ScheduledInputs[:i], w.ScheduledInputs[i+1:]...)
+	} else {
+		next := *sie
+		next.Schedule.Height += next.Schedule.Period
+		next.Schedule.Repeats--
+		s.InsertEvent(&next, true)
+		w.ScheduledInputs[i] = next
+	}
+
+	err = s.SaveWallet(w)
+	return
+}
+
+// SetCounter sets the event counter of the event.
+func (sie *ScheduledInputEvent) SetCounter(counter uint32) {
+	sie.EventCounter = counter
+}
+
+// scheduledInput returns the position of the scheduled input with index
+// 'index' in the wallet's scheduled inputs, or -1 if there isn't one.
+func (w *Wallet) scheduledInput(index uint32) int {
+	for i := range w.ScheduledInputs {
+		if w.ScheduledInputs[i].Index == index {
+			return i
+		}
+	}
+	return -1
+}
+
+// addScheduledInput checks that a scheduled input is legal and adds it to
+// the scheduled inputs of a wallet, returning the event of the input. The
+// event still needs a counter, and needs to be put into the event list.
+func (md *Metadata) addScheduledInput(w *Wallet, si ScheduledInput) (sie ScheduledInputEvent, err error) {
+	if si.Height <= md.Height || si.Height > md.Height+MaxDeadline {
+		err = errScheduleHeight
+		return
+	}
+	if si.Repeats > 0 && (si.Period == 0 || si.Period > MaxDeadline) {
+		err = errSchedulePeriod
+		return
+	}
+	if si.Repeats > MaxScheduleRepeats {
+		err = errScheduleRepeats
+		return
+	}
+	if len(w.ScheduledInputs) >= MaxScheduledInputs {
+		err = errTooManySchedules
+		return
+	}
+
+	sie = ScheduledInputEvent{
+		WalletID: w.ID,
+		Schedule: si,
+	}
+	if len(w.ScheduledInputs) > 0 {
+		sie.Index = w.ScheduledInputs[len(w.ScheduledInputs)-1].Index + 1
+	}
+	w.ScheduledInputs = append(w.ScheduledInputs, sie)
+	return
+}
+
+// TakeScheduledInputs returns the scheduled inputs whose events have fired,
+// and empties the queue.
+func (s *State) TakeScheduledInputs() (inputs []ScriptInput) {
+	inputs = s.scheduled
+	s.scheduled = nil
+	return
+}
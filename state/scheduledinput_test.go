@@ -0,0 +1,117 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/siafiles"
+)
+
+// TestScheduledInputs schedules a recurring input, checking the limits on
+// scheduling and that the input is queued at every scheduled height.
+func TestScheduledInputs(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.SetWalletPrefix(siafiles.TempFilename("TestScheduledInputs"))
+	err := s.InsertWallet(Wallet{ID: 1}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := s.NewOverlay()
+	w, err := o.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: 0})
+	if err != errScheduleHeight {
+		t.Error("expecting errScheduleHeight, got", err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: MaxDeadline + 1})
+	if err != errScheduleHeight {
+		t.Error("expecting errScheduleHeight, got", err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: 1, Repeats: 1})
+	if err != errSchedulePeriod {
+		t.Error("expecting errSchedulePeriod, got", err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: 1, Period: 1, Repeats: MaxScheduleRepeats + 1})
+	if err != errScheduleRepeats {
+		t.Error("expecting errScheduleRepeats, got", err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: 2, Period: 3, Repeats: 1, Input: []byte{0xFF}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The input runs at heights 2 and 5, and is then removed from the
+	// wallet.
+	for height := uint32(1); height <= 6; height++ {
+		s.Metadata.Height = height
+		s.ProcessExpiringEvents()
+		inputs := s.TakeScheduledInputs()
+		if (height == 2 || height == 5) != (len(inputs) == 1) {
+			t.Fatal("unexpected number of inputs at height", height, ":", len(inputs))
+		}
+		if len(inputs) == 1 && (inputs[0].WalletID != 1 || inputs[0].Deadline != height) {
+			t.Error("queued input does not match the schedule:", inputs[0])
+		}
+	}
+	w, err = s.LoadWallet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.ScheduledInputs) != 0 || s.eventRoot != nil {
+		t.Error("scheduled input was not removed after its last run")
+	}
+}
+
+// TestScheduleNewWallet creates a wallet and schedules an input to it in the
+// same overlay, checking that the input's event is only put into the event
+// list once.
+func TestScheduleNewWallet(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.SetWalletPrefix(siafiles.TempFilename("TestScheduleNewWallet"))
+
+	o := s.NewOverlay()
+	w := Wallet{ID: 1}
+	err := o.InsertWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.InsertScheduledInput(&w, ScheduledInput{Height: 2, Input: []byte{0xFF}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.SaveWallet(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Metadata.EventCounter != 1 {
+		t.Error("expecting 1 event to have been counted, got", s.Metadata.EventCounter)
+	}
+
+	for height := uint32(1); height <= 3; height++ {
+		s.Metadata.Height = height
+		s.ProcessExpiringEvents()
+		inputs := s.TakeScheduledInputs()
+		if (height == 2) != (len(inputs) == 1) {
+			t.Fatal("unexpected number of inputs at height", height, ":", len(inputs))
+		}
+	}
+	if s.eventRoot != nil {
+		t.Error("scheduled input was left in the event list")
+	}
+}
@@ -8,10 +8,20 @@ import (
 // A ScriptInput pairs an input byte slice with the WalletID associated with
 // the recipient. During execution, the WalletID is used to load the script
 // body, and then the Input is appended to the end of the script.
+//
+// CostLimit caps the cost units that the script can use, and with them the
+// amount that the wallet can be charged for the run. A CostLimit of 0 uses
+// the default limit of the interpreter.
+//
+// Fee is paid by the wallet to the siblings of the quorum when the input is
+// run, unless the script rejects the input. Blocks have a limited cost budget,
+// and the inputs that pay the most per unit of CostLimit are run first.
 type ScriptInput struct {
-	Deadline uint32
-	Input    []byte
-	WalletID WalletID
+	Deadline  uint32
+	Input     []byte
+	WalletID  WalletID
+	CostLimit uint32
+	Fee       Balance
 }
 
 // ScriptInputEvent contains all the information needed by the event list to
@@ -18,6 +18,7 @@ const (
 	MinK             = 1
 
 	StandardK = 2
+	StandardD = 3
 )
 
 // Sector contains all the information about the sector of a wallet,
@@ -33,7 +34,9 @@ type Sector struct {
 
 	// The minimum number of siblings in the quorum that need to remain
 	// uncorrupted in order for other pieces to be recoverable without using a
-	// large amount of bandwidth.
+	// large amount of bandwidth. Setting D selects the regenerating code,
+	// see Regenerating, and a D of 0 keeps the sector on Reed-Solomon
+	// coding.
 	D byte
 
 	// The hash of the hash set of the sector, where hash set is defined as an
@@ -113,6 +113,21 @@ func (su *SectorUpdate) Hash() siacrypto.Hash {
 
 // InsertSectorUpdate adds an update to a wallet, and to the event list.
 func (s *State) InsertSectorUpdate(w *Wallet, su SectorUpdate) (err error) {
+	su, err = s.Metadata.addSectorUpdate(w, su)
+	if err != nil {
+		return
+	}
+
+	// Create the event and put it into the event list.
+	s.InsertEvent(&su.Event, true)
+
+	return
+}
+
+// addSectorUpdate checks that an update is legal and appends it to the active
+// updates of a wallet, returning the update with its event filled out. The
+// event still needs to be put into the event list.
+func (md *Metadata) addSectorUpdate(w *Wallet, su SectorUpdate) (newUpdate SectorUpdate, err error) {
 	// Check that the values in the update are legal.
 	if su.Atoms > AtomsPerSector {
 		err = errors.New("Sector allocates too many atoms")
@@ -126,6 +141,10 @@ func (s *State) InsertSectorUpdate(w *Wallet, su SectorUpdate) (err error) {
 		err = errors.New("Sector has K below the Min K")
 		return
 	}
+	err = checkCode(su.K, su.D)
+	if err != nil {
+		return
+	}
 	if su.ConfirmationsRequired < MinConfirmations {
 		err = errors.New("Confirmations required must be at least K!")
 		return
@@ -138,7 +157,7 @@ func (s *State) InsertSectorUpdate(w *Wallet, su SectorUpdate) (err error) {
 	}
 
 	// Check that the deadline is in bounds.
-	if su.Event.Deadline > s.Metadata.Height+MaxDeadline {
+	if su.Event.Deadline > md.Height+MaxDeadline {
 		err = errors.New("deadline too far in the future")
 		return
 	}
@@ -153,9 +172,6 @@ func (s *State) InsertSectorUpdate(w *Wallet, su SectorUpdate) (err error) {
 
 	// Append the update to the list of active updates.
 	w.Sector.ActiveUpdates = append(w.Sector.ActiveUpdates, su)
-
-	// Create the event and put it into the event list.
-	s.InsertEvent(&su.Event, true)
-
+	newUpdate = su
 	return
 }
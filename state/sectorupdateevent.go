@@ -57,7 +57,10 @@ func (sue *SectorUpdateEvent) HandleEvent(s *State) (err error) {
 		// Copy the file from the update to the file for the sector.
 		filename := s.SectorUpdateFilename(sue.WalletID, sue.UpdateIndex)
 		if !siafiles.Exists(filename) {
-			s.RepairChan <- sue.WalletID
+			repairErr := s.Repairs.Add(sue.WalletID)
+			if repairErr != nil {
+				s.log.Error("failed to save repair queue:", repairErr)
+			}
 		} else {
 			siafiles.Copy(s.SectorFilename(sue.WalletID), filename)
 			siafiles.Remove(filename)
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"errors"
+
 	"github.com/NebulousLabs/Sia/network"
 	"github.com/NebulousLabs/Sia/siacrypto"
 )
@@ -9,6 +11,31 @@ const (
 	// SiblingPassiveWindow is the number of blocks that a sibling is
 	// allowed to be passive.
 	SiblingPassiveWindow = 3
+
+	// SiblingForkAllowance is the number of times that a sibling can be
+	// caught heartbeating on the wrong parent block before it is tossed
+	// instead of demoted.
+	SiblingForkAllowance = 2
+
+	// MaxHopefuls is the maximum number of participants that can be
+	// waiting to join the quorum at once.
+	MaxHopefuls = QuorumSize
+
+	// HopefulLifetime is the number of blocks that a hopeful will wait for
+	// an empty sibling slot before being dropped from the hopeful list.
+	HopefulLifetime = 10
+
+	// SiblingDepartureWindow is the number of blocks between a sibling
+	// announcing that it is leaving and the sibling leaving the quorum.
+	// During this time, a successor is recruited from the hopeful list.
+	SiblingDepartureWindow = 3
+)
+
+var (
+	errBadAddressUpdate   = errors.New("address update does not name an active or passive sibling")
+	errEmptyAddress       = errors.New("address update has an empty hostname")
+	errStaleAddressUpdate = errors.New("address update is older than the sibling's current address")
+	errUnsignedAddress    = errors.New("address update is not signed by the sibling")
 )
 
 // A Sibling is the public facing information of participants on the quorum.
@@ -22,12 +49,68 @@ const (
 // Passive sibling will not be included in compensation. An active sibling is a
 // full sibing that _must_ participate in consensus and provide updates to the
 // network.
+//
+// JoinHeight is the height of the block during which the sibling was placed
+// into the quorum. The first block in which a new sibling is active is its
+// 'handoff' block, see HandoffBlock.
+//
+// ForkStrikes counts the number of times that the sibling has been demoted for
+// submitting a heartbeat that built on a different parent block than the rest
+// of the quorum.
+//
+// Departing is the number of blocks until a sibling that has asked to leave
+// the quorum is removed, or 0 if the sibling is not leaving. A sibling that
+// leaves gracefully gets its Collateral back, while a sibling that is tossed
+// forfeits it.
+//
+// AddressHeight is the height at which the sibling's current Address was
+// signed, see AddressUpdate.
 type Sibling struct {
-	Status    byte
-	Index     byte
-	Address   network.Address
-	PublicKey siacrypto.PublicKey
-	WalletID  WalletID
+	Status        byte
+	ForkStrikes   byte
+	Departing     byte
+	JoinHeight    uint32
+	Index         byte
+	Address       network.Address
+	AddressHeight uint32
+	PublicKey     siacrypto.PublicKey
+	WalletID      WalletID
+	Collateral    Balance
+}
+
+// An AddressUpdate moves a sibling to a new address, which lets a sibling
+// whose IP changes stay reachable by the rest of the quorum. The update is
+// signed by the sibling, and can be submitted either in the sibling's
+// heartbeat or through a ScriptInput. Height is the height of the quorum when
+// the update was signed, and an update is only accepted if it is more recent
+// than the update that set the sibling's current address. This keeps an old
+// update, or another update signed at the same height, from being replayed to
+// move the sibling back to an address that it has abandoned.
+type AddressUpdate struct {
+	SiblingIndex byte
+	Address      network.Address
+	Height       uint32
+}
+
+// A SignedAddressUpdate is an AddressUpdate with the signature of the
+// sibling.
+type SignedAddressUpdate struct {
+	AddressUpdate AddressUpdate
+	Signature     siacrypto.Signature
+}
+
+// A Hopeful is a participant that has asked to join the quorum but has not yet
+// been placed into a sibling slot. Hopefuls are dropped from the list if they
+// have not been placed by their Deadline.
+type Hopeful struct {
+	Sibling  Sibling
+	Deadline uint32
+}
+
+// Empty returns true if there is no hopeful in this position of the hopeful
+// list.
+func (h Hopeful) Empty() bool {
+	return h.Deadline == 0
 }
 
 // Active returns true if the sibling is a fully active member of the quorum
@@ -43,9 +126,75 @@ func (sib Sibling) Inactive() bool {
 	return sib.Status == ^byte(0)
 }
 
-// TossSibling removes a sibling from the list of siblings.
+// IsDeparting returns true if the sibling has asked to leave the quorum.
+func (sib Sibling) IsDeparting() bool {
+	return !sib.Inactive() && sib.Departing != 0
+}
+
+// HandoffBlock returns true if the block at 'height' is the first block in
+// which a newly placed sibling is active. During the handoff block the sibling
+// may not have finished synchronizing, and so the quorum will use a default
+// heartbeat for the sibling if its heartbeat is missing.
+func (sib Sibling) HandoffBlock(height uint32) bool {
+	return sib.Active() && height == sib.JoinHeight+SiblingPassiveWindow+1
+}
+
+// UpdateAddress checks a signed address update against the public key of the
+// sibling it names, and moves the sibling to the new address.
+func (s *State) UpdateAddress(sau SignedAddressUpdate) (err error) {
+	return s.Metadata.updateAddress(sau)
+}
+
+// updateAddress applies a signed address update to the siblings of the
+// metadata, see UpdateAddress.
+func (md *Metadata) updateAddress(sau SignedAddressUpdate) (err error) {
+	au := sau.AddressUpdate
+	if au.SiblingIndex >= QuorumSize || md.Siblings[au.SiblingIndex].Inactive() {
+		err = errBadAddressUpdate
+		return
+	}
+	if au.Address.Host == "" {
+		err = errEmptyAddress
+		return
+	}
+	sib := &md.Siblings[au.SiblingIndex]
+	if au.Height <= sib.AddressHeight || au.Height > md.Height {
+		err = errStaleAddressUpdate
+		return
+	}
+	verified, err := sib.PublicKey.VerifyObject(sau.Signature, au)
+	if err != nil {
+		return
+	}
+	if !verified {
+		err = errUnsignedAddress
+		return
+	}
+
+	sib.Address = au.Address
+	sib.AddressHeight = au.Height
+	return
+}
+
+// TossSibling removes a sibling from the list of siblings. The segments that
+// the sibling was holding are lost, so the slot is marked for a rebuild.
 func (s *State) TossSibling(i byte) {
 	s.Metadata.Siblings[i] = Sibling{
 		Status: 255,
 	}
+	s.VacateSlot(i)
+}
+
+// DemoteSibling is called when a sibling submits a heartbeat that builds on
+// the wrong parent block. Rather than tossing the sibling immediately, the
+// sibling is made passive for SiblingPassiveWindow blocks, which gives it time
+// to notice the fork, roll back, and catch up to the quorum. A sibling that
+// keeps forking is tossed once it runs out of strikes.
+func (s *State) DemoteSibling(i byte) {
+	if s.Metadata.Siblings[i].ForkStrikes >= SiblingForkAllowance {
+		s.TossSibling(i)
+		return
+	}
+	s.Metadata.Siblings[i].ForkStrikes++
+	s.Metadata.Siblings[i].Status = SiblingPassiveWindow
 }
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/network"
+	"github.com/NebulousLabs/Sia/siacrypto"
+)
+
+// TestDemoteSibling checks that a sibling is made passive when demoted, and
+// that it gets tossed once it has used up its strikes.
+func TestDemoteSibling(t *testing.T) {
+	var s State
+	s.Initialize()
+	s.Metadata.Siblings[1].Status = 0
+	s.Metadata.Siblings[1].WalletID = 5
+
+	for i := 0; i < SiblingForkAllowance; i++ {
+		s.DemoteSibling(1)
+		if s.Metadata.Siblings[1].Status != SiblingPassiveWindow {
+			t.Fatal("demoted sibling is not passive:", s.Metadata.Siblings[1].Status)
+		}
+		if s.Metadata.Siblings[1].ForkStrikes != byte(i+1) {
+			t.Error("expecting", i+1, "strikes, got", s.Metadata.Siblings[1].ForkStrikes)
+		}
+		s.Metadata.Siblings[1].Status = 0
+	}
+
+	s.DemoteSibling(1)
+	if !s.Metadata.Siblings[1].Inactive() || s.Metadata.Siblings[1].WalletID != 0 {
+		t.Error("sibling was not tossed after running out of strikes")
+	}
+}
+
+// TestUpdateAddress checks that a sibling's address is only updated by
+// recent updates signed by the sibling.
+func TestUpdateAddress(t *testing.T) {
+	pk, sk, err := siacrypto.CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s State
+	s.Initialize()
+	s.Metadata.Height = 10
+	s.Metadata.Siblings[1].Status = 0
+	s.Metadata.Siblings[1].PublicKey = pk
+	s.Metadata.Siblings[1].AddressHeight = 5
+
+	sign := func(au AddressUpdate) SignedAddressUpdate {
+		signature, err := sk.SignObject(au)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SignedAddressUpdate{au, signature}
+	}
+	newAddress := network.Address{Host: "new", Port: 9988, ID: 1}
+	tests := []struct {
+		sau SignedAddressUpdate
+		err error
+	}{
+		{sign(AddressUpdate{0, newAddress, 10}), errBadAddressUpdate},
+		{sign(AddressUpdate{QuorumSize, newAddress, 10}), errBadAddressUpdate},
+		{sign(AddressUpdate{1, network.Address{}, 10}), errEmptyAddress},
+		{sign(AddressUpdate{1, newAddress, 4}), errStaleAddressUpdate},
+		{sign(AddressUpdate{1, newAddress, 5}), errStaleAddressUpdate},
+		{sign(AddressUpdate{1, newAddress, 11}), errStaleAddressUpdate},
+		{SignedAddressUpdate{AddressUpdate: AddressUpdate{1, newAddress, 10}}, errUnsignedAddress},
+	}
+	for i, test := range tests {
+		err = s.UpdateAddress(test.sau)
+		if err != test.err {
+			t.Errorf("update %v: expecting %v, got %v", i, test.err, err)
+		}
+	}
+	if s.Metadata.Siblings[1].Address != (network.Address{}) {
+		t.Fatal("rejected update changed the address")
+	}
+
+	err = s.UpdateAddress(sign(AddressUpdate{1, newAddress, 7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Metadata.Siblings[1].Address != newAddress || s.Metadata.Siblings[1].AddressHeight != 7 {
+		t.Error("sibling was not moved to the new address")
+	}
+}
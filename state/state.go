@@ -11,6 +11,13 @@ const (
 	// AtomsPerQuorum is the maximum number of atoms that can be stored on
 	// a single quorum.
 	AtomsPerQuorum int = 16777216
+
+	// ScriptPrice is the price of a unit of script cost in every quorum.
+	// There is no way yet for a quorum to agree on a different price, so
+	// the price is set when the state is initialized and never changes.
+	// Changing it splits the network, since siblings with different prices
+	// charge scripts differently.
+	ScriptPrice uint64 = 1
 )
 
 // The State struct contains all of the information about the current state of
@@ -32,8 +39,11 @@ type State struct {
 	// Points to the skip list that contains all of the events.
 	eventRoot *eventNode
 
-	// Communicating with consensus for repairing files.
-	RepairChan chan WalletID
+	// The scheduled inputs whose events have fired, waiting to be run.
+	scheduled []ScriptInput
+
+	// The segments that need to be repaired by the participant.
+	Repairs *RepairQueue
 
 	log *sialog.Logger
 }
@@ -62,12 +72,25 @@ func (s *State) SetWalletPrefix(walletPrefix string) {
 }
 
 // Initialize puts the state in the default configuration, initializing the
-// repair channel, setting all of the siblings to inactive, and setting the
-// default storage price.
+// repair queue, setting all of the siblings to inactive, and setting the
+// default storage and script prices. Nobody holds the segments of an empty
+// slot, so every slot starts out needing a rebuild.
 func (s *State) Initialize() {
 	for i := range s.Metadata.Siblings {
 		s.Metadata.Siblings[i].Status = ^byte(0)
+		s.Metadata.Rebuilds[i].Pending = true
 	}
-	s.RepairChan = make(chan WalletID)
+	s.Repairs = NewRepairQueue()
 	s.Metadata.StoragePrice = NewBalance(1)
+	s.Metadata.ScriptPrice = NewBalance(ScriptPrice)
+}
+
+// ClearWallets removes every wallet and every event from the state, leaving
+// the metadata untouched. It is used when the state needs to be rebuilt from a
+// snapshot, for example when recovering from a fork.
+func (s *State) ClearWallets() {
+	for _, id := range s.WalletList() {
+		s.RemoveWallet(id)
+	}
+	s.eventRoot = nil
 }
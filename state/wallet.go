@@ -16,19 +16,25 @@ const (
 	walletAtomMultiplier = 3
 )
 
+var (
+	errWalletExists = errors.New("wallet of that id already exists in quorum")
+)
+
 // A WalletID is a unique identifier that references a Wallet on the network.
 type WalletID uint64
 
 // A Wallet performs three important duties. It contains a Balance, allowing
 // for transactions; a Sector object which manages what storage is
 // associated with the Wallet; and a Script, which can receive inputs and
-// perform actions.
+// perform actions. ScheduledInputs holds the inputs that the script has
+// scheduled for itself.
 type Wallet struct {
-	ID           WalletID
-	Balance      Balance
-	Sector       Sector
-	Script       []byte
-	KnownScripts map[string]ScriptInputEvent
+	ID              WalletID
+	Balance         Balance
+	Sector          Sector
+	Script          []byte
+	KnownScripts    map[string]ScriptInputEvent
+	ScheduledInputs []ScheduledInputEvent
 }
 
 // Bytes returns the WalletID as a byte slice.
@@ -87,17 +93,11 @@ func (s *State) walletFilename(id WalletID) (filename string) {
 // New wallets will have certain values automatically set, where non-new
 // wallets will not have any values be automatically set.
 func (s *State) InsertWallet(w Wallet, newWallet bool) (err error) {
-	wn := s.walletNode(w.ID)
-	if wn != nil {
-		err = errors.New("wallet of that id already exists in quorum")
+	err = s.addWalletNode(w)
+	if err != nil {
 		return
 	}
 
-	wn = new(walletNode)
-	wn.id = w.ID
-	wn.weight = int(w.Sector.Atoms)
-	s.insertWalletNode(wn)
-
 	if w.KnownScripts == nil {
 		w.KnownScripts = make(map[string]ScriptInputEvent)
 	} else {
@@ -114,10 +114,30 @@ func (s *State) InsertWallet(w Wallet, newWallet bool) (err error) {
 		}
 	}
 
+	for i := range w.ScheduledInputs {
+		sie := w.ScheduledInputs[i]
+		s.InsertEvent(&sie, newWallet)
+		w.ScheduledInputs[i] = sie
+	}
+
 	s.SaveWallet(w)
 	return
 }
 
+// addWalletNode puts a wallet into the wallet tree, without saving the wallet
+// or putting its events into the event list.
+func (s *State) addWalletNode(w Wallet) (err error) {
+	if s.walletNode(w.ID) != nil {
+		err = errWalletExists
+		return
+	}
+	wn := new(walletNode)
+	wn.id = w.ID
+	wn.weight = int(w.Sector.Atoms)
+	s.insertWalletNode(wn)
+	return
+}
+
 // LoadWallet checks the wallettree for existence of the wallet, and then loads
 // the wallet from disk if the wallet exists.
 func (s *State) LoadWallet(id WalletID) (w Wallet, err error) {